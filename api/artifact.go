@@ -0,0 +1,123 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// artifactMaxRequestSize bounds the uploads before the storage's own limits are checked.
+const artifactMaxRequestSize = 16 << 20
+
+var artifactNameRe = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,63}$`)
+
+// artifactTypes holds the MIME types of extensions commonly used for payloads that may
+// not be known by the system's MIME types table.
+var artifactTypes = map[string]string{
+	".dtd": "application/xml-dtd",
+	".xsl": "application/xml",
+	".ent": "application/xml-external-parsed-entity",
+}
+
+// setArtifact stores the request's body as the authorized test's artifact named by the
+// URL. The artifact's MIME type is guessed from its name's extension, falling back to
+// the request's Content-Type and then to sniffing its content.
+func (env *env) setArtifact(w http.ResponseWriter, r *http.Request) {
+	id, ok := r.Context().Value(idCtxKey).(string)
+	if !ok || id == "" {
+		log.Info("API /artifacts could not get authorization context keys from context")
+		render.Render(w, r, errUnauthorized(errors.New("internal authentication error")))
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	if !artifactNameRe.MatchString(name) {
+		err := errors.New("artifact names must be up to 64 letters, digits, '.', '_' or '-' and not start with '.'")
+		render.Render(w, r, errBadRequest(err))
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, artifactMaxRequestSize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		render.Render(w, r, errRequestEntityTooLarge(app.ErrArtifactLimit))
+		return
+	} else if err != nil {
+		log.Debug("API /artifacts read error: %v", err)
+		render.Render(w, r, errBadRequest(errors.New("could not read the artifact")))
+		return
+	}
+
+	a := app.Artifact{
+		Name:        name,
+		ContentType: artifactType(name, r.Header.Get("Content-Type"), data),
+		Data:        data,
+	}
+	if err := env.strg.SetArtifact(id, a); errors.Is(err, app.ErrArtifactLimit) {
+		render.Render(w, r, errRequestEntityTooLarge(err))
+		return
+	} else if err != nil {
+		log.Debug("API /artifacts set artifact error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not store the artifact")))
+		return
+	}
+	render.Render(w, r, &artifactResponse{ID: id, Name: name, ContentType: a.ContentType, Size: len(data)})
+}
+
+// deleteArtifact deletes the authorized test's artifact named by the URL.
+func (env *env) deleteArtifact(w http.ResponseWriter, r *http.Request) {
+	id, ok := r.Context().Value(idCtxKey).(string)
+	if !ok || id == "" {
+		log.Info("API /artifacts could not get authorization context keys from context")
+		render.Render(w, r, errUnauthorized(errors.New("internal authentication error")))
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	deleted, err := env.strg.DeleteArtifact(id, name)
+	if err != nil {
+		log.Debug("API /artifacts delete artifact error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not delete the artifact")))
+		return
+	}
+	if !deleted {
+		render.Render(w, r, errNotFound(errors.New("artifact not found")))
+		return
+	}
+	render.Render(w, r, &artifactResponse{ID: id, Name: name})
+}
+
+func artifactType(name, reqType string, data []byte) string {
+	ext := path.Ext(name)
+	if t, exists := artifactTypes[ext]; exists {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	// curl sends this type by default, so it's not a good hint of the actual type.
+	if reqType != "" && reqType != "application/x-www-form-urlencoded" {
+		return reqType
+	}
+	return http.DetectContentType(data)
+}
+
+type artifactResponse struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int    `json:"size,omitempty"`
+}
+
+func (res *artifactResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
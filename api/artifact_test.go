@@ -0,0 +1,124 @@
+package api_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/api"
+)
+
+type artifactMockStorage struct {
+	mockStorage
+	artifacts map[string]app.Artifact
+	maxSize   int
+}
+
+func (s *artifactMockStorage) SetArtifact(id string, a app.Artifact) error {
+	if len(a.Data) > s.maxSize {
+		return app.ErrArtifactLimit
+	}
+	s.artifacts[a.Name] = a
+	return nil
+}
+
+func (s *artifactMockStorage) DeleteArtifact(id, name string) (bool, error) {
+	_, deleted := s.artifacts[name]
+	delete(s.artifacts, name)
+	return deleted, nil
+}
+
+func newArtifactMockStorage() *artifactMockStorage {
+	return &artifactMockStorage{artifacts: map[string]app.Artifact{}, maxSize: 1024}
+}
+
+func newArtifactRequest(method, name string, data []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, "/artifacts/"+name, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Secret %s", tB64TestSecret))
+	return req, nil
+}
+
+func TestSetArtifact(t *testing.T) {
+	tests := []struct {
+		name, reqType, wantType string
+		data                    []byte
+	}{
+		{"evil.dtd", "application/x-www-form-urlencoded", "application/xml-dtd", []byte("<!ENTITY")},
+		{"x.svg", "", "image/svg+xml", []byte("<svg/>")},
+		{"payload", "text/javascript", "text/javascript", []byte("alert(1)")},
+		{"page", "", "text/html; charset=utf-8", []byte("<html></html>")},
+	}
+	for _, tt := range tests {
+		req, err := newArtifactRequest("PUT", tt.name, tt.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.reqType != "" {
+			req.Header.Set("Content-Type", tt.reqType)
+		}
+
+		mockStrg := newArtifactMockStorage()
+		rr := httptest.NewRecorder()
+		api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+		checkStatusCode(http.StatusOK, rr.Code, t)
+
+		got := mockStrg.artifacts[tt.name]
+		if tt.wantType != got.ContentType {
+			t.Errorf("wrong content type: %v (want) != %v (got)", tt.wantType, got.ContentType)
+		}
+		if !bytes.Equal(tt.data, got.Data) {
+			t.Errorf("wrong data: %v (want) != %v (got)", tt.data, got.Data)
+		}
+	}
+}
+
+func TestSetArtifactWrongName(t *testing.T) {
+	for _, name := range []string{".htaccess", "a%2Fb", "a:b"} {
+		req, err := newArtifactRequest("PUT", name, []byte("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		api.NewTestAPI("/test-status", newArtifactMockStorage()).ServeHTTP(rr, req)
+
+		checkStatusCode(http.StatusBadRequest, rr.Code, t)
+	}
+}
+
+func TestSetArtifactTooLarge(t *testing.T) {
+	mockStrg := newArtifactMockStorage()
+	req, err := newArtifactRequest("PUT", "large.js", make([]byte, mockStrg.maxSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusRequestEntityTooLarge, rr.Code, t)
+}
+
+func TestDeleteArtifact(t *testing.T) {
+	mockStrg := newArtifactMockStorage()
+	mockStrg.artifacts["x.js"] = app.Artifact{Name: "x.js"}
+	handler := api.NewTestAPI("/test-status", mockStrg)
+
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		req, err := newArtifactRequest("DELETE", "x.js", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		checkStatusCode(want, rr.Code, t)
+	}
+}
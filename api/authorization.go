@@ -15,7 +15,6 @@ import (
 func (env *env) authorize(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
-		const secretMaxSize = 44
 
 		// 1. Check Authorization header is not empty
 		if auth == "" {
@@ -32,34 +31,18 @@ func (env *env) authorize(next http.Handler) http.Handler {
 			return
 		}
 
-		// 3. Check Authorization type is correct (i.e. "Secret") and that
-		//    <secret> does not exceed the maximum accepted size in bytes
+		// 3. Check Authorization type is correct (i.e. "Secret")
 		authType := authSplit[0]
 		b64secret := authSplit[1]
 		if authType != "Secret" {
 			err := errors.New("unsupported authorization type")
 			render.Render(w, r, errUnauthorized(err))
 			return
-		} else if base64.StdEncoding.DecodedLen(len(b64secret)) > secretMaxSize {
-			err := fmt.Errorf("secret is too long; maximum is %d bytes of decoded content", secretMaxSize)
-			render.Render(w, r, errUnauthorized(err))
-			return
 		}
 
-		// 4. Check Authorization is valid base64
-		secret, err := base64.StdEncoding.DecodeString(b64secret)
+		// 4. Check the secret and get its test's id and canary
+		id, canary, err := env.setTest(b64secret)
 		if err != nil {
-			log.Debug("base64 error: %v", err)
-			err := errors.New("base64 error")
-			render.Render(w, r, errUnauthorized(err))
-			return
-		}
-
-		// 5. Generate a base32 URL-safe id via SetTest
-		id, canary, err := env.strg.SetTest(secret)
-		if id == "" || canary == "" || err != nil {
-			log.Debug("set test error: %v", err)
-			err := fmt.Errorf("could not create test")
 			render.Render(w, r, errUnauthorized(err))
 			return
 		}
@@ -69,3 +52,30 @@ func (env *env) authorize(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// setTest checks a base64 secret and creates or fetches its test via Storage.SetTest to
+// return the test's id and canary. The returned errors are safe to be sent to clients.
+func (env *env) setTest(b64secret string) (id string, canary string, err error) {
+	const secretMaxSize = 44
+
+	// 1. Check <secret> does not exceed the maximum accepted size in bytes
+	if base64.StdEncoding.DecodedLen(len(b64secret)) > secretMaxSize {
+		return "", "", fmt.Errorf("secret is too long; maximum is %d bytes of decoded content", secretMaxSize)
+	}
+
+	// 2. Check <secret> is valid base64
+	secret, err := base64.StdEncoding.DecodeString(b64secret)
+	if err != nil {
+		log.Debug("base64 error: %v", err)
+		return "", "", errors.New("base64 error")
+	}
+
+	// 3. Generate a base32 URL-safe id via SetTest
+	id, canary, err = env.strg.SetTest(secret)
+	if id == "" || canary == "" || err != nil {
+		log.Debug("set test error: %v", err)
+		return "", "", errors.New("could not create test")
+	}
+
+	return id, canary, nil
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+
+	"github.com/go-chi/render"
+	"github.com/miekg/dns"
+)
+
+const (
+	dnsMaxRecords = 16
+	// dnsMaxTXTSize keeps TXT answers small enough for UDP.
+	dnsMaxTXTSize = 1024
+	dnsMaxTTL     = 86400
+	// dnsMaxRequestSize leaves room for the records' JSON encoding overhead.
+	dnsMaxRequestSize = 4 * dnsMaxRecords * dnsMaxTXTSize
+)
+
+// dnsRequest represents a test's DNS records as set via the API.
+type dnsRequest struct {
+	Records []app.DNSRecord `json:"records"`
+}
+
+// setDNSRecords sets the records served by the DNS receiver to the queries for the
+// authorized test's names.
+func (env *env) setDNSRecords(w http.ResponseWriter, r *http.Request) {
+	id, ok := r.Context().Value(idCtxKey).(string)
+	if !ok || id == "" {
+		log.Info("API /dns could not get authorization context keys from context")
+		render.Render(w, r, errUnauthorized(errors.New("internal authentication error")))
+		return
+	}
+
+	var req dnsRequest
+	body := http.MaxBytesReader(w, r.Body, dnsMaxRequestSize)
+	if err := render.DecodeJSON(body, &req); err != nil {
+		log.Debug("API /dns decode error: %v", err)
+		render.Render(w, r, errBadRequest(errors.New("could not decode the records")))
+		return
+	}
+	if err := req.validate(); err != nil {
+		render.Render(w, r, errBadRequest(err))
+		return
+	}
+
+	if err := env.strg.SetDNSRecords(id, req.Records); err != nil {
+		log.Debug("API /dns set DNS records error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not set the records")))
+		return
+	}
+	render.Render(w, r, &dnsResponse{ID: id, Records: req.Records})
+}
+
+// deleteDNSRecords removes the authorized test's records so the DNS receiver responds
+// with the server's defaults again.
+func (env *env) deleteDNSRecords(w http.ResponseWriter, r *http.Request) {
+	id, ok := r.Context().Value(idCtxKey).(string)
+	if !ok || id == "" {
+		log.Info("API /dns could not get authorization context keys from context")
+		render.Render(w, r, errUnauthorized(errors.New("internal authentication error")))
+		return
+	}
+	if err := env.strg.SetDNSRecords(id, nil); err != nil {
+		log.Debug("API /dns delete DNS records error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not delete the records")))
+		return
+	}
+	render.Render(w, r, &dnsResponse{ID: id, Records: []app.DNSRecord{}})
+}
+
+// validate checks the records and normalizes their values.
+// The returned errors are safe to be sent to clients.
+func (req *dnsRequest) validate() error {
+	if len(req.Records) == 0 {
+		return errors.New("no records")
+	}
+	if len(req.Records) > dnsMaxRecords {
+		return fmt.Errorf("too many records; maximum is %d", dnsMaxRecords)
+	}
+	for i := range req.Records {
+		rec := &req.Records[i]
+		if rec.TTL > dnsMaxTTL {
+			return fmt.Errorf("ttl must be between 0 and %d", dnsMaxTTL)
+		}
+		switch rec.Type {
+		case "A":
+			if ip := net.ParseIP(rec.Value); ip == nil || ip.To4() == nil {
+				return fmt.Errorf("invalid A record %q", rec.Value)
+			}
+		case "AAAA":
+			if ip := net.ParseIP(rec.Value); ip == nil || ip.To4() != nil {
+				return fmt.Errorf("invalid AAAA record %q", rec.Value)
+			}
+		case "CNAME":
+			// A CNAME can't coexist with other records.
+			if len(req.Records) > 1 {
+				return errors.New("a CNAME record must be the only record")
+			}
+			if _, ok := dns.IsDomainName(rec.Value); !ok || rec.Value == "" {
+				return fmt.Errorf("invalid CNAME record %q", rec.Value)
+			}
+			rec.Value = dns.Fqdn(rec.Value)
+		case "TXT":
+			if len(rec.Value) > dnsMaxTXTSize {
+				return fmt.Errorf("TXT record is too long; maximum is %d bytes", dnsMaxTXTSize)
+			}
+		default:
+			return fmt.Errorf("unsupported record type %q", rec.Type)
+		}
+	}
+	return nil
+}
+
+type dnsResponse struct {
+	ID      string          `json:"id"`
+	Records []app.DNSRecord `json:"records"`
+}
+
+func (res *dnsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,125 @@
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/api"
+)
+
+type dnsMockStorage struct {
+	mockStorage
+	recs []app.DNSRecord
+}
+
+func (s *dnsMockStorage) SetDNSRecords(id string, recs []app.DNSRecord) error {
+	s.recs = recs
+	return nil
+}
+
+func newDNSRequest(method, body string) (*http.Request, error) {
+	req, err := http.NewRequest(method, "/dns", strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Secret %s", tB64TestSecret))
+	return req, nil
+}
+
+func TestSetDNSRecords(t *testing.T) {
+	body := `{"records": [
+		{"type": "A", "value": "169.254.169.254"},
+		{"type": "AAAA", "value": "fd00::1", "ttl": 60},
+		{"type": "TXT", "value": "v=spf1 -all"}
+	]}`
+	req, err := newDNSRequest("PUT", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &dnsMockStorage{}
+	rr := httptest.NewRecorder()
+	api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+
+	want := []app.DNSRecord{
+		{Type: "A", Value: "169.254.169.254"},
+		{Type: "AAAA", Value: "fd00::1", TTL: 60},
+		{Type: "TXT", Value: "v=spf1 -all"},
+	}
+	if !reflect.DeepEqual(want, mockStrg.recs) {
+		t.Errorf("wrong records: %v (want) != %v (got)", want, mockStrg.recs)
+	}
+}
+
+func TestSetDNSRecordsCNAME(t *testing.T) {
+	req, err := newDNSRequest("PUT", `{"records": [{"type": "CNAME", "value": "internal.example.com"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &dnsMockStorage{}
+	rr := httptest.NewRecorder()
+	api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+
+	// The CNAME's target is made fully qualified.
+	want := []app.DNSRecord{{Type: "CNAME", Value: "internal.example.com."}}
+	if !reflect.DeepEqual(want, mockStrg.recs) {
+		t.Errorf("wrong records: %v (want) != %v (got)", want, mockStrg.recs)
+	}
+}
+
+func TestSetDNSRecordsWrongRecords(t *testing.T) {
+	bodies := []string{
+		`{"records": []}`,
+		`{"records": [{"type": "MX", "value": "mail.example.com"}]}`,
+		`{"records": [{"type": "A", "value": "fd00::1"}]}`,
+		`{"records": [{"type": "A", "value": "localhost"}]}`,
+		`{"records": [{"type": "AAAA", "value": "127.0.0.1"}]}`,
+		`{"records": [{"type": "A", "value": "127.0.0.1", "ttl": 86401}]}`,
+		`{"records": [{"type": "CNAME", "value": ""}]}`,
+		`{"records": [{"type": "CNAME", "value": "a.example.com"}, {"type": "A", "value": "127.0.0.1"}]}`,
+		fmt.Sprintf(`{"records": [{"type": "TXT", "value": %q}]}`, strings.Repeat("A", 1025)),
+		fmt.Sprintf(`{"records": [%s{"type": "A", "value": "127.0.0.1"}]}`,
+			strings.Repeat(`{"type": "A", "value": "127.0.0.1"},`, 16)),
+	}
+	for _, body := range bodies {
+		req, err := newDNSRequest("PUT", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mockStrg := &dnsMockStorage{}
+		rr := httptest.NewRecorder()
+		api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+		checkStatusCode(http.StatusBadRequest, rr.Code, t)
+		if mockStrg.recs != nil {
+			t.Errorf("wrong records for %s: %v (want) != %v (got)", body, nil, mockStrg.recs)
+		}
+	}
+}
+
+func TestDeleteDNSRecords(t *testing.T) {
+	req, err := newDNSRequest("DELETE", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &dnsMockStorage{recs: []app.DNSRecord{{Type: "A", Value: "127.0.0.1"}}}
+	rr := httptest.NewRecorder()
+	api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+	if mockStrg.recs != nil {
+		t.Errorf("wrong records: %v (want) != %v (got)", nil, mockStrg.recs)
+	}
+}
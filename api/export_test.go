@@ -4,7 +4,9 @@ import (
 	"net/http"
 
 	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/certmgr"
 	"github.com/ciphermarco/BOAST/log"
+	"github.com/ciphermarco/BOAST/webhook"
 )
 
 type ExportAPI struct {
@@ -12,7 +14,27 @@ type ExportAPI struct {
 }
 
 func NewTestAPI(statusPath string, strg app.Storage) *ExportAPI {
-	handler, err := api("", statusPath, strg)
+	handler, err := api("", statusPath, strg, nil, nil)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return &ExportAPI{
+		Handler: handler,
+	}
+}
+
+func NewTestStatusAPI(statusPath string, strg app.Storage, certs certmgr.Source) *ExportAPI {
+	handler, err := api("", statusPath, strg, nil, certs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return &ExportAPI{
+		Handler: handler,
+	}
+}
+
+func NewTestWebhookAPI(strg app.Storage, hooks *webhook.Dispatcher) *ExportAPI {
+	handler, err := api("", "", strg, hooks, nil)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+
+	"github.com/go-chi/render"
+)
+
+const keyMaxBodySize = 1024
+
+type keyRequest struct {
+	PublicKey string `json:"publicKey"`
+}
+
+// setKey sets the base64 X25519 public key in the JSON body as the key the authorized
+// test's new events are sealed to.
+func (env *env) setKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := r.Context().Value(idCtxKey).(string)
+	if !ok || id == "" {
+		log.Info("API /key could not get authorization context keys from context")
+		render.Render(w, r, errUnauthorized(errors.New("internal authentication error")))
+		return
+	}
+
+	var req keyRequest
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, keyMaxBodySize), &req); err != nil {
+		log.Debug("API /key decode error: %v", err)
+		render.Render(w, r, errBadRequest(errors.New("could not decode the key")))
+		return
+	}
+	key, err := base64.StdEncoding.DecodeString(req.PublicKey)
+	if err != nil || len(key) != app.KeySize {
+		err := fmt.Errorf("publicKey must be a base64 %d bytes X25519 public key", app.KeySize)
+		render.Render(w, r, errBadRequest(err))
+		return
+	}
+
+	if err := env.strg.SetPublicKey(id, key); err != nil {
+		log.Debug("API /key set public key error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not set the key")))
+		return
+	}
+	render.Render(w, r, &keyResponse{ID: id, PublicKey: req.PublicKey})
+}
+
+// deleteKey removes the authorized test's public key so its new events are not sealed.
+func (env *env) deleteKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := r.Context().Value(idCtxKey).(string)
+	if !ok || id == "" {
+		log.Info("API /key could not get authorization context keys from context")
+		render.Render(w, r, errUnauthorized(errors.New("internal authentication error")))
+		return
+	}
+	if err := env.strg.SetPublicKey(id, nil); err != nil {
+		log.Debug("API /key delete public key error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not delete the key")))
+		return
+	}
+	render.Render(w, r, &keyResponse{ID: id})
+}
+
+type keyResponse struct {
+	ID        string `json:"id"`
+	PublicKey string `json:"publicKey"`
+}
+
+func (res *keyResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,82 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ciphermarco/BOAST/api"
+)
+
+type keyMockStorage struct {
+	mockStorage
+	key []byte
+}
+
+func (s *keyMockStorage) SetPublicKey(id string, key []byte) error {
+	s.key = key
+	return nil
+}
+
+func newKeyRequest(method, body string) (*http.Request, error) {
+	req, err := http.NewRequest(method, "/key", strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Secret %s", tB64TestSecret))
+	return req, nil
+}
+
+func TestSetKey(t *testing.T) {
+	want := bytes.Repeat([]byte{1}, 32)
+	body := fmt.Sprintf(`{"publicKey":%q}`, base64.StdEncoding.EncodeToString(want))
+	req, err := newKeyRequest("PUT", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &keyMockStorage{}
+	rr := httptest.NewRecorder()
+	api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+	if !bytes.Equal(want, mockStrg.key) {
+		t.Errorf("wrong key: %v (want) != %v (got)", want, mockStrg.key)
+	}
+}
+
+func TestSetKeyWrongKey(t *testing.T) {
+	short := base64.StdEncoding.EncodeToString([]byte("short"))
+	for _, body := range []string{"", `{"publicKey":"not base64"}`, `{"publicKey":"` + short + `"}`} {
+		req, err := newKeyRequest("PUT", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mockStrg := &keyMockStorage{}
+		rr := httptest.NewRecorder()
+		api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+		checkStatusCode(http.StatusBadRequest, rr.Code, t)
+	}
+}
+
+func TestDeleteKey(t *testing.T) {
+	req, err := newKeyRequest("DELETE", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &keyMockStorage{key: []byte("TEST KEY")}
+	rr := httptest.NewRecorder()
+	api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+	if mockStrg.key != nil {
+		t.Errorf("wrong key: %v (want) != %v (got)", nil, mockStrg.key)
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+
+	"github.com/go-chi/render"
+)
+
+const (
+	rebindingMaxCount = 1000
+	rebindingMaxDelay = time.Hour
+	// rebindingMaxRequestSize is plenty for a policy's JSON encoding.
+	rebindingMaxRequestSize = 1 << 10
+)
+
+// rebindingRequest represents a test's rebinding policy as set via the API.
+// It differs from boast.Rebinding in having a human readable delay (e.g. "30s").
+type rebindingRequest struct {
+	First  string `json:"first"`
+	Second string `json:"second"`
+	Mode   string `json:"mode,omitempty"`
+	Count  int    `json:"count,omitempty"`
+	Delay  string `json:"delay,omitempty"`
+}
+
+// setRebinding sets the DNS receiver's rebinding policy for the authorized test's names.
+func (env *env) setRebinding(w http.ResponseWriter, r *http.Request) {
+	id, ok := r.Context().Value(idCtxKey).(string)
+	if !ok || id == "" {
+		log.Info("API /rebinding could not get authorization context keys from context")
+		render.Render(w, r, errUnauthorized(errors.New("internal authentication error")))
+		return
+	}
+
+	var req rebindingRequest
+	body := http.MaxBytesReader(w, r.Body, rebindingMaxRequestSize)
+	if err := render.DecodeJSON(body, &req); err != nil {
+		log.Debug("API /rebinding decode error: %v", err)
+		render.Render(w, r, errBadRequest(errors.New("could not decode the rebinding policy")))
+		return
+	}
+	rb, err := req.rebinding()
+	if err != nil {
+		render.Render(w, r, errBadRequest(err))
+		return
+	}
+
+	if err := env.strg.SetRebinding(id, rb); err != nil {
+		log.Debug("API /rebinding set rebinding error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not set the rebinding policy")))
+		return
+	}
+	render.Render(w, r, &rebindingResponse{ID: id, Rebinding: &req})
+}
+
+// deleteRebinding removes the authorized test's rebinding policy.
+func (env *env) deleteRebinding(w http.ResponseWriter, r *http.Request) {
+	id, ok := r.Context().Value(idCtxKey).(string)
+	if !ok || id == "" {
+		log.Info("API /rebinding could not get authorization context keys from context")
+		render.Render(w, r, errUnauthorized(errors.New("internal authentication error")))
+		return
+	}
+	if err := env.strg.SetRebinding(id, nil); err != nil {
+		log.Debug("API /rebinding delete rebinding error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not delete the rebinding policy")))
+		return
+	}
+	render.Render(w, r, &rebindingResponse{ID: id})
+}
+
+// rebinding validates the request and converts it to a boast.Rebinding.
+// The returned errors are safe to be sent to clients.
+func (req *rebindingRequest) rebinding() (*app.Rebinding, error) {
+	first, second := net.ParseIP(req.First), net.ParseIP(req.Second)
+	if first == nil || second == nil {
+		return nil, errors.New("first and second must be IP addresses")
+	}
+	if (first.To4() == nil) != (second.To4() == nil) {
+		return nil, errors.New("first and second must be both IPv4 or IPv6 addresses")
+	}
+
+	rb := &app.Rebinding{First: first.String(), Second: second.String(), Mode: req.Mode}
+	switch req.Mode {
+	case "", app.RebindAlternate:
+		rb.Mode = app.RebindAlternate
+	case app.RebindCount:
+		if req.Count < 1 || req.Count > rebindingMaxCount {
+			return nil, fmt.Errorf("count must be between 1 and %d", rebindingMaxCount)
+		}
+		rb.Count = req.Count
+	case app.RebindTime:
+		d, err := time.ParseDuration(req.Delay)
+		if err != nil || d <= 0 || d > rebindingMaxDelay {
+			return nil, fmt.Errorf("delay must be a duration between 0s and %v", rebindingMaxDelay)
+		}
+		rb.Delay = d
+	default:
+		return nil, fmt.Errorf("unsupported mode %q", req.Mode)
+	}
+	return rb, nil
+}
+
+type rebindingResponse struct {
+	ID        string            `json:"id"`
+	Rebinding *rebindingRequest `json:"rebinding"`
+}
+
+func (res *rebindingResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,111 @@
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/api"
+)
+
+type rebindingMockStorage struct {
+	mockStorage
+	rb *app.Rebinding
+}
+
+func (s *rebindingMockStorage) SetRebinding(id string, rb *app.Rebinding) error {
+	s.rb = rb
+	return nil
+}
+
+func newRebindingRequest(method, body string) (*http.Request, error) {
+	req, err := http.NewRequest(method, "/rebinding", strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Secret %s", tB64TestSecret))
+	return req, nil
+}
+
+func TestSetRebinding(t *testing.T) {
+	tests := []struct {
+		body string
+		want app.Rebinding
+	}{
+		{
+			`{"first": "203.0.113.77", "second": "127.0.0.1"}`,
+			app.Rebinding{First: "203.0.113.77", Second: "127.0.0.1", Mode: app.RebindAlternate},
+		},
+		{
+			`{"first": "203.0.113.77", "second": "169.254.169.254", "mode": "count", "count": 2}`,
+			app.Rebinding{First: "203.0.113.77", Second: "169.254.169.254", Mode: app.RebindCount, Count: 2},
+		},
+		{
+			`{"first": "2001:db8::77", "second": "::1", "mode": "time", "delay": "30s"}`,
+			app.Rebinding{First: "2001:db8::77", Second: "::1", Mode: app.RebindTime, Delay: 30 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		req, err := newRebindingRequest("PUT", tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mockStrg := &rebindingMockStorage{}
+		rr := httptest.NewRecorder()
+		api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+		checkStatusCode(http.StatusOK, rr.Code, t)
+		if mockStrg.rb == nil {
+			t.Fatal("rebinding not set")
+		}
+		if tt.want != *mockStrg.rb {
+			t.Errorf("wrong rebinding: %v (want) != %v (got)", tt.want, *mockStrg.rb)
+		}
+	}
+}
+
+func TestSetRebindingWrongRebinding(t *testing.T) {
+	bodies := []string{
+		`{"first": "203.0.113.77"}`,
+		`{"first": "example.com", "second": "127.0.0.1"}`,
+		`{"first": "203.0.113.77", "second": "::1"}`,
+		`{"first": "203.0.113.77", "second": "127.0.0.1", "mode": "random"}`,
+		`{"first": "203.0.113.77", "second": "127.0.0.1", "mode": "count"}`,
+		`{"first": "203.0.113.77", "second": "127.0.0.1", "mode": "count", "count": 1001}`,
+		`{"first": "203.0.113.77", "second": "127.0.0.1", "mode": "time"}`,
+		`{"first": "203.0.113.77", "second": "127.0.0.1", "mode": "time", "delay": "2h"}`,
+	}
+	for _, body := range bodies {
+		req, err := newRebindingRequest("PUT", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mockStrg := &rebindingMockStorage{}
+		rr := httptest.NewRecorder()
+		api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+		checkStatusCode(http.StatusBadRequest, rr.Code, t)
+	}
+}
+
+func TestDeleteRebinding(t *testing.T) {
+	req, err := newRebindingRequest("DELETE", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &rebindingMockStorage{rb: &app.Rebinding{}}
+	rr := httptest.NewRecorder()
+	api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+	if mockStrg.rb != nil {
+		t.Errorf("wrong rebinding: %v (want) != %v (got)", nil, mockStrg.rb)
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+
+	"github.com/go-chi/render"
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+	httpResponseMaxBodySize = 64 << 10
+	httpResponseMaxHeaders  = 32
+	// httpResponseMaxRequestSize leaves room for the response's JSON encoding overhead.
+	httpResponseMaxRequestSize = 2 * httpResponseMaxBodySize
+)
+
+// httpResponseRequest represents a test's HTTP response as set via the API.
+// It differs from boast.HTTPResponse in having a human readable delay (e.g. "2s").
+type httpResponseRequest struct {
+	Status      int               `json:"status,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        string            `json:"body,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Location    string            `json:"location,omitempty"`
+	Delay       string            `json:"delay,omitempty"`
+}
+
+// setHTTPResponse sets the response served by the HTTP receiver to the authorized
+// test's requests.
+func (env *env) setHTTPResponse(w http.ResponseWriter, r *http.Request) {
+	id, ok := r.Context().Value(idCtxKey).(string)
+	if !ok || id == "" {
+		log.Info("API /response could not get authorization context keys from context")
+		render.Render(w, r, errUnauthorized(errors.New("internal authentication error")))
+		return
+	}
+
+	var req httpResponseRequest
+	body := http.MaxBytesReader(w, r.Body, httpResponseMaxRequestSize)
+	if err := render.DecodeJSON(body, &req); err != nil {
+		log.Debug("API /response decode error: %v", err)
+		render.Render(w, r, errBadRequest(errors.New("could not decode the response")))
+		return
+	}
+	res, err := req.httpResponse()
+	if err != nil {
+		render.Render(w, r, errBadRequest(err))
+		return
+	}
+
+	if err := env.strg.SetHTTPResponse(id, res); err != nil {
+		log.Debug("API /response set HTTP response error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not set the response")))
+		return
+	}
+	render.Render(w, r, &httpResponseResponse{ID: id, Response: &req})
+}
+
+// deleteHTTPResponse removes the authorized test's response so the HTTP receiver
+// responds with the test's canary again.
+func (env *env) deleteHTTPResponse(w http.ResponseWriter, r *http.Request) {
+	id, ok := r.Context().Value(idCtxKey).(string)
+	if !ok || id == "" {
+		log.Info("API /response could not get authorization context keys from context")
+		render.Render(w, r, errUnauthorized(errors.New("internal authentication error")))
+		return
+	}
+	if err := env.strg.SetHTTPResponse(id, nil); err != nil {
+		log.Debug("API /response delete HTTP response error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not delete the response")))
+		return
+	}
+	render.Render(w, r, &httpResponseResponse{ID: id})
+}
+
+// httpResponse validates the request and converts it to a boast.HTTPResponse.
+// The returned errors are safe to be sent to clients.
+func (req *httpResponseRequest) httpResponse() (*app.HTTPResponse, error) {
+	if req.Status != 0 && (req.Status < 200 || req.Status > 599) {
+		return nil, errors.New("status must be between 200 and 599")
+	}
+	if len(req.Body) > httpResponseMaxBodySize {
+		return nil, fmt.Errorf("body is too long; maximum is %d bytes", httpResponseMaxBodySize)
+	}
+	if len(req.Headers) > httpResponseMaxHeaders {
+		return nil, fmt.Errorf("too many headers; maximum is %d", httpResponseMaxHeaders)
+	}
+	for k, v := range req.Headers {
+		if !httpguts.ValidHeaderFieldName(k) || !httpguts.ValidHeaderFieldValue(v) {
+			return nil, fmt.Errorf("invalid header %q", k)
+		}
+	}
+	if !httpguts.ValidHeaderFieldValue(req.ContentType) {
+		return nil, errors.New("invalid content type")
+	}
+	if !httpguts.ValidHeaderFieldValue(req.Location) {
+		return nil, errors.New("invalid location")
+	}
+
+	var delay time.Duration
+	if req.Delay != "" {
+		d, err := time.ParseDuration(req.Delay)
+		if err != nil || d < 0 || d > app.HTTPResponseMaxDelay {
+			return nil, fmt.Errorf("delay must be a duration between 0s and %v", app.HTTPResponseMaxDelay)
+		}
+		delay = d
+	}
+
+	return &app.HTTPResponse{
+		Status:      req.Status,
+		Headers:     req.Headers,
+		Body:        req.Body,
+		ContentType: req.ContentType,
+		Location:    req.Location,
+		Delay:       delay,
+	}, nil
+}
+
+type httpResponseResponse struct {
+	ID       string               `json:"id"`
+	Response *httpResponseRequest `json:"response"`
+}
+
+func (res *httpResponseResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/api"
+)
+
+type responseMockStorage struct {
+	mockStorage
+	res *app.HTTPResponse
+}
+
+func (s *responseMockStorage) SetHTTPResponse(id string, res *app.HTTPResponse) error {
+	s.res = res
+	return nil
+}
+
+func newResponseRequest(method, body string) (*http.Request, error) {
+	req, err := http.NewRequest(method, "/response", strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Secret %s", tB64TestSecret))
+	return req, nil
+}
+
+func TestSetHTTPResponse(t *testing.T) {
+	body := `{
+		"status": 301,
+		"headers": {"X-Test": "{{id}}"},
+		"location": "file:///etc/passwd",
+		"delay": "1.5s"
+	}`
+	req, err := newResponseRequest("PUT", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &responseMockStorage{}
+	rr := httptest.NewRecorder()
+	api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+
+	if mockStrg.res == nil {
+		t.Fatal("response not set")
+	}
+	wantDelay := 1500 * time.Millisecond
+	if wantDelay != mockStrg.res.Delay {
+		t.Errorf("wrong delay: %v (want) != %v (got)", wantDelay, mockStrg.res.Delay)
+	}
+	wantLoc := "file:///etc/passwd"
+	if wantLoc != mockStrg.res.Location {
+		t.Errorf("wrong location: %v (want) != %v (got)", wantLoc, mockStrg.res.Location)
+	}
+}
+
+func TestSetHTTPResponseWrongResponse(t *testing.T) {
+	bodies := []string{
+		`{"status": 99}`,
+		`{"status": 600}`,
+		`{"delay": "1 minute"}`,
+		`{"delay": "1h"}`,
+		`{"headers": {"X Test": "test"}}`,
+		`{"headers": {"X-Test": "line\r\nbreak"}}`,
+		`{"location": "line\r\nbreak"}`,
+		fmt.Sprintf(`{"body": %q}`, strings.Repeat("A", 65<<10)),
+	}
+	for _, body := range bodies {
+		req, err := newResponseRequest("PUT", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mockStrg := &responseMockStorage{}
+		rr := httptest.NewRecorder()
+		api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+		checkStatusCode(http.StatusBadRequest, rr.Code, t)
+	}
+}
+
+func TestDeleteHTTPResponse(t *testing.T) {
+	req, err := newResponseRequest("DELETE", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &responseMockStorage{res: &app.HTTPResponse{}}
+	rr := httptest.NewRecorder()
+	api.NewTestAPI("/test-status", mockStrg).ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+	if mockStrg.res != nil {
+		t.Errorf("wrong response: %v (want) != %v (got)", nil, mockStrg.res)
+	}
+}
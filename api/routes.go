@@ -4,11 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	app "github.com/ciphermarco/BOAST"
 	"github.com/ciphermarco/BOAST/api/httplogger"
+	"github.com/ciphermarco/BOAST/certmgr"
 	"github.com/ciphermarco/BOAST/log"
+	"github.com/ciphermarco/BOAST/webhook"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
@@ -19,10 +23,12 @@ type env struct {
 	strg   app.Storage
 	proc   procfs.Proc
 	domain string
+	hooks  *webhook.Dispatcher
+	certs  certmgr.Source
 }
 
-func api(domain string, statusPath string, strg app.Storage) (http.Handler, error) {
-	e := &env{strg: strg, domain: domain}
+func api(domain string, statusPath string, strg app.Storage, hooks *webhook.Dispatcher, certs certmgr.Source) (http.Handler, error) {
+	e := &env{strg: strg, domain: domain, hooks: hooks, certs: certs}
 	r := chi.NewRouter()
 
 	if e.domain != "" {
@@ -33,6 +39,22 @@ func api(domain string, statusPath string, strg app.Storage) (http.Handler, erro
 
 	r.Get("/", e.home)
 	r.With(e.authorize).Get("/events", e.events)
+	r.With(e.authorize).Delete("/events", e.deleteEvents)
+	r.With(e.authorize).Delete("/events/{eventID}", e.deleteEvent)
+	r.With(e.authorize).Get("/events/stream", e.eventsStream)
+	r.Get("/events/ws", e.eventsWebSocket)
+	r.With(e.authorize).Put("/webhook", e.setWebhook)
+	r.With(e.authorize).Delete("/webhook", e.deleteWebhook)
+	r.With(e.authorize).Put("/key", e.setKey)
+	r.With(e.authorize).Delete("/key", e.deleteKey)
+	r.With(e.authorize).Put("/response", e.setHTTPResponse)
+	r.With(e.authorize).Delete("/response", e.deleteHTTPResponse)
+	r.With(e.authorize).Put("/artifacts/{name}", e.setArtifact)
+	r.With(e.authorize).Delete("/artifacts/{name}", e.deleteArtifact)
+	r.With(e.authorize).Put("/dns", e.setDNSRecords)
+	r.With(e.authorize).Delete("/dns", e.deleteDNSRecords)
+	r.With(e.authorize).Put("/rebinding", e.setRebinding)
+	r.With(e.authorize).Delete("/rebinding", e.deleteRebinding)
 
 	if statusPath != "" && statusPath != "/" {
 		p, err := procfs.Self()
@@ -102,15 +124,25 @@ func (env *env) status(w http.ResponseWriter, r *http.Request) {
 		FDLen:        fdLen,
 		FDLimit:      limits.OpenFiles,
 	}
+	if env.hooks != nil {
+		res.WebhookDelivered = env.hooks.Delivered()
+		res.WebhookDeadLetters = env.hooks.DeadLetters()
+	}
+	if env.certs != nil {
+		res.Certificates = env.certs.Certificates()
+	}
 	render.Render(w, r, res)
 }
 
 type statusResponse struct {
-	StoredTests  int    `json:"storedTests"`
-	StoredEvents int    `json:"storedEvents"`
-	RSS          int    `json:"residentSetSizeBytes"`
-	FDLen        int    `json:"openFileDescriptors"`
-	FDLimit      uint64 `json:"openFileDescriptorsLimit"`
+	StoredTests        int            `json:"storedTests"`
+	StoredEvents       int            `json:"storedEvents"`
+	RSS                int            `json:"residentSetSizeBytes"`
+	FDLen              int            `json:"openFileDescriptors"`
+	FDLimit            uint64         `json:"openFileDescriptorsLimit"`
+	WebhookDelivered   int64          `json:"webhookDelivered"`
+	WebhookDeadLetters int64          `json:"webhookDeadLetters"`
+	Certificates       []certmgr.Info `json:"certificates,omitempty"`
 }
 
 func (res *statusResponse) Render(w http.ResponseWriter, r *http.Request) error {
@@ -136,25 +168,151 @@ func (env *env) events(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if events, exists := env.strg.LoadEvents(id); exists {
-		res := &eventsResponse{ID: id, Canary: canary, Events: events}
-		render.Render(w, r, res)
-	} else {
-		res := &eventsResponse{ID: id, Canary: canary, Events: []app.Event{}}
-		render.Render(w, r, res)
+	rng, err := parseEventsRange(r)
+	if err != nil {
+		render.Render(w, r, errBadRequest(err))
+		return
+	}
+
+	wait, err := parseEventsWait(r)
+	if err != nil {
+		render.Render(w, r, errBadRequest(err))
+		return
+	}
+
+	res := &eventsResponse{ID: id, Canary: canary, Events: []app.Event{}}
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+waitLoop:
+	for {
+		// The notification channel must be taken before loading the events so an
+		// event stored in between is not missed.
+		notify, cancel := env.strg.WaitEvents(id)
+		if events, exists := env.strg.LoadEventsRange(id, rng); exists {
+			res.Events = events
+		}
+		if len(res.Events) > 0 || wait == 0 {
+			cancel()
+			break
+		}
+		select {
+		case <-notify:
+			cancel()
+		case <-timeout.C:
+			cancel()
+			break waitLoop
+		case <-r.Context().Done():
+			cancel()
+			return
+		}
 	}
+	res.Next = r.URL.Query().Get("since")
+	if l := len(res.Events); l > 0 {
+		res.Next = res.Events[l-1].ID
+	}
+	render.Render(w, r, res)
+}
+
+// parseEventsRange parses the events range from the request's query parameters.
+// The "since" parameter is either an event ID or an RFC 3339 timestamp, and "limit" is
+// the maximum number of events to be returned.
+func parseEventsRange(r *http.Request) (rng app.EventsRange, err error) {
+	q := r.URL.Query()
+	if since := q.Get("since"); since != "" {
+		if t, err := time.Parse(time.RFC3339Nano, since); err == nil {
+			rng.SinceTime = t
+		} else {
+			rng.SinceID = since
+		}
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return rng, errors.New("limit must be a non-negative integer")
+		}
+		rng.Limit = n
+	}
+	return rng, nil
+}
+
+// parseEventsWait parses the "wait" query parameter as the duration to wait for new
+// events when there are none in the requested range. The duration is capped to
+// maxEventsWait so the response can still be written before the server's write timeout.
+func parseEventsWait(r *http.Request) (time.Duration, error) {
+	wait := r.URL.Query().Get("wait")
+	if wait == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(wait)
+	if err != nil || d < 0 {
+		return 0, errors.New("wait must be a non-negative duration (e.g. \"5s\")")
+	}
+	if d > maxEventsWait {
+		d = maxEventsWait
+	}
+	return d, nil
 }
 
 type eventsResponse struct {
 	ID     string      `json:"id"`
 	Canary string      `json:"canary"`
 	Events []app.Event `json:"events"`
+	Next   string      `json:"next,omitempty"`
 }
 
 func (res *eventsResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// deleteEvents deletes all the authorized test's events.
+func (env *env) deleteEvents(w http.ResponseWriter, r *http.Request) {
+	id, ok := r.Context().Value(idCtxKey).(string)
+	if !ok || id == "" {
+		log.Info("API DELETE /events could not get authorization context keys from context")
+		render.Render(w, r, errUnauthorized(errors.New("internal authentication error")))
+		return
+	}
+
+	deleted, err := env.strg.DeleteEvents(id)
+	if err != nil {
+		log.Debug("API DELETE /events error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not delete the events")))
+		return
+	}
+	render.Render(w, r, &deleteEventsResponse{ID: id, Deleted: deleted})
+}
+
+// deleteEvent deletes one of the authorized test's events.
+func (env *env) deleteEvent(w http.ResponseWriter, r *http.Request) {
+	id, ok := r.Context().Value(idCtxKey).(string)
+	if !ok || id == "" {
+		log.Info("API DELETE /events/{eventID} could not get authorization context keys from context")
+		render.Render(w, r, errUnauthorized(errors.New("internal authentication error")))
+		return
+	}
+
+	deleted, err := env.strg.DeleteEvent(id, chi.URLParam(r, "eventID"))
+	if err != nil {
+		log.Debug("API DELETE /events/{eventID} error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not delete the event")))
+		return
+	}
+	if !deleted {
+		render.Render(w, r, errNotFound(errors.New("event not found")))
+		return
+	}
+	render.Render(w, r, &deleteEventsResponse{ID: id, Deleted: 1})
+}
+
+type deleteEventsResponse struct {
+	ID      string `json:"id"`
+	Deleted int    `json:"deleted"`
+}
+
+func (res *deleteEventsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
 type errResponse struct {
 	Err            error  `json:"-"`
 	HTTPStatusCode int    `json:"-"`
@@ -176,6 +334,42 @@ func errUnauthorized(err error) render.Renderer {
 	}
 }
 
+func errBadRequest(err error) render.Renderer {
+	return &errResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusBadRequest,
+		StatusText:     "Bad Request",
+		ErrorText:      err.Error(),
+	}
+}
+
+func errForbidden(err error) render.Renderer {
+	return &errResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusForbidden,
+		StatusText:     "Forbidden",
+		ErrorText:      err.Error(),
+	}
+}
+
+func errNotFound(err error) render.Renderer {
+	return &errResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusNotFound,
+		StatusText:     "Not Found",
+		ErrorText:      err.Error(),
+	}
+}
+
+func errRequestEntityTooLarge(err error) render.Renderer {
+	return &errResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusRequestEntityTooLarge,
+		StatusText:     "Request Entity Too Large",
+		ErrorText:      err.Error(),
+	}
+}
+
 func errInternalServerError(err error) render.Renderer {
 	return &errResponse{
 		Err:            err,
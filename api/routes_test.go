@@ -0,0 +1,319 @@
+package api_test
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/api"
+	"github.com/ciphermarco/BOAST/certmgr"
+)
+
+type rangeMockStorage struct {
+	mockStorage
+	evts []app.Event
+	rng  app.EventsRange
+}
+
+func (s *rangeMockStorage) LoadEventsRange(id string, rng app.EventsRange) ([]app.Event, bool) {
+	s.rng = rng
+	return s.evts, true
+}
+
+func newRangeMockStorage(n int) *rangeMockStorage {
+	s := &rangeMockStorage{}
+	for i := 0; i < n; i++ {
+		s.evts = append(s.evts, app.Event{
+			ID:     fmt.Sprintf("TEST ID %d", i),
+			Time:   time.Now(),
+			TestID: tTest.ID,
+		})
+	}
+	return s
+}
+
+func newAuthorizedRequest(method, target string) (*http.Request, error) {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Secret %s", tB64TestSecret))
+	return req, nil
+}
+
+func TestEventsSinceID(t *testing.T) {
+	req, err := newAuthorizedRequest("GET", "/events?since=fbb6osymic6llzuiw7f7ylwix4&limit=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := newRangeMockStorage(2)
+	rr := httptest.NewRecorder()
+	handler := api.NewTestAPI("/test-status", mockStrg)
+	handler.ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+
+	wantRng := app.EventsRange{SinceID: "fbb6osymic6llzuiw7f7ylwix4", Limit: 2}
+	if wantRng != mockStrg.rng {
+		t.Errorf("wrong range: %v (want) != %v (got)", wantRng, mockStrg.rng)
+	}
+
+	res, err := unmarshalEventsResponse(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNext := mockStrg.evts[1].ID
+	if wantNext != res.Next {
+		t.Errorf("wrong next: %v (want) != %v (got)", wantNext, res.Next)
+	}
+}
+
+func TestEventsSinceTime(t *testing.T) {
+	since := "2020-09-16T16:31:05.183124969+01:00"
+	req, err := newAuthorizedRequest("GET", "/events?since="+url.QueryEscape(since))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without new events the cursor is kept.
+	mockStrg := newRangeMockStorage(0)
+	rr := httptest.NewRecorder()
+	handler := api.NewTestAPI("/test-status", mockStrg)
+	handler.ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+
+	wantTime, _ := time.Parse(time.RFC3339Nano, since)
+	if !wantTime.Equal(mockStrg.rng.SinceTime) || mockStrg.rng.SinceID != "" {
+		t.Errorf("wrong range: %v (want) != %v (got)", wantTime, mockStrg.rng)
+	}
+
+	res, err := unmarshalEventsResponse(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if since != res.Next {
+		t.Errorf("wrong next: %v (want) != %v (got)", since, res.Next)
+	}
+}
+
+func TestEventsWrongLimit(t *testing.T) {
+	for _, limit := range []string{"-1", "ten"} {
+		req, err := newAuthorizedRequest("GET", "/events?limit="+limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := api.NewTestAPI("/test-status", newRangeMockStorage(1))
+		handler.ServeHTTP(rr, req)
+
+		checkStatusCode(http.StatusBadRequest, rr.Code, t)
+	}
+}
+
+type waitMockStorage struct {
+	rangeMockStorage
+	notify chan struct{}
+}
+
+func (s *waitMockStorage) WaitEvents(id string) (notify <-chan struct{}, cancel func()) {
+	return s.notify, func() {}
+}
+
+func (s *waitMockStorage) LoadEventsRange(id string, rng app.EventsRange) ([]app.Event, bool) {
+	select {
+	case <-s.notify:
+		return s.rangeMockStorage.LoadEventsRange(id, rng)
+	default:
+		return []app.Event{}, true
+	}
+}
+
+func TestEventsWait(t *testing.T) {
+	req, err := newAuthorizedRequest("GET", "/events?wait=5s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &waitMockStorage{
+		rangeMockStorage: *newRangeMockStorage(1),
+		notify:           make(chan struct{}),
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(mockStrg.notify)
+	}()
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	handler := api.NewTestAPI("/test-status", mockStrg)
+	handler.ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+	checkEventsBody(rr.Body, tTest.ID, 1, t)
+	if elapsed := time.Since(start); elapsed >= 5*time.Second {
+		t.Errorf("did not return on new event: < 5s (want) != %v (got)", elapsed)
+	}
+}
+
+func TestEventsWaitTimeout(t *testing.T) {
+	req, err := newAuthorizedRequest("GET", "/events?wait=50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &waitMockStorage{
+		rangeMockStorage: *newRangeMockStorage(1),
+		notify:           make(chan struct{}),
+	}
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	handler := api.NewTestAPI("/test-status", mockStrg)
+	handler.ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+	checkEventsBody(rr.Body, tTest.ID, 0, t)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("did not wait: >= 50ms (want) != %v (got)", elapsed)
+	}
+}
+
+func TestEventsWrongWait(t *testing.T) {
+	for _, wait := range []string{"-1s", "5"} {
+		req, err := newAuthorizedRequest("GET", "/events?wait="+wait)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := api.NewTestAPI("/test-status", newRangeMockStorage(1))
+		handler.ServeHTTP(rr, req)
+
+		checkStatusCode(http.StatusBadRequest, rr.Code, t)
+	}
+}
+
+type deleteMockStorage struct {
+	mockStorage
+	evtIDs map[string]bool
+}
+
+func (s *deleteMockStorage) DeleteEvents(id string) (int, error) {
+	n := len(s.evtIDs)
+	s.evtIDs = map[string]bool{}
+	return n, nil
+}
+
+func (s *deleteMockStorage) DeleteEvent(id, evtID string) (bool, error) {
+	deleted := s.evtIDs[evtID]
+	delete(s.evtIDs, evtID)
+	return deleted, nil
+}
+
+func TestDeleteEvents(t *testing.T) {
+	req, err := newAuthorizedRequest("DELETE", "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &deleteMockStorage{evtIDs: map[string]bool{"a": true, "b": true}}
+	rr := httptest.NewRecorder()
+	handler := api.NewTestAPI("/test-status", mockStrg)
+	handler.ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+
+	var res struct {
+		Deleted int `json:"deleted"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	wantDeleted := 2
+	if wantDeleted != res.Deleted {
+		t.Errorf("wrong deleted: %v (want) != %v (got)", wantDeleted, res.Deleted)
+	}
+}
+
+func TestDeleteEvent(t *testing.T) {
+	mockStrg := &deleteMockStorage{evtIDs: map[string]bool{"fbb6osymic6llzuiw7f7ylwix4": true}}
+	handler := api.NewTestAPI("/test-status", mockStrg)
+
+	// Deleting the same event twice finds it only the first time.
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		req, err := newAuthorizedRequest("DELETE", "/events/fbb6osymic6llzuiw7f7ylwix4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		checkStatusCode(want, rr.Code, t)
+	}
+}
+
+func TestDeleteEventsUnauthorized(t *testing.T) {
+	for _, target := range []string{"/events", "/events/fbb6osymic6llzuiw7f7ylwix4"} {
+		req, err := http.NewRequest("DELETE", target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		handler := api.NewTestAPI("/test-status", &deleteMockStorage{})
+		handler.ServeHTTP(rr, req)
+
+		checkStatusCode(http.StatusUnauthorized, rr.Code, t)
+	}
+}
+
+type mockCerts []certmgr.Info
+
+func (c mockCerts) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return nil, nil
+}
+
+func (c mockCerts) Certificates() []certmgr.Info {
+	return c
+}
+
+func TestStatusCertificates(t *testing.T) {
+	req, err := http.NewRequest("GET", "/test-status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := mockCerts{
+		{Names: []string{"api.example.com"}, NotAfter: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{Names: []string{"example.com", "*.example.com"}, NotAfter: time.Date(2031, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	rr := httptest.NewRecorder()
+	handler := api.NewTestStatusAPI("/test-status", &mockStorage{}, certs)
+	handler.ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+
+	var res struct {
+		Certificates []certmgr.Info `json:"certificates"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Certificates) != len(certs) {
+		t.Fatalf("wrong certificates: %v (want) != %v (got)", certs, res.Certificates)
+	}
+	for i, want := range certs {
+		got := res.Certificates[i]
+		if !got.NotAfter.Equal(want.NotAfter) || fmt.Sprint(got.Names) != fmt.Sprint(want.Names) {
+			t.Errorf("wrong certificate: %v (want) != %v (got)", want, got)
+		}
+	}
+}
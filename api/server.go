@@ -8,7 +8,16 @@ import (
 	"time"
 
 	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/certmgr"
 	"github.com/ciphermarco/BOAST/log"
+	"github.com/ciphermarco/BOAST/webhook"
+)
+
+const (
+	writeTimeout = 10 * time.Second
+	// maxEventsWait is the maximum time /events waits for new events.
+	// It leaves part of writeTimeout for writing the response.
+	maxEventsWait = writeTimeout - 2*time.Second
 )
 
 // Server represents the API server.
@@ -21,6 +30,10 @@ type Server struct {
 	TLSKeyPath  string
 	StatusPath  string
 	Storage     app.Storage
+	Webhooks    *webhook.Dispatcher
+	// Certs, if set, provides the TLS certificates instead of the files and their
+	// expiry to the status page.
+	Certs certmgr.Source
 }
 
 // ListenAndServe sets the necessary conditions for the underlying http.Server
@@ -43,10 +56,15 @@ func (s *Server) ListenAndServe(err chan error) {
 			tls.CurveP256, tls.X25519,
 		},
 	}
+	certPath, keyPath := s.TLSCertPath, s.TLSKeyPath
+	if s.Certs != nil {
+		tlsConfig.GetCertificate = s.Certs.GetCertificate
+		certPath, keyPath = "", ""
+	}
 
 	addr := s.Addr(s.TLSPort)
 	statusPath := ensureLeadingSlash(url.PathEscape(s.StatusPath))
-	r, e := api(s.Domain, statusPath, s.Storage)
+	r, e := api(s.Domain, statusPath, s.Storage, s.Webhooks, s.Certs)
 	if e != nil {
 		err <- e
 	}
@@ -57,7 +75,7 @@ func (s *Server) ListenAndServe(err chan error) {
 		TLSConfig:    tlsConfig,
 		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
 		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: writeTimeout,
 		IdleTimeout:  120 * time.Second,
 	}
 
@@ -65,7 +83,7 @@ func (s *Server) ListenAndServe(err chan error) {
 		log.Info("Web API Server: status URL is https://%s%s", addr, statusPath)
 	}
 	log.Info("Web API Server: Listening on https://%s\n", addr)
-	err <- srv.ListenAndServeTLS(s.TLSCertPath, s.TLSKeyPath)
+	err <- srv.ListenAndServeTLS(certPath, keyPath)
 }
 
 // Addr returns an address in the format expected by http.Server.
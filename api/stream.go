@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+
+	"github.com/go-chi/render"
+)
+
+// streamKeepAlive is the interval between keep-alive comments sent to streaming clients
+// so proxies do not close idle connections.
+const streamKeepAlive = 15 * time.Second
+
+// eventsStream streams the authorized test's events as Server-Sent Events.
+//
+// The test's id and canary are sent first as a "test" message. Then each event is sent
+// as an "event" message with the event's ID as the message ID so clients can resume
+// from the last received event with the standard Last-Event-ID header (or the "since"
+// query parameter). The "limit" query parameter only limits how many stored events are
+// loaded at once. The stream ends if the client falls behind the stored events.
+func (env *env) eventsStream(w http.ResponseWriter, r *http.Request) {
+	id, idOk := r.Context().Value(idCtxKey).(string)
+	canary, canaryOk := r.Context().Value(canaryCtxKey).(string)
+
+	if !idOk || !canaryOk || id == "" || canary == "" {
+		log.Info("API /events/stream could not get authorization context keys from context")
+		err := errors.New("internal authentication error")
+		render.Render(w, r, errUnauthorized(err))
+		return
+	}
+
+	rng, err := parseEventsRange(r)
+	if err != nil {
+		render.Render(w, r, errBadRequest(err))
+		return
+	}
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		rng = app.EventsRange{SinceID: lastID}
+	}
+
+	// Streams are long-lived, so they are exempted from the server's write timeout.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Debug("API /events/stream could not clear write deadline: %v", err)
+	}
+
+	// Subscribing before loading the stored events makes sure no event is missed.
+	evts, cancel := env.strg.Subscribe(id)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(name, msgID string, v interface{}) error {
+		if err := writeSSE(w, name, msgID, v); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if err := send("test", "", &testResponse{ID: id, Canary: canary}); err != nil {
+		return
+	}
+
+	// With a limit, the stored events are loaded in pages continuing from the last event
+	// sent until a page is not full.
+	var last *app.Event
+	for {
+		stored, _ := env.strg.LoadEventsRange(id, rng)
+		sent := 0
+		for i := range stored {
+			if last != nil && !last.Before(&stored[i]) {
+				continue
+			}
+			if err := send("event", stored[i].ID, &stored[i]); err != nil {
+				return
+			}
+			last = &stored[i]
+			sent++
+		}
+		if rng.Limit <= 0 || len(stored) < rng.Limit || sent == 0 {
+			break
+		}
+		rng = app.EventsRange{SinceID: last.ID, Limit: rng.Limit}
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case evt, ok := <-evts:
+			if !ok {
+				log.Debug("API /events/stream subscription for test %s ended", id)
+				return
+			}
+			// Skip events already sent from the stored ones.
+			if last != nil && !last.Before(&evt) {
+				continue
+			}
+			if err := send("event", evt.ID, &evt); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSE writes v as the JSON data of a Server-Sent Events message.
+func writeSSE(w io.Writer, name, msgID string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if msgID != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", msgID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+	return err
+}
+
+type testResponse struct {
+	ID     string `json:"id"`
+	Canary string `json:"canary"`
+}
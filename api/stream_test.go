@@ -0,0 +1,194 @@
+package api_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/api"
+)
+
+type streamMockStorage struct {
+	rangeMockStorage
+	pub chan app.Event
+}
+
+func (s *streamMockStorage) Subscribe(id string) (<-chan app.Event, func() int) {
+	return s.pub, func() int { return 0 }
+}
+
+// LoadEventsRange selects the events after rng.SinceID up to rng.Limit.
+func (s *streamMockStorage) LoadEventsRange(id string, rng app.EventsRange) ([]app.Event, bool) {
+	s.rng = rng
+	evts := s.evts
+	for i := range evts {
+		if evts[i].ID == rng.SinceID {
+			evts = evts[i+1:]
+			break
+		}
+	}
+	if rng.Limit > 0 && len(evts) > rng.Limit {
+		evts = evts[:rng.Limit]
+	}
+	return evts, true
+}
+
+type sseMessage struct {
+	id    string
+	event string
+	data  string
+}
+
+func readSSE(r *bufio.Reader) (msg sseMessage, err error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return msg, err
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			return msg, nil
+		case strings.HasPrefix(line, ":"):
+			continue
+		case strings.HasPrefix(line, "id: "):
+			msg.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			msg.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			msg.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	mockStrg := &streamMockStorage{
+		rangeMockStorage: *newRangeMockStorage(1),
+		pub:              make(chan app.Event, 2),
+	}
+	srv := httptest.NewUnstartedServer(api.NewTestAPI("/test-status", mockStrg))
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	req, err := newAuthorizedRequest("GET", srv.URL+"/events/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	checkStatusCode(http.StatusOK, res.StatusCode, t)
+	wantType := "text/event-stream"
+	if gotType := res.Header.Get("Content-Type"); wantType != gotType {
+		t.Errorf("wrong Content-Type: %v (want) != %v (got)", wantType, gotType)
+	}
+
+	r := bufio.NewReader(res.Body)
+	msg, err := readSSE(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantData := fmt.Sprintf(`{"id":"%s","canary":"%s"}`, tTest.ID, tTest.Canary)
+	if msg.event != "test" || msg.data != wantData {
+		t.Errorf("wrong test message: %v (want) != %v (got)", wantData, msg)
+	}
+
+	stored := mockStrg.evts[0]
+	msg, err = readSSE(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.event != "event" || msg.id != stored.ID {
+		t.Errorf("wrong stored event message: %v (want) != %v (got)", stored.ID, msg)
+	}
+
+	// The stream outlives the server's write timeout.
+	time.Sleep(2 * srv.Config.WriteTimeout)
+
+	// An event published while loading the stored ones is not sent twice.
+	mockStrg.pub <- stored
+	published := app.Event{ID: "TEST ID published", Time: time.Now(), TestID: tTest.ID}
+	mockStrg.pub <- published
+
+	msg, err = readSSE(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.event != "event" || msg.id != published.ID {
+		t.Errorf("wrong published event message: %v (want) != %v (got)", published.ID, msg)
+	}
+	var evt app.Event
+	if err := json.Unmarshal([]byte(msg.data), &evt); err != nil {
+		t.Fatal(err)
+	}
+	if evt.ID != published.ID {
+		t.Errorf("wrong event: %v (want) != %v (got)", published.ID, evt.ID)
+	}
+
+	// The stream ends when the subscription ends.
+	close(mockStrg.pub)
+	if _, err := readSSE(r); err == nil {
+		t.Errorf("stream did not end: error (want) != %v (got)", err)
+	}
+}
+
+func TestEventsStreamLimit(t *testing.T) {
+	mockStrg := &streamMockStorage{
+		rangeMockStorage: *newRangeMockStorage(5),
+		pub:              make(chan app.Event),
+	}
+	srv := httptest.NewServer(api.NewTestAPI("/test-status", mockStrg))
+	defer srv.Close()
+
+	req, err := newAuthorizedRequest("GET", srv.URL+"/events/stream?limit=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The events not sent would make the reads block.
+	client := &http.Client{Timeout: 5 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	checkStatusCode(http.StatusOK, res.StatusCode, t)
+
+	r := bufio.NewReader(res.Body)
+	if _, err := readSSE(r); err != nil {
+		t.Fatal(err)
+	}
+	// All the stored events are sent, two at a time.
+	for _, want := range mockStrg.evts {
+		msg, err := readSSE(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.event != "event" || msg.id != want.ID {
+			t.Errorf("wrong stored event message: %v (want) != %v (got)", want.ID, msg)
+		}
+	}
+	close(mockStrg.pub)
+}
+
+func TestEventsStreamUnauthorized(t *testing.T) {
+	req, err := http.NewRequest("GET", "/events/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := api.NewTestAPI("/test-status", &mockStorage{})
+	handler.ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusUnauthorized, rr.Code, t)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+
+	"github.com/go-chi/render"
+)
+
+const (
+	webhookMaxBodySize  = 4096
+	webhookMaxURLLen    = 2048
+	webhookMaxSecretLen = 64
+)
+
+// setWebhook sets the authorized test's webhook to the URL and secret in the JSON body.
+func (env *env) setWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := env.webhookTestID(w, r)
+	if !ok {
+		return
+	}
+
+	var wh app.Webhook
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, webhookMaxBodySize), &wh); err != nil {
+		log.Debug("API /webhook decode error: %v", err)
+		render.Render(w, r, errBadRequest(errors.New("could not decode the webhook")))
+		return
+	}
+	if err := validateWebhook(&wh); err != nil {
+		render.Render(w, r, errBadRequest(err))
+		return
+	}
+
+	if err := env.strg.SetWebhook(id, wh); err != nil {
+		log.Debug("API /webhook set webhook error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not set the webhook")))
+		return
+	}
+	render.Render(w, r, &webhookResponse{ID: id, Webhook: wh})
+}
+
+// deleteWebhook removes the authorized test's webhook.
+func (env *env) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := env.webhookTestID(w, r)
+	if !ok {
+		return
+	}
+	if err := env.strg.SetWebhook(id, app.Webhook{}); err != nil {
+		log.Debug("API /webhook delete webhook error: %v", err)
+		render.Render(w, r, errInternalServerError(errors.New("could not delete the webhook")))
+		return
+	}
+	render.Render(w, r, &webhookResponse{ID: id})
+}
+
+// webhookTestID returns the authorized test's id if tests are allowed to set their own
+// webhooks. Otherwise, it renders the error response.
+func (env *env) webhookTestID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if env.hooks == nil || !env.hooks.TestWebhooks() {
+		render.Render(w, r, errForbidden(errors.New("test webhooks are disabled")))
+		return "", false
+	}
+	id, ok := r.Context().Value(idCtxKey).(string)
+	if !ok || id == "" {
+		log.Info("API /webhook could not get authorization context keys from context")
+		render.Render(w, r, errUnauthorized(errors.New("internal authentication error")))
+		return "", false
+	}
+	return id, true
+}
+
+// validateWebhook checks the webhook is an absolute HTTP(S) URL and that its fields do
+// not exceed their maximum sizes. The returned errors are safe to be sent to clients.
+func validateWebhook(wh *app.Webhook) error {
+	if len(wh.URL) > webhookMaxURLLen {
+		return errors.New("webhook URL is too long")
+	}
+	if len(wh.Secret) > webhookMaxSecretLen {
+		return errors.New("webhook secret is too long")
+	}
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook URL must be an absolute http or https URL")
+	}
+	return nil
+}
+
+type webhookResponse struct {
+	ID      string      `json:"id"`
+	Webhook app.Webhook `json:"webhook"`
+}
+
+func (res *webhookResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,109 @@
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/api"
+	"github.com/ciphermarco/BOAST/webhook"
+)
+
+type webhookMockStorage struct {
+	mockStorage
+	id string
+	wh app.Webhook
+}
+
+func (s *webhookMockStorage) SetWebhook(id string, wh app.Webhook) error {
+	s.id, s.wh = id, wh
+	return nil
+}
+
+func newWebhookRequest(method, body string) (*http.Request, error) {
+	req, err := http.NewRequest(method, "/webhook", strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Secret %s", tB64TestSecret))
+	return req, nil
+}
+
+func TestSetWebhook(t *testing.T) {
+	req, err := newWebhookRequest("PUT", `{"url":"https://example.com/hook","secret":"s3cr3t"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &webhookMockStorage{}
+	hooks := webhook.New(&webhook.Config{TestWebhooks: true}, mockStrg)
+	rr := httptest.NewRecorder()
+	api.NewTestWebhookAPI(mockStrg, hooks).ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+
+	want := app.Webhook{URL: "https://example.com/hook", Secret: "s3cr3t"}
+	if want != mockStrg.wh {
+		t.Errorf("wrong webhook: %v (want) != %v (got)", want, mockStrg.wh)
+	}
+	if tTest.ID != mockStrg.id {
+		t.Errorf("wrong ID: %v (want) != %v (got)", tTest.ID, mockStrg.id)
+	}
+}
+
+func TestSetWebhookWrongURL(t *testing.T) {
+	for _, u := range []string{"", "example.com/hook", "ftp://example.com", "https://"} {
+		req, err := newWebhookRequest("PUT", fmt.Sprintf(`{"url":%q}`, u))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mockStrg := &webhookMockStorage{}
+		hooks := webhook.New(&webhook.Config{TestWebhooks: true}, mockStrg)
+		rr := httptest.NewRecorder()
+		api.NewTestWebhookAPI(mockStrg, hooks).ServeHTTP(rr, req)
+
+		checkStatusCode(http.StatusBadRequest, rr.Code, t)
+	}
+}
+
+func TestSetWebhookDisabled(t *testing.T) {
+	req, err := newWebhookRequest("PUT", `{"url":"https://example.com/hook"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &webhookMockStorage{}
+	hooks := webhook.New(&webhook.Config{URL: "https://example.com/global"}, mockStrg)
+	rr := httptest.NewRecorder()
+	api.NewTestWebhookAPI(mockStrg, hooks).ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusForbidden, rr.Code, t)
+
+	// Without a dispatcher, webhooks are disabled too.
+	rr = httptest.NewRecorder()
+	api.NewTestWebhookAPI(mockStrg, nil).ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusForbidden, rr.Code, t)
+}
+
+func TestDeleteWebhook(t *testing.T) {
+	req, err := newWebhookRequest("DELETE", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &webhookMockStorage{wh: app.Webhook{URL: "https://example.com/hook"}}
+	hooks := webhook.New(&webhook.Config{TestWebhooks: true}, mockStrg)
+	rr := httptest.NewRecorder()
+	api.NewTestWebhookAPI(mockStrg, hooks).ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+
+	if (app.Webhook{}) != mockStrg.wh {
+		t.Errorf("wrong webhook: %v (want) != %v (got)", app.Webhook{}, mockStrg.wh)
+	}
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// wsMaxTests is the maximum number of tests subscribed on a single connection.
+	wsMaxTests = 10_000
+	// wsBufferLen is the number of outgoing messages buffered for each connection.
+	wsBufferLen = 256
+)
+
+// WebSocket message types.
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsTests       = "tests"
+	wsEvent       = "event"
+	wsEnded       = "ended"
+	wsError       = "error"
+)
+
+// wsMessage represents the JSON messages exchanged over the events WebSocket.
+//
+// Clients send "subscribe" messages with the base64 secrets of the tests they want to
+// receive events for and "unsubscribe" messages with test ids. The server replies to
+// each "subscribe" with a "tests" message holding the id and canary of each secret in
+// the same order, and then sends an "event" message for each new event of the
+// subscribed tests. An "ended" message means the server stopped sending a test's events
+// because the client fell behind or unsubscribed.
+type wsMessage struct {
+	Type    string     `json:"type"`
+	Secrets []string   `json:"secrets,omitempty"`
+	IDs     []string   `json:"ids,omitempty"`
+	Tests   []wsTest   `json:"tests,omitempty"`
+	TestID  string     `json:"testID,omitempty"`
+	Event   *app.Event `json:"event,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+type wsTest struct {
+	ID     string `json:"id,omitempty"`
+	Canary string `json:"canary,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// eventsWebSocket serves the events WebSocket that multiplexes the events of many
+// tests over a single connection. See wsMessage for the protocol.
+//
+// Clients authenticate with secrets inside the connection instead of cookies or
+// headers, so any origin is accepted.
+func (env *env) eventsWebSocket(w http.ResponseWriter, r *http.Request) {
+	srv := websocket.Server{
+		Handshake: func(cfg *websocket.Config, r *http.Request) error { return nil },
+		Handler:   env.serveEventsWebSocket,
+	}
+	srv.ServeHTTP(w, r)
+}
+
+// wsConn holds the state of an events WebSocket connection.
+type wsConn struct {
+	env  *env
+	ws   *websocket.Conn
+	out  chan wsMessage
+	done chan struct{}
+	mu   sync.Mutex
+	subs map[string]*wsSub
+}
+
+type wsSub struct {
+	cancel func() (dropped int)
+}
+
+func (env *env) serveEventsWebSocket(ws *websocket.Conn) {
+	// WebSockets are long-lived, so they are exempted from the server's timeouts.
+	ws.SetDeadline(time.Time{})
+
+	c := &wsConn{
+		env:  env,
+		ws:   ws,
+		out:  make(chan wsMessage, wsBufferLen),
+		done: make(chan struct{}),
+		subs: make(map[string]*wsSub),
+	}
+	defer c.close()
+	go c.writeLoop()
+
+	for {
+		var msg wsMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			log.Debug("API events WebSocket receive error: %v", err)
+			return
+		}
+		switch msg.Type {
+		case wsSubscribe:
+			c.subscribe(msg.Secrets)
+		case wsUnsubscribe:
+			c.unsubscribe(msg.IDs)
+		default:
+			c.send(wsMessage{Type: wsError, Error: "unknown message type"})
+		}
+	}
+}
+
+// writeLoop writes the outgoing messages until the connection is closed.
+func (c *wsConn) writeLoop() {
+	for {
+		select {
+		case msg := <-c.out:
+			if err := websocket.JSON.Send(c.ws, &msg); err != nil {
+				log.Debug("API events WebSocket send error: %v", err)
+				c.ws.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// send queues a message to be written, blocking if the buffer is full.
+func (c *wsConn) send(msg wsMessage) bool {
+	select {
+	case c.out <- msg:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *wsConn) subscribe(secrets []string) {
+	res := wsMessage{Type: wsTests, Tests: make([]wsTest, len(secrets))}
+	for i, secret := range secrets {
+		id, canary, err := c.env.setTest(secret)
+		if err != nil {
+			res.Tests[i].Error = err.Error()
+			continue
+		}
+
+		c.mu.Lock()
+		if _, exists := c.subs[id]; !exists {
+			if len(c.subs) >= wsMaxTests {
+				c.mu.Unlock()
+				res.Tests[i].Error = "too many tests subscribed on this connection"
+				continue
+			}
+			evts, cancel := c.env.strg.Subscribe(id)
+			sub := &wsSub{cancel: cancel}
+			c.subs[id] = sub
+			go c.forward(id, sub, evts)
+		}
+		c.mu.Unlock()
+
+		res.Tests[i].ID, res.Tests[i].Canary = id, canary
+	}
+	c.send(res)
+}
+
+func (c *wsConn) unsubscribe(ids []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		if sub, exists := c.subs[id]; exists {
+			sub.cancel()
+		}
+	}
+}
+
+// forward sends a test's events to the client until its subscription ends.
+func (c *wsConn) forward(id string, sub *wsSub, evts <-chan app.Event) {
+	for evt := range evts {
+		if !c.send(wsMessage{Type: wsEvent, TestID: id, Event: &evt}) {
+			return
+		}
+	}
+
+	c.mu.Lock()
+	if c.subs[id] == sub {
+		delete(c.subs, id)
+	}
+	c.mu.Unlock()
+	sub.cancel()
+	c.send(wsMessage{Type: wsEnded, TestID: id})
+}
+
+func (c *wsConn) close() {
+	close(c.done)
+	c.mu.Lock()
+	for _, sub := range c.subs {
+		sub.cancel()
+	}
+	c.mu.Unlock()
+	c.ws.Close()
+}
@@ -0,0 +1,105 @@
+package api_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/api"
+
+	"golang.org/x/net/websocket"
+)
+
+type mockWSMessage struct {
+	Type    string     `json:"type"`
+	Secrets []string   `json:"secrets,omitempty"`
+	IDs     []string   `json:"ids,omitempty"`
+	Tests   []mockTest `json:"tests,omitempty"`
+	TestID  string     `json:"testID,omitempty"`
+	Event   *app.Event `json:"event,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+func dialTestWebSocket(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/events/ws"
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+	return ws
+}
+
+func TestEventsWebSocket(t *testing.T) {
+	mockStrg := &streamMockStorage{pub: make(chan app.Event, 1)}
+	srv := httptest.NewUnstartedServer(api.NewTestAPI("/test-status", mockStrg))
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	ws := dialTestWebSocket(t, srv)
+	defer ws.Close()
+
+	sub := mockWSMessage{Type: "subscribe", Secrets: []string{tB64TestSecret, "not base64"}}
+	if err := websocket.JSON.Send(ws, &sub); err != nil {
+		t.Fatal(err)
+	}
+
+	var res mockWSMessage
+	if err := websocket.JSON.Receive(ws, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Type != "tests" || len(res.Tests) != 2 {
+		t.Fatalf("wrong tests message: 2 tests (want) != %+v (got)", res)
+	}
+	if res.Tests[0].ID != tTest.ID || res.Tests[0].Canary != tTest.Canary {
+		t.Errorf("wrong test: %+v (want) != %+v (got)", tTest, res.Tests[0])
+	}
+	if res.Tests[1].ID != "" {
+		t.Errorf("wrong test for invalid secret: %v (want) != %v (got)", "", res.Tests[1].ID)
+	}
+
+	// The connection outlives the server's write timeout.
+	time.Sleep(2 * srv.Config.WriteTimeout)
+
+	published := app.Event{ID: "TEST ID published", Time: time.Now(), TestID: tTest.ID}
+	mockStrg.pub <- published
+
+	var msg mockWSMessage
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "event" || msg.TestID != tTest.ID || msg.Event == nil || msg.Event.ID != published.ID {
+		t.Errorf("wrong event message: %v (want) != %+v (got)", published.ID, msg)
+	}
+
+	// The client is told when a subscription ends.
+	close(mockStrg.pub)
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "ended" || msg.TestID != tTest.ID {
+		t.Errorf("wrong ended message: %v (want) != %+v (got)", tTest.ID, msg)
+	}
+}
+
+func TestEventsWebSocketUnknownType(t *testing.T) {
+	srv := httptest.NewServer(api.NewTestAPI("/test-status", &mockStorage{}))
+	defer srv.Close()
+
+	ws := dialTestWebSocket(t, srv)
+	defer ws.Close()
+
+	if err := websocket.JSON.Send(ws, &mockWSMessage{Type: "wrong"}); err != nil {
+		t.Fatal(err)
+	}
+	var msg mockWSMessage
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "error" || msg.Error == "" {
+		t.Errorf("wrong error message: error (want) != %+v (got)", msg)
+	}
+}
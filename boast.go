@@ -3,11 +3,15 @@ package boast
 import (
 	"crypto/rand"
 	"encoding/base32"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"strings"
 	"time"
 
 	"github.com/ciphermarco/BOAST/log"
+
+	"golang.org/x/crypto/nacl/box"
 )
 
 // Storage represents the BOAST's storage implementation.
@@ -16,13 +20,37 @@ import (
 type Storage interface {
 	SetTest(secret []byte) (id string, canary string, err error)
 	SearchTest(f func(k, v string) bool) (id string, canary string)
-	StoreEvent(evt Event) error
+	Recorder
 	LoadEvents(id string) (evts []Event, loaded bool)
+	LoadEventsRange(id string, rng EventsRange) (evts []Event, loaded bool)
+	DeleteEvents(id string) (deleted int, err error)
+	DeleteEvent(id, evtID string) (deleted bool, err error)
+	WaitEvents(id string) (notify <-chan struct{}, cancel func())
+	Subscribe(id string) (evts <-chan Event, cancel func() (dropped int))
+	SetWebhook(id string, wh Webhook) error
+	LoadWebhook(id string) (wh Webhook, loaded bool)
+	SetPublicKey(id string, key []byte) error
+	SetHTTPResponse(id string, res *HTTPResponse) error
+	LoadHTTPResponse(id string) (res HTTPResponse, loaded bool)
+	SetArtifact(id string, a Artifact) error
+	LoadArtifact(id, name string) (a Artifact, loaded bool)
+	DeleteArtifact(id, name string) (deleted bool, err error)
+	SetDNSRecords(id string, recs []DNSRecord) error
+	LoadDNSRecords(id string) (recs []DNSRecord, loaded bool)
+	SetRebinding(id string, rb *Rebinding) error
+	LoadRebinding(id string) (rb Rebinding, loaded bool)
 	TotalTests() int
 	TotalEvents() int
 	StartExpire(err chan error)
 }
 
+// Recorder represents the part of the storage used by the receivers that only record
+// the events of the tests found in the interactions.
+type Recorder interface {
+	LookupTest(s string) (id string, canary string)
+	StoreEvent(evt Event) error
+}
+
 // Event represents an interaction event.
 type Event struct {
 	ID         string    `json:"id"`
@@ -32,6 +60,50 @@ type Event struct {
 	RemoteAddr string    `json:"remoteAddress,omitempty"`
 	Dump       string    `json:"dump,omitempty"`
 	QueryType  string    `json:"queryType,omitempty"`
+	// Transport is the network of the interaction (e.g. "udp" or "tcp") if the
+	// receiver serves more than one.
+	Transport string `json:"transport,omitempty"`
+	// LocalAddr is the server's address that received the interaction if the receiver
+	// listens on many ports.
+	LocalAddr string `json:"localAddress,omitempty"`
+	// Answers are the DNS receiver's answers to the query.
+	Answers []string `json:"answers,omitempty"`
+	// HTTP holds the details of HTTP requests.
+	HTTP *HTTPInfo `json:"http,omitempty"`
+	// DNS holds the details of DNS queries.
+	DNS *DNSInfo `json:"dns,omitempty"`
+	// SMTP holds the envelope of SMTP messages.
+	SMTP *SMTPInfo `json:"smtp,omitempty"`
+	// LDAP holds the details of LDAP requests.
+	LDAP *LDAPInfo `json:"ldap,omitempty"`
+	// FTP holds the details of FTP sessions.
+	FTP *FTPInfo `json:"ftp,omitempty"`
+	// ClientHello holds the details of TLS ClientHello messages.
+	ClientHello *ClientHelloInfo `json:"clientHello,omitempty"`
+	// Sealed holds the sensitive fields encrypted by Seal.
+	Sealed string `json:"sealed,omitempty"`
+}
+
+// Before reports whether e happened before other.
+// Events are ordered by time and then by ID so the order is total even if two events
+// share the same time.
+func (e *Event) Before(other *Event) bool {
+	if e.Time.Equal(other.Time) {
+		return e.ID < other.ID
+	}
+	return e.Time.Before(other.Time)
+}
+
+// EventsRange represents a range of a test's events ordered as defined by Event.Before.
+// The zero value represents all the events.
+type EventsRange struct {
+	// SinceID selects the events after the event with this ID, even if the event was
+	// recently removed. If the event is unknown, this is ignored.
+	SinceID string
+	// SinceTime selects the events after this time.
+	SinceTime time.Time
+	// Limit is the maximum number of events selected. 0 means no limit.
+	Limit int
 }
 
 // String satisfies the Stringer interface for pretty-printing Event.
@@ -45,6 +117,235 @@ func (e *Event) String() string {
 	return string(s)
 }
 
+// KeySize is the size of the X25519 keys used by Seal and Open.
+const KeySize = 32
+
+// HTTPInfo represents the details of an HTTP request so clients don't need to parse the
+// event's dump.
+type HTTPInfo struct {
+	Method  string              `json:"method"`
+	Host    string              `json:"host"`
+	Path    string              `json:"path"`
+	Query   map[string][]string `json:"query,omitempty"`
+	Proto   string              `json:"proto"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Body    string              `json:"body,omitempty"`
+	// TLS holds the details of the TLS connection of HTTPS requests.
+	TLS *TLSInfo `json:"tls,omitempty"`
+}
+
+// TLSInfo represents the details of a TLS connection.
+type TLSInfo struct {
+	// Version is the TLS version (e.g. "TLS 1.3").
+	Version string `json:"version"`
+	// ServerName is the name sent by the client with SNI, if any.
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// DNSInfo represents the details of a DNS query, including the ones that help
+// fingerprinting the chain of resolvers that sent it.
+type DNSInfo struct {
+	// Name, Type and Class are the query's question.
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+	// Flags are the query's header flags that are set (e.g. "rd" and "cd").
+	Flags []string `json:"flags,omitempty"`
+	// ClientSubnet is the EDNS(0) client subnet (e.g. "198.51.100.0/24"), if sent.
+	ClientSubnet string `json:"clientSubnet,omitempty"`
+	// Cookie is the hex encoded DNS cookie, if sent.
+	Cookie string `json:"cookie,omitempty"`
+	// DNSSECOK reports whether the EDNS(0) DNSSEC OK bit was set.
+	DNSSECOK bool `json:"dnssecOK"`
+	// CasePattern is the query's name with its upper case letters replaced by "X" and
+	// the lower case ones by "x", showing any 0x20 bit encoding used by the resolver.
+	CasePattern string `json:"casePattern"`
+}
+
+// SMTPInfo represents the envelope of an SMTP message.
+type SMTPInfo struct {
+	// Helo is the name sent by the client with HELO or EHLO.
+	Helo string   `json:"helo,omitempty"`
+	From string   `json:"from"`
+	To   []string `json:"to"`
+	// TLS holds the details of the TLS connection if the client used STARTTLS.
+	TLS *TLSInfo `json:"tls,omitempty"`
+}
+
+// LDAPInfo represents the details of an LDAP request.
+type LDAPInfo struct {
+	// Operation is the request's operation: "bind" or "search".
+	Operation string `json:"operation"`
+	// DN is the bind request's name or the search request's base object.
+	DN string `json:"dn"`
+}
+
+// FTPInfo represents the details of an FTP session.
+type FTPInfo struct {
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Commands holds the CWD, RETR, and STOR commands in the order they were received.
+	Commands []string `json:"commands,omitempty"`
+	// DataAddrs holds the addresses sent by the client with PORT or EPRT.
+	DataAddrs []string `json:"dataAddrs,omitempty"`
+}
+
+// ClientHelloInfo represents the details of a TLS ClientHello message.
+// The GREASE values (RFC 8701) are left out of the lists.
+type ClientHelloInfo struct {
+	ServerName   string   `json:"serverName,omitempty"`
+	ALPN         []string `json:"alpn,omitempty"`
+	Versions     []string `json:"versions"`
+	CipherSuites []string `json:"cipherSuites"`
+	// JA3 is the client's JA3 fingerprint and JA3Hash is its MD5 hash.
+	JA3     string `json:"ja3"`
+	JA3Hash string `json:"ja3Hash"`
+	// JA4 is the client's JA4 fingerprint.
+	JA4 string `json:"ja4"`
+}
+
+// sealedFields represents the sensitive fields of Event encrypted by Seal.
+// Transport and LocalAddr are left in plaintext like QueryType, as they only describe
+// which of the server's listeners received the interaction.
+type sealedFields struct {
+	RemoteAddr  string           `json:"remoteAddress,omitempty"`
+	Dump        string           `json:"dump,omitempty"`
+	Answers     []string         `json:"answers,omitempty"`
+	HTTP        *HTTPInfo        `json:"http,omitempty"`
+	DNS         *DNSInfo         `json:"dns,omitempty"`
+	SMTP        *SMTPInfo        `json:"smtp,omitempty"`
+	LDAP        *LDAPInfo        `json:"ldap,omitempty"`
+	FTP         *FTPInfo         `json:"ftp,omitempty"`
+	ClientHello *ClientHelloInfo `json:"clientHello,omitempty"`
+}
+
+// Seal encrypts the event's sensitive fields to the X25519 public key pub so only the
+// holder of the matching private key can read them. The encrypted fields are cleared
+// and their base64 ciphertext is stored in Sealed.
+func (e *Event) Seal(pub *[KeySize]byte) error {
+	plain, err := json.Marshal(&sealedFields{
+		RemoteAddr:  e.RemoteAddr,
+		Dump:        e.Dump,
+		Answers:     e.Answers,
+		HTTP:        e.HTTP,
+		DNS:         e.DNS,
+		SMTP:        e.SMTP,
+		LDAP:        e.LDAP,
+		FTP:         e.FTP,
+		ClientHello: e.ClientHello,
+	})
+	if err != nil {
+		return err
+	}
+	sealed, err := box.SealAnonymous(nil, plain, pub, rand.Reader)
+	if err != nil {
+		return err
+	}
+	e.RemoteAddr, e.Dump, e.Answers = "", "", nil
+	e.HTTP, e.DNS, e.SMTP, e.LDAP, e.FTP = nil, nil, nil, nil, nil
+	e.ClientHello = nil
+	e.Sealed = base64.StdEncoding.EncodeToString(sealed)
+	return nil
+}
+
+// Open decrypts the fields encrypted by Seal with the X25519 key pair pub and priv and
+// restores them. Events that are not sealed are left untouched.
+func (e *Event) Open(pub, priv *[KeySize]byte) error {
+	if e.Sealed == "" {
+		return nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(e.Sealed)
+	if err != nil {
+		return err
+	}
+	plain, ok := box.OpenAnonymous(nil, sealed, pub, priv)
+	if !ok {
+		return errors.New("could not open the sealed fields")
+	}
+	var f sealedFields
+	if err := json.Unmarshal(plain, &f); err != nil {
+		return err
+	}
+	e.RemoteAddr, e.Dump, e.Answers = f.RemoteAddr, f.Dump, f.Answers
+	e.HTTP, e.DNS, e.SMTP, e.LDAP, e.FTP = f.HTTP, f.DNS, f.SMTP, f.LDAP, f.FTP
+	e.ClientHello = f.ClientHello
+	e.Sealed = ""
+	return nil
+}
+
+// Webhook represents a callback URL to be notified of a test's events.
+// The notifications are signed with Secret so the receiver can verify their authenticity.
+type Webhook struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+}
+
+// HTTPResponse represents a response served by the HTTP receiver to a test's requests.
+// The placeholders "{{id}}" and "{{canary}}" in Headers, Body and Location are replaced
+// by the test's id and canary.
+type HTTPResponse struct {
+	// Status is the response's status code. It defaults to 302 if Location is set and
+	// to 200 otherwise.
+	Status      int               `json:"status,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        string            `json:"body,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Location    string            `json:"location,omitempty"`
+	// Delay is the time waited before responding. It's at most HTTPResponseMaxDelay.
+	Delay time.Duration `json:"delay,omitempty"`
+}
+
+// HTTPResponseMaxDelay is the maximum HTTPResponse.Delay.
+const HTTPResponseMaxDelay = 8 * time.Second
+
+// Artifact represents a file served by the HTTP receiver to a test's requests whose URL
+// path ends with the artifact's name.
+type Artifact struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Data        []byte `json:"data"`
+}
+
+// ErrArtifactLimit is returned by Storage.SetArtifact when storing the artifact would
+// exceed the storage's limits.
+var ErrArtifactLimit = errors.New("artifact exceeds the storage limits")
+
+// DNSRecord represents a record served by the DNS receiver to the queries for a test's
+// names instead of the server's defaults.
+type DNSRecord struct {
+	// Type is the record's type: "A", "AAAA", "CNAME" or "TXT".
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	// TTL is the record's time to live in seconds. It defaults to 0 so resolvers don't
+	// cache the test's records.
+	TTL uint32 `json:"ttl,omitempty"`
+}
+
+// Rebinding represents a DNS rebinding policy for a test's names.
+// The DNS receiver answers the A or AAAA queries with First or Second, according to Mode,
+// and a TTL of 0 so each query reaches the server.
+type Rebinding struct {
+	First  string `json:"first"`
+	Second string `json:"second"`
+	// Mode is one of the Rebind* modes. It defaults to RebindAlternate.
+	Mode string `json:"mode,omitempty"`
+	// Count is the number of queries answered with First by RebindCount.
+	Count int `json:"count,omitempty"`
+	// Delay is the time since the first query during which RebindTime answers First.
+	Delay time.Duration `json:"delay,omitempty"`
+}
+
+// Rebinding modes.
+const (
+	// RebindAlternate alternates between First and Second at each query.
+	RebindAlternate = "alternate"
+	// RebindCount answers First to the first Count queries and Second afterwards.
+	RebindCount = "count"
+	// RebindTime answers First until Delay elapses since the first query and Second
+	// afterwards.
+	RebindTime = "time"
+)
+
 // NewEvent allocates a new Event struct and returns its copy.
 // The raison d'être of this function is to provide an easy interface to generate an
 // event with a standard ID without the caller having to deal with it.
@@ -89,6 +390,51 @@ func genEventID() (string, error) {
 	return res, nil
 }
 
+// IDLen is the length of the test ids generated by BOAST's storage.
+// Test ids are 16 bytes long values encoded by ToBase32.
+const IDLen = 26
+
+// ScanIDs tokenizes s into candidate test ids and calls f for each one of them until f
+// returns true or there are no more candidates. It returns true if f returned true.
+//
+// A candidate id is any sequence of IDLen characters from the base32 alphabet used by
+// ToBase32. Characters are lowercased before being passed to f so ids found in DNS
+// names randomized with 0x20 bit encoding are still recognised. The number of calls to
+// f is bounded by the length of s so callers can resolve candidates with map lookups.
+func ScanIDs(s string, f func(id string) bool) bool {
+	run := 0
+	lastUpper := -1
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' {
+			lastUpper = i
+			c += 'a' - 'A'
+		}
+		if !isBase32(c) {
+			run = 0
+			continue
+		}
+		run++
+		if run < IDLen {
+			continue
+		}
+		// Substrings of s are passed as they are unless they need lowercasing so the
+		// common case does not allocate.
+		id := s[i+1-IDLen : i+1]
+		if lastUpper > i-IDLen {
+			id = strings.ToLower(id)
+		}
+		if f(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBase32(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('2' <= c && c <= '7')
+}
+
 // ToBase32 encodes b to the base32 format used by BOAST's components.
 func ToBase32(b []byte) string {
 	enc := base32.StdEncoding.WithPadding(-1)
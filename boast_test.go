@@ -1,11 +1,16 @@
 package boast_test
 
 import (
+	"crypto/rand"
+	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	app "github.com/ciphermarco/BOAST"
+
+	"golang.org/x/crypto/nacl/box"
 )
 
 func TestNewEvent(t *testing.T) {
@@ -127,3 +132,92 @@ func TestToBase32(t *testing.T) {
 		}
 	}
 }
+
+func TestScanIDs(t *testing.T) {
+	id := "mpqhomfbxab55m5de32mywvfoy"
+	tests := map[string][]string{
+		"":                            nil,
+		"no ids in here":              nil,
+		id:                            {id},
+		id + ".example.com.":          {id},
+		"sub." + id + ".example.com.": {id},
+		"GET /" + id + " HTTP/1.1":    {id},
+		"MpQhOmFbXaB55m5De32MyWvFoY.example.com.": {id},
+		// 1 character longer than an id results in 2 candidates
+		"a" + id: {"ampqhomfbxab55m5de32mywvfo", id},
+		// 0, 1, 8, and 9 are not part of the base32 alphabet
+		strings.Replace(id, "5", "1", 1): nil,
+	}
+
+	for s, want := range tests {
+		var got []string
+		app.ScanIDs(s, func(candidate string) bool {
+			got = append(got, candidate)
+			return false
+		})
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("wrong candidates for %q: %v (want) != %v (got)", s, want, got)
+		}
+	}
+
+	wantFound := true
+	gotFound := app.ScanIDs("a"+id, func(candidate string) bool {
+		return candidate == id
+	})
+	if wantFound != gotFound {
+		t.Errorf("wrong found: %v (want) != %v (got)", wantFound, gotFound)
+	}
+}
+
+func TestEventSealOpen(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := app.Event{
+		ID:          "TEST ID",
+		Time:        time.Now(),
+		TestID:      "TEST TestID",
+		Receiver:    "TEST Receiver",
+		RemoteAddr:  "TEST RemoteAddr",
+		Dump:        "TEST Dump",
+		Answers:     []string{"secretdata.TEST.example.com.\t0\tIN\tA\t10.0.0.1"},
+		HTTP:        &app.HTTPInfo{Method: "GET", Headers: map[string][]string{"Cookie": {"s=1"}}},
+		DNS:         &app.DNSInfo{ClientSubnet: "198.51.100.0/24", CasePattern: "xXx.xxx."},
+		SMTP:        &app.SMTPInfo{From: "admin@example.com", To: []string{"TEST To"}},
+		LDAP:        &app.LDAPInfo{Operation: "bind", DN: "cn=admin"},
+		FTP:         &app.FTPInfo{User: "anonymous", DataAddrs: []string{"127.0.0.1:1024"}},
+		ClientHello: &app.ClientHelloInfo{ServerName: "example.com", JA4: "t13d1516h2_8daaf6152771_e5627efa2ab1"},
+	}
+
+	got := want
+	if err := got.Seal(pub); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	if got.RemoteAddr != "" || got.Dump != "" || got.Answers != nil || got.HTTP != nil || got.DNS != nil ||
+		got.SMTP != nil || got.LDAP != nil || got.FTP != nil || got.ClientHello != nil || got.Sealed == "" {
+		t.Errorf("fields not sealed: %v", &got)
+	}
+	// The query's name is nowhere in plaintext.
+	js, err := json.Marshal(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(js), "secretdata") {
+		t.Errorf("query name not sealed: %s", js)
+	}
+
+	// A different key pair can not open the event.
+	otherPub, otherPriv, _ := box.GenerateKey(rand.Reader)
+	wrong := got
+	if err := wrong.Open(otherPub, otherPriv); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+
+	if err := got.Open(pub, priv); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong event: %v (want) != %v (got)", &want, &got)
+	}
+}
@@ -0,0 +1,415 @@
+// Package certmgr provides the servers' TLS certificates without restarting them. They
+// are either obtained and renewed from an ACME CA (e.g. Let's Encrypt) using DNS-01
+// challenges answered by BOAST's own DNS receiver or reloaded from files when they
+// change.
+package certmgr
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ciphermarco/BOAST/log"
+
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	defaultRenewBefore = 30 * 24 * time.Hour
+	// checkInterval is the interval for checking if the certificate must be renewed.
+	checkInterval = 12 * time.Hour
+	// retryInterval is the wait before trying again after failing to obtain a
+	// certificate.
+	retryInterval = time.Hour
+	obtainTimeout = 10 * time.Minute
+
+	accountKeyFile = "account.key"
+	certFile       = "cert.pem"
+	keyFile        = "key.pem"
+)
+
+// letsEncryptCAA is Let's Encrypt's CAA issuer domain, used by its staging directory too.
+const letsEncryptCAA = "letsencrypt.org"
+
+var errNoCertificate = errors.New("no certificate available")
+
+// Source provides the TLS certificates for the servers.
+type Source interface {
+	// GetCertificate is meant to be used as the tls.Config's GetCertificate.
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	// Certificates returns the information of the current certificates.
+	Certificates() []Info
+}
+
+// Info describes a certificate.
+type Info struct {
+	Names    []string  `json:"names"`
+	NotAfter time.Time `json:"notAfter"`
+}
+
+func newInfo(leaf *x509.Certificate) Info {
+	return Info{Names: leaf.DNSNames, NotAfter: leaf.NotAfter}
+}
+
+// Manager obtains a certificate for a domain and its subdomains and renews it before it
+// expires. The DNS-01 challenges are answered by the DNS receiver via ChallengeTXT, so
+// the DNS receiver must be the domain's nameserver.
+type Manager struct {
+	Domain string
+	// Names are the names under Domain not covered by its wildcard (e.g.
+	// "api.sub.example.com") to be included in the certificate too.
+	Names []string
+	Email string
+	// DirectoryURL is the ACME CA's directory. It defaults to Let's Encrypt's.
+	DirectoryURL string
+	// CacheDir is the directory keeping the ACME account key and the certificate.
+	CacheDir string
+	// RenewBefore is how long before the certificate expires it's renewed.
+	// It defaults to 30 days.
+	RenewBefore time.Duration
+	// HTTPClient is the client used to talk to the CA. It defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+
+	challengesMu sync.RWMutex
+	challenges   map[string][]string
+}
+
+// Start loads the cached certificate and then obtains a new one whenever it's missing
+// or about to expire. Failures to obtain a certificate are logged and retried.
+//
+// Any errors are returned via the received channel.
+func (m *Manager) Start(err chan error) {
+	if e := os.MkdirAll(m.CacheDir, 0700); e != nil {
+		err <- e
+		return
+	}
+	if e := m.load(); e != nil && !errors.Is(e, os.ErrNotExist) {
+		log.Info("Could not load the cached certificate")
+		log.Debug("Load certificate error: %v", e)
+	}
+	for {
+		wait := checkInterval
+		if m.needsRenewal() {
+			log.Info("Obtaining a certificate for %s", m.Domain)
+			ctx, cancel := context.WithTimeout(context.Background(), obtainTimeout)
+			if e := m.obtain(ctx); e != nil {
+				log.Info("Could not obtain a certificate")
+				log.Debug("Obtain certificate error: %v", e)
+				wait = retryInterval
+			} else {
+				log.Info("New certificate obtained for %s", m.Domain)
+			}
+			cancel()
+		}
+		time.Sleep(wait)
+	}
+}
+
+// GetCertificate returns the current certificate. It's meant to be used as the
+// tls.Config's GetCertificate so new certificates are used without restarting the
+// servers.
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return nil, errNoCertificate
+	}
+	return m.cert, nil
+}
+
+// Certificates returns the information of the current certificate, if any.
+func (m *Manager) Certificates() []Info {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return nil
+	}
+	return []Info{newInfo(m.cert.Leaf)}
+}
+
+// ChallengeTXT returns the TXT records of the pending DNS-01 challenges for name, which
+// must be a lower case fully qualified domain name.
+func (m *Manager) ChallengeTXT(name string) []string {
+	m.challengesMu.RLock()
+	defer m.challengesMu.RUnlock()
+	return m.challenges[name]
+}
+
+// CAAIssuer returns the domain identifying the CA in CAA records if it's known (i.e. for
+// Let's Encrypt's directories) or an empty string otherwise.
+func (m *Manager) CAAIssuer() string {
+	if m.DirectoryURL == "" {
+		return letsEncryptCAA
+	}
+	u, err := url.Parse(m.DirectoryURL)
+	if err != nil {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == letsEncryptCAA || strings.HasSuffix(host, "."+letsEncryptCAA) {
+		return letsEncryptCAA
+	}
+	return ""
+}
+
+func (m *Manager) addChallenge(name, txt string) {
+	m.challengesMu.Lock()
+	defer m.challengesMu.Unlock()
+	if m.challenges == nil {
+		m.challenges = make(map[string][]string)
+	}
+	m.challenges[name] = append(m.challenges[name], txt)
+}
+
+func (m *Manager) removeChallenge(name, txt string) {
+	m.challengesMu.Lock()
+	defer m.challengesMu.Unlock()
+	txts := m.challenges[name]
+	for i, t := range txts {
+		if t == txt {
+			txts = append(txts[:i:i], txts[i+1:]...)
+			break
+		}
+	}
+	if len(txts) == 0 {
+		delete(m.challenges, name)
+	} else {
+		m.challenges[name] = txts
+	}
+}
+
+// needsRenewal reports whether there's no certificate, it expires within RenewBefore, or
+// it does not cover all the Names.
+func (m *Manager) needsRenewal() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	renewBefore := m.RenewBefore
+	if renewBefore <= 0 {
+		renewBefore = defaultRenewBefore
+	}
+	if m.cert == nil || time.Until(m.cert.Leaf.NotAfter) < renewBefore {
+		return true
+	}
+	for _, name := range m.Names {
+		if m.cert.Leaf.VerifyHostname(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// load loads the cached certificate.
+func (m *Manager) load() error {
+	cert, err := tls.LoadX509KeyPair(m.path(certFile), m.path(keyFile))
+	if err != nil {
+		return err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.cert = &cert
+	m.mu.Unlock()
+	return nil
+}
+
+// obtain obtains a certificate for the domain, its subdomains, and the other names,
+// caches it, and makes it the current one.
+func (m *Manager) obtain(ctx context.Context) error {
+	key, err := m.accountKey()
+	if err != nil {
+		return err
+	}
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: m.DirectoryURL,
+		HTTPClient:   m.HTTPClient,
+		UserAgent:    "BOAST",
+	}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = acme.LetsEncryptURL
+	}
+	acct := &acme.Account{}
+	if m.Email != "" {
+		acct.Contact = []string{"mailto:" + m.Email}
+	}
+	if _, err := client.Register(ctx, acct, acme.AcceptTOS); err != nil &&
+		!errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("registering account: %w", err)
+	}
+
+	domains := append([]string{m.Domain, "*." + m.Domain}, m.Names...)
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return fmt.Errorf("creating order: %w", err)
+	}
+	if err := m.authorize(ctx, client, order.AuthzURLs); err != nil {
+		return err
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return fmt.Errorf("waiting order: %w", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: domains,
+	}, certKey)
+	if err != nil {
+		return err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("finalizing order: %w", err)
+	}
+	return m.save(chain, certKey)
+}
+
+// authorize completes the DNS-01 challenges of the order's pending authorizations.
+// All the challenges' TXT records are served at once as the domain and its wildcard
+// share the same _acme-challenge name.
+func (m *Manager) authorize(ctx context.Context, client *acme.Client, authzURLs []string) error {
+	var pending []*acme.Authorization
+	var chals []*acme.Challenge
+	for _, u := range authzURLs {
+		z, err := client.GetAuthorization(ctx, u)
+		if err != nil {
+			return fmt.Errorf("getting authorization: %w", err)
+		}
+		if z.Status == acme.StatusValid {
+			continue
+		}
+		var chal *acme.Challenge
+		for _, c := range z.Challenges {
+			if c.Type == "dns-01" {
+				chal = c
+			}
+		}
+		if chal == nil {
+			return fmt.Errorf("no dns-01 challenge for %s", z.Identifier.Value)
+		}
+		txt, err := client.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			return err
+		}
+		name := "_acme-challenge." + strings.ToLower(z.Identifier.Value) + "."
+		m.addChallenge(name, txt)
+		defer m.removeChallenge(name, txt)
+		pending = append(pending, z)
+		chals = append(chals, chal)
+	}
+
+	for _, chal := range chals {
+		if _, err := client.Accept(ctx, chal); err != nil {
+			return fmt.Errorf("accepting challenge: %w", err)
+		}
+	}
+	for _, z := range pending {
+		if _, err := client.WaitAuthorization(ctx, z.URI); err != nil {
+			return fmt.Errorf("authorizing %s: %w", z.Identifier.Value, err)
+		}
+	}
+	return nil
+}
+
+// save caches the certificate chain and its key and makes them the current
+// certificate.
+func (m *Manager) save(chain [][]byte, key *ecdsa.PrivateKey) error {
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return err
+	}
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	// A failure to write either file leaves the cached key and certificate untouched. A
+	// crash between the renames leaves a pair that fails to load, so a new certificate
+	// is obtained.
+	err = writeFiles(map[string][]byte{m.path(keyFile): keyPEM, m.path(certFile): certPEM})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.cert = &tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}
+	m.mu.Unlock()
+	return nil
+}
+
+// accountKey loads the cached ACME account key or generates and caches a new one.
+func (m *Manager) accountKey() (crypto.Signer, error) {
+	b, err := os.ReadFile(m.path(accountKeyFile))
+	if err == nil {
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, errors.New("invalid account key")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := writeFiles(map[string][]byte{m.path(accountKeyFile): keyPEM}); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (m *Manager) path(name string) string {
+	return filepath.Join(m.CacheDir, name)
+}
+
+// writeFiles atomically replaces each file at the files' paths with their data readable
+// only by their owner. All the files are written before any of them is replaced.
+func writeFiles(files map[string][]byte) error {
+	for path, data := range files {
+		if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+			for path := range files {
+				os.Remove(path + ".tmp")
+			}
+			return err
+		}
+	}
+	for path := range files {
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package certmgr_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ciphermarco/BOAST/certmgr"
+	"github.com/ciphermarco/BOAST/log"
+	"github.com/ciphermarco/BOAST/receivers/dnsrcv"
+	"github.com/ciphermarco/BOAST/storage"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func TestChallengeTXT(t *testing.T) {
+	m := &certmgr.Manager{Domain: "example.com"}
+	name := "_acme-challenge.example.com."
+
+	m.AddChallenge(name, "first")
+	m.AddChallenge(name, "second")
+	if want, got := []string{"first", "second"}, m.ChallengeTXT(name); !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong TXT: %v (want) != %v (got)", want, got)
+	}
+
+	m.RemoveChallenge(name, "first")
+	if want, got := []string{"second"}, m.ChallengeTXT(name); !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong TXT: %v (want) != %v (got)", want, got)
+	}
+
+	m.RemoveChallenge(name, "second")
+	if got := m.ChallengeTXT(name); len(got) != 0 {
+		t.Errorf("wrong TXT: %v (want) != %v (got)", nil, got)
+	}
+}
+
+func TestCAAIssuer(t *testing.T) {
+	tests := []struct {
+		directoryURL string
+		want         string
+	}{
+		{"", "letsencrypt.org"},
+		{"https://acme-v02.api.letsencrypt.org/directory", "letsencrypt.org"},
+		{"https://acme-staging-v02.api.letsencrypt.org/directory", "letsencrypt.org"},
+		{"https://acme.example.net/directory", ""},
+		{"https://letsencrypt.org.example.net/directory", ""},
+	}
+	for _, tt := range tests {
+		m := &certmgr.Manager{Domain: "example.com", DirectoryURL: tt.directoryURL}
+		if got := m.CAAIssuer(); got != tt.want {
+			t.Errorf("wrong issuer for %q: %v (want) != %v (got)", tt.directoryURL, tt.want, got)
+		}
+	}
+}
+
+func TestGetCertificateNone(t *testing.T) {
+	m := &certmgr.Manager{Domain: "example.com", CacheDir: t.TempDir()}
+
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+	if !m.NeedsRenewal() {
+		t.Errorf("wrong renewal: %v (want) != %v (got)", true, false)
+	}
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadCachedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	copyFile(t, "../testdata/cert.pem", filepath.Join(dir, "cert.pem"))
+	copyFile(t, "../testdata/key.pem", filepath.Join(dir, "key.pem"))
+	m := &certmgr.Manager{Domain: "example.com", CacheDir: dir}
+
+	if err := m.Load(); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	if cert.Leaf == nil {
+		t.Fatal("leaf not parsed")
+	}
+
+	// The test certificate expires in 2030.
+	if m.NeedsRenewal() {
+		t.Errorf("wrong renewal: %v (want) != %v (got)", false, true)
+	}
+	m.RenewBefore = time.Until(cert.Leaf.NotAfter) + time.Hour
+	if !m.NeedsRenewal() {
+		t.Errorf("wrong renewal: %v (want) != %v (got)", true, false)
+	}
+
+	// A certificate not covering all the names is renewed too.
+	m.RenewBefore = 0
+	m.Names = []string{"api.sub.example.com"}
+	if !m.NeedsRenewal() {
+		t.Errorf("wrong renewal: %v (want) != %v (got)", true, false)
+	}
+}
+
+// TestObtainPebble obtains a certificate from a Pebble ACME test server
+// (https://github.com/letsencrypt/pebble), answering its DNS-01 challenges with a DNS
+// receiver. It only runs if the following environment variables are set:
+//
+//   - BOAST_TEST_ACME_DIRECTORY: Pebble's directory URL (e.g. https://127.0.0.1:14000/dir)
+//   - BOAST_TEST_ACME_CA: Pebble's HTTPS CA certificate file (e.g. test/certs/pebble.minica.pem)
+//   - BOAST_TEST_ACME_DNS: the address for the DNS receiver, which must be Pebble's
+//     -dnsserver (e.g. 127.0.0.1:8053)
+func TestObtainPebble(t *testing.T) {
+	dirURL := os.Getenv("BOAST_TEST_ACME_DIRECTORY")
+	caPath := os.Getenv("BOAST_TEST_ACME_CA")
+	dnsAddr := os.Getenv("BOAST_TEST_ACME_DNS")
+	if dirURL == "" || caPath == "" || dnsAddr == "" {
+		t.Skip("BOAST_TEST_ACME_DIRECTORY, BOAST_TEST_ACME_CA, or BOAST_TEST_ACME_DNS not set")
+	}
+
+	ca, err := os.ReadFile(caPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		t.Fatal("invalid CA certificate")
+	}
+	domain := "boast.test"
+	m := &certmgr.Manager{
+		Domain:       domain,
+		Email:        "admin@boast.test",
+		DirectoryURL: dirURL,
+		CacheDir:     t.TempDir(),
+		HTTPClient: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		}},
+	}
+
+	host, p, err := net.SplitHostPort(dnsAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strg, err := storage.New(&storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsRcv := &dnsrcv.Receiver{
+		Name:       "DNS receiver",
+		Domain:     domain,
+		Host:       host,
+		Ports:      []int{port},
+		PublicIP:   "127.0.0.1",
+		Storage:    strg,
+		Challenges: m,
+	}
+	errc := make(chan error, 2)
+	dnsRcv.ListenAndServe(errc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err := m.Obtain(ctx); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	names := append([]string{}, cert.Leaf.DNSNames...)
+	sort.Strings(names)
+	if want := []string{"*." + domain, domain}; !reflect.DeepEqual(want, names) {
+		t.Errorf("wrong DNS names: %v (want) != %v (got)", want, names)
+	}
+	if got := m.ChallengeTXT("_acme-challenge." + domain + "."); len(got) != 0 {
+		t.Errorf("challenges not removed: %v", got)
+	}
+
+	// The certificate is cached.
+	cached := &certmgr.Manager{Domain: domain, CacheDir: m.CacheDir}
+	if err := cached.Load(); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+}
@@ -0,0 +1,27 @@
+package certmgr
+
+import "context"
+
+func (m *Manager) Load() error {
+	return m.load()
+}
+
+func (m *Manager) NeedsRenewal() bool {
+	return m.needsRenewal()
+}
+
+func (m *Manager) AddChallenge(name, txt string) {
+	m.addChallenge(name, txt)
+}
+
+func (m *Manager) RemoveChallenge(name, txt string) {
+	m.removeChallenge(name, txt)
+}
+
+func (m *Manager) Obtain(ctx context.Context) error {
+	return m.obtain(ctx)
+}
+
+func (f *Files) Reload(force bool) (bool, error) {
+	return f.reload(force)
+}
@@ -0,0 +1,155 @@
+package certmgr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ciphermarco/BOAST/log"
+)
+
+const defaultPollInterval = time.Minute
+
+// Pair is a PEM encoded certificate file and its private key file.
+type Pair struct {
+	CertPath string
+	KeyPath  string
+}
+
+// Files provides the certificates loaded from files and reloads them when the files
+// change or the process receives a SIGHUP, so certificates can be rotated without
+// restarting the servers. The certificate for each connection is selected by SNI.
+type Files struct {
+	Pairs []Pair
+	// PollInterval is the interval for checking if the files changed.
+	// It defaults to 1 minute.
+	PollInterval time.Duration
+
+	mu    sync.RWMutex
+	certs []*tls.Certificate
+	stamp string
+}
+
+// Load loads the certificates. It fails if any of them can't be loaded.
+func (f *Files) Load() error {
+	_, err := f.reload(true)
+	return err
+}
+
+// Watch reloads the certificates whenever the files change or the process receives a
+// SIGHUP. If any of the certificates can't be reloaded, the current ones are kept and
+// the reload is tried again on the next change.
+func (f *Files) Watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	interval := f.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		force := false
+		select {
+		case <-hup:
+			force = true
+		case <-ticker.C:
+		}
+		reloaded, err := f.reload(force)
+		if err != nil {
+			log.Info("Could not reload the TLS certificates")
+			log.Debug("Reload certificates error: %v", err)
+		} else if reloaded {
+			log.Info("TLS certificates reloaded")
+		}
+	}
+}
+
+// GetCertificate returns the first certificate valid for the ClientHello's server name
+// or, if there's none, the first certificate. It's meant to be used as the tls.Config's
+// GetCertificate.
+func (f *Files) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if len(f.certs) == 0 {
+		return nil, errNoCertificate
+	}
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if name != "" {
+		for _, cert := range f.certs {
+			if cert.Leaf.VerifyHostname(name) == nil {
+				return cert, nil
+			}
+		}
+	}
+	return f.certs[0], nil
+}
+
+// Certificates returns the information of the current certificates.
+func (f *Files) Certificates() []Info {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	infos := make([]Info, 0, len(f.certs))
+	for _, cert := range f.certs {
+		infos = append(infos, newInfo(cert.Leaf))
+	}
+	return infos
+}
+
+// reload loads the certificates and replaces the current ones if the files changed since
+// the last reload or force is true. It reports whether the certificates were replaced.
+func (f *Files) reload(force bool) (bool, error) {
+	stamp, err := f.filesStamp()
+	if err != nil {
+		return false, err
+	}
+	f.mu.RLock()
+	changed := stamp != f.stamp
+	f.mu.RUnlock()
+	if !changed && !force {
+		return false, nil
+	}
+
+	certs := make([]*tls.Certificate, 0, len(f.Pairs))
+	for _, p := range f.Pairs {
+		cert, err := tls.LoadX509KeyPair(p.CertPath, p.KeyPath)
+		if err != nil {
+			return false, err
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return false, err
+		}
+		certs = append(certs, &cert)
+	}
+	if len(certs) == 0 {
+		return false, errors.New("no certificate files")
+	}
+
+	f.mu.Lock()
+	f.certs, f.stamp = certs, stamp
+	f.mu.Unlock()
+	return true, nil
+}
+
+// filesStamp returns a string that changes whenever any of the files is modified.
+func (f *Files) filesStamp() (string, error) {
+	var b strings.Builder
+	for _, p := range f.Pairs {
+		for _, path := range []string{p.CertPath, p.KeyPath} {
+			fi, err := os.Stat(path)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "%s:%d:%d;", path, fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,148 @@
+package certmgr_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ciphermarco/BOAST/certmgr"
+)
+
+// writeTestCert writes a self-signed certificate for names expiring at notAfter and its
+// key to dir/name.pem and dir/name.key.
+func writeTestCert(t *testing.T, dir, name string, names []string, notAfter time.Time) certmgr.Pair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := certmgr.Pair{
+		CertPath: filepath.Join(dir, name+".pem"),
+		KeyPath:  filepath.Join(dir, name+".key"),
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(p.CertPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p.KeyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestFilesSNI(t *testing.T) {
+	dir := t.TempDir()
+	notAfter := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	f := &certmgr.Files{Pairs: []certmgr.Pair{
+		writeTestCert(t, dir, "receiver", []string{"example.com", "*.example.com"}, notAfter),
+		writeTestCert(t, dir, "api", []string{"api.example.net"}, notAfter),
+	}}
+	if err := f.Load(); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"API.example.net", "api.example.net"},
+		{"api.example.net.", "api.example.net"},
+		{"sub.example.com", "example.com"},
+		{"example.com", "example.com"},
+		{"other.example.org", "example.com"},
+		{"", "example.com"},
+	}
+	for _, tt := range tests {
+		cert, err := f.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+		if err != nil {
+			t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+		}
+		if got := cert.Leaf.DNSNames[0]; got != tt.want {
+			t.Errorf("wrong certificate for %q: %v (want) != %v (got)", tt.serverName, tt.want, got)
+		}
+	}
+
+	want := []certmgr.Info{
+		{Names: []string{"example.com", "*.example.com"}, NotAfter: notAfter.UTC()},
+		{Names: []string{"api.example.net"}, NotAfter: notAfter.UTC()},
+	}
+	if got := f.Certificates(); !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong certificates: %v (want) != %v (got)", want, got)
+	}
+}
+
+func TestFilesReload(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"example.com"}
+	first := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	p := writeTestCert(t, dir, "cert", names, first)
+	f := &certmgr.Files{Pairs: []certmgr.Pair{p}}
+	if err := f.Load(); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	// Unchanged files aren't reloaded.
+	if reloaded, err := f.Reload(false); err != nil || reloaded {
+		t.Errorf("wrong reload: %v, %v (want) != %v, %v (got)", false, nil, reloaded, err)
+	}
+
+	second := first.Add(time.Hour)
+	writeTestCert(t, dir, "cert", names, second)
+	// Make sure the change is noticed even if the modification time's resolution is low.
+	mtime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(p.CertPath, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := f.Reload(false); err != nil || !reloaded {
+		t.Errorf("wrong reload: %v, %v (want) != %v, %v (got)", true, nil, reloaded, err)
+	}
+	if got := f.Certificates()[0].NotAfter; !got.Equal(second) {
+		t.Errorf("wrong expiry: %v (want) != %v (got)", second, got)
+	}
+
+	// A broken key keeps the current certificate.
+	if err := os.WriteFile(p.KeyPath, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Reload(true); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+	if got := f.Certificates()[0].NotAfter; !got.Equal(second) {
+		t.Errorf("wrong expiry: %v (want) != %v (got)", second, got)
+	}
+}
+
+func TestFilesMissing(t *testing.T) {
+	f := &certmgr.Files{Pairs: []certmgr.Pair{{CertPath: "missing.pem", KeyPath: "missing.key"}}}
+	if err := f.Load(); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+	if _, err := f.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Package client provides helpers for BOAST's clients.
+//
+// Clients that do not want the server to keep their events' dumps in plaintext can
+// generate a key pair with GenerateKey, register the public key with the API's /key
+// endpoint and decrypt the events they receive with DecryptEvent.
+package client
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+
+	app "github.com/ciphermarco/BOAST"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// KeyPair represents an X25519 key pair used to decrypt sealed events.
+type KeyPair struct {
+	Public  [app.KeySize]byte
+	Private [app.KeySize]byte
+}
+
+// GenerateKey generates a new random key pair.
+func GenerateKey() (*KeyPair, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{Public: *pub, Private: *priv}, nil
+}
+
+// ParseKey parses the base64 private key encoded by PrivateKeyString.
+func ParseKey(b64priv string) (*KeyPair, error) {
+	priv, err := base64.StdEncoding.DecodeString(b64priv)
+	if err != nil {
+		return nil, err
+	}
+	if len(priv) != app.KeySize {
+		return nil, errors.New("wrong private key size")
+	}
+	var k KeyPair
+	copy(k.Private[:], priv)
+	pub, err := publicKey(&k.Private)
+	if err != nil {
+		return nil, err
+	}
+	k.Public = *pub
+	return &k, nil
+}
+
+// PublicKeyString returns the base64 public key as expected by the API's /key endpoint.
+func (k *KeyPair) PublicKeyString() string {
+	return base64.StdEncoding.EncodeToString(k.Public[:])
+}
+
+// PrivateKeyString returns the base64 private key so it can be saved and parsed later
+// with ParseKey.
+func (k *KeyPair) PrivateKeyString() string {
+	return base64.StdEncoding.EncodeToString(k.Private[:])
+}
+
+// DecryptEvent decrypts the sealed fields of evt in place.
+// Events that are not sealed are left untouched.
+func (k *KeyPair) DecryptEvent(evt *app.Event) error {
+	return evt.Open(&k.Public, &k.Private)
+}
+
+// DecryptEvents decrypts the sealed fields of each event in evts in place.
+// It stops at the first event that can not be decrypted.
+func (k *KeyPair) DecryptEvents(evts []app.Event) error {
+	for i := range evts {
+		if err := k.DecryptEvent(&evts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publicKey derives the X25519 public key of priv.
+func publicKey(priv *[app.KeySize]byte) (*[app.KeySize]byte, error) {
+	b, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	var pub [app.KeySize]byte
+	copy(pub[:], b)
+	return &pub, nil
+}
@@ -0,0 +1,62 @@
+package client_test
+
+import (
+	"testing"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/client"
+)
+
+func TestParseKey(t *testing.T) {
+	k, err := client.GenerateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	got, err := client.ParseKey(k.PrivateKeyString())
+	if err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	if *k != *got {
+		t.Errorf("wrong key pair: %v (want) != %v (got)", k, got)
+	}
+
+	for _, wrong := range []string{"not base64", "AAAA"} {
+		if _, err := client.ParseKey(wrong); err == nil {
+			t.Errorf("did not fail: error (want) != %v (got)", err)
+		}
+	}
+}
+
+func TestDecryptEvents(t *testing.T) {
+	k, err := client.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed := app.Event{
+		ID:         "TEST ID",
+		Time:       time.Now(),
+		RemoteAddr: "203.0.113.113",
+		Dump:       "TEST Dump",
+	}
+	if err := sealed.Seal(&k.Public); err != nil {
+		t.Fatal(err)
+	}
+	plain := app.Event{ID: "TEST ID 2", Dump: "TEST Dump 2"}
+	evts := []app.Event{sealed, plain}
+
+	if err := k.DecryptEvents(evts); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	wantDumps := []string{"TEST Dump", "TEST Dump 2"}
+	for i, want := range wantDumps {
+		if want != evts[i].Dump {
+			t.Errorf("wrong dump: %v (want) != %v (got)", want, evts[i].Dump)
+		}
+	}
+	if evts[0].Sealed != "" {
+		t.Errorf("wrong sealed: %v (want) != %v (got)", "", evts[0].Sealed)
+	}
+}
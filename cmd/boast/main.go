@@ -5,13 +5,23 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	app "github.com/ciphermarco/BOAST"
 	"github.com/ciphermarco/BOAST/api"
+	"github.com/ciphermarco/BOAST/certmgr"
 	"github.com/ciphermarco/BOAST/config"
 	"github.com/ciphermarco/BOAST/log"
 	"github.com/ciphermarco/BOAST/receivers/dnsrcv"
+	"github.com/ciphermarco/BOAST/receivers/ftprcv"
 	"github.com/ciphermarco/BOAST/receivers/httprcv"
+	"github.com/ciphermarco/BOAST/receivers/ldaprcv"
+	"github.com/ciphermarco/BOAST/receivers/rawrcv"
+	"github.com/ciphermarco/BOAST/receivers/smtprcv"
 	"github.com/ciphermarco/BOAST/storage"
+	"github.com/ciphermarco/BOAST/webhook"
 
 	"github.com/BurntSushi/toml"
 )
@@ -73,17 +83,64 @@ func main() {
 		log.Fatalln("Failed to parse configuration:", err)
 	}
 
-	strg, err := storage.New(&storage.Config{
-		TTL:             cfg.Strg.Expire.TTL.Value(),
-		CheckInterval:   cfg.Strg.Expire.CheckInterval.Value(),
-		MaxRestarts:     cfg.Strg.Expire.MaxRestarts,
-		MaxEvents:       cfg.Strg.MaxEvents,
-		MaxEventsByTest: cfg.Strg.MaxEventsByTest,
-		MaxDumpSize:     cfg.Strg.MaxDumpSize.Value(),
-		HMACKey:         cfg.Strg.HMACKey,
-	})
-	if err != nil {
-		log.Fatalln("Failed to create storage:", err)
+	strgCfg := &storage.Config{
+		TTL:                cfg.Strg.Expire.TTL.Value(),
+		CheckInterval:      cfg.Strg.Expire.CheckInterval.Value(),
+		MaxRestarts:        cfg.Strg.Expire.MaxRestarts,
+		MaxEvents:          cfg.Strg.MaxEvents,
+		MaxEventsByTest:    cfg.Strg.MaxEventsByTest,
+		MaxDumpSize:        cfg.Strg.MaxDumpSize.Value(),
+		HMACKey:            cfg.Strg.HMACKey,
+		MaxArtifactsByTest: cfg.Strg.MaxArtifacts,
+		MaxArtifactSize:    cfg.Strg.MaxArtifactSize.Value(),
+		SyncInterval:       cfg.Strg.Disk.SyncInterval.Value(),
+	}
+	var strg app.Storage
+	var closeStrg func() error
+	switch cfg.Strg.Backend {
+	case "", config.MemoryBackend:
+		memStrg, err := storage.New(strgCfg)
+		if err != nil {
+			log.Fatalln("Failed to create storage:", err)
+		}
+		if p := cfg.Strg.Snapshot.Path; p != "" {
+			if err := memStrg.LoadSnapshot(p); err != nil {
+				log.Fatalln("Failed to restore storage snapshot:", err)
+			}
+			if i := cfg.Strg.Snapshot.Interval.Value(); i > 0 {
+				go memStrg.StartSnapshots(p, i)
+			}
+			closeStrg = func() error { return memStrg.SaveSnapshot(p) }
+		}
+		strg = memStrg
+	case config.DiskBackend:
+		if cfg.Strg.Disk.Path == "" {
+			log.Fatalln("Failed to create storage: the disk backend requires a path")
+		}
+		diskStrg, err := storage.NewDisk(strgCfg, cfg.Strg.Disk.Path)
+		if err != nil {
+			log.Fatalln("Failed to create storage:", err)
+		}
+		closeStrg = diskStrg.Close
+		strg = diskStrg
+	default:
+		log.Fatalln("Failed to create storage: unknown backend", cfg.Strg.Backend)
+	}
+
+	var hooks *webhook.Dispatcher
+	if cfg.Webhook.URL != "" || cfg.Webhook.PerTest {
+		hooks = webhook.New(&webhook.Config{
+			URL:          cfg.Webhook.URL,
+			Secret:       cfg.Webhook.Secret,
+			TestWebhooks: cfg.Webhook.PerTest,
+			QueueSize:    cfg.Webhook.QueueSize,
+			Workers:      cfg.Webhook.Workers,
+			MaxRetries:   cfg.Webhook.MaxRetries,
+			Backoff:      cfg.Webhook.Backoff.Value(),
+			Timeout:      cfg.Webhook.Timeout.Value(),
+
+			TestWebhooksAllowPrivate: cfg.Webhook.PerTestAllowPrivate,
+		}, strg)
 	}
 
 	apiSrv := &api.Server{
@@ -94,6 +151,7 @@ func main() {
 		TLSKeyPath:  cfg.API.TLSKeyPath,
 		StatusPath:  cfg.API.Status.Path,
 		Storage:     strg,
+		Webhooks:    hooks,
 	}
 
 	httpRcv := &httprcv.Receiver{
@@ -112,23 +170,127 @@ func main() {
 		txt = append(txt, dnsTxt)
 	}
 	dnsRcv := &dnsrcv.Receiver{
-		Name:     "DNS receiver",
-		Domain:   cfg.DNSRcv.Domain,
-		Host:     cfg.DNSRcv.Host,
-		Ports:    cfg.DNSRcv.Ports,
-		PublicIP: cfg.DNSRcv.PublicIP,
-		Txt:      txt,
-		Storage:  strg,
+		Name:       "DNS receiver",
+		Domain:     cfg.DNSRcv.Domain,
+		Host:       cfg.DNSRcv.Host,
+		Ports:      cfg.DNSRcv.Ports,
+		PublicIP:   cfg.DNSRcv.PublicIP,
+		PublicIPv6: cfg.DNSRcv.PublicIPv6,
+		Txt:        txt,
+		Storage:    strg,
+		CAAIssuers: cfg.DNSRcv.CAAIssuers,
+	}
+
+	smtpRcv := &smtprcv.Receiver{
+		Name:        "SMTP receiver",
+		Domain:      cfg.DNSRcv.Domain,
+		Host:        cfg.SMTPRcv.Host,
+		Ports:       cfg.SMTPRcv.Ports,
+		TLSCertPath: cfg.HTTPRcv.TLS.CertPath,
+		TLSKeyPath:  cfg.HTTPRcv.TLS.KeyPath,
+		Storage:     strg,
+	}
+
+	ldapRcv := &ldaprcv.Receiver{
+		Name:    "LDAP receiver",
+		Host:    cfg.LDAPRcv.Host,
+		Ports:   cfg.LDAPRcv.Ports,
+		Storage: strg,
+	}
+
+	ftpRcv := &ftprcv.Receiver{
+		Name:    "FTP receiver",
+		Host:    cfg.FTPRcv.Host,
+		Ports:   cfg.FTPRcv.Ports,
+		Storage: strg,
+	}
+
+	rawRcv := &rawrcv.Receiver{
+		Name:        "Raw receiver",
+		Host:        cfg.RawRcv.Host,
+		TCPPorts:    cfg.RawRcv.TCPPorts,
+		UDPPorts:    cfg.RawRcv.UDPPorts,
+		MaxReadSize: cfg.RawRcv.MaxReadSize.Value(),
+		IdleTimeout: cfg.RawRcv.IdleTimeout.Value(),
+		Storage:     strg,
+	}
+
+	// The TLS certificates are provided by a certmgr.Source so they're renewed or
+	// reloaded without restarting the servers. The ACME certificate replaces the
+	// configured TLS certificate files.
+	var certs certmgr.Source
+	var certMgr *certmgr.Manager
+	var certFiles *certmgr.Files
+	if !dnsOnly {
+		if cfg.ACME.CacheDir != "" {
+			names, err := acmeNames(&cfg)
+			if err != nil {
+				log.Fatalln("Failed to configure ACME:", err)
+			}
+			certMgr = &certmgr.Manager{
+				Domain:       cfg.DNSRcv.Domain,
+				Names:        names,
+				Email:        cfg.ACME.Email,
+				DirectoryURL: cfg.ACME.DirectoryURL,
+				CacheDir:     cfg.ACME.CacheDir,
+				RenewBefore:  cfg.ACME.RenewBefore.Value(),
+			}
+			dnsRcv.Challenges = certMgr
+			// Only the ACME CA is allowed to issue certificates unless the issuers are
+			// configured.
+			if issuer := certMgr.CAAIssuer(); issuer != "" && len(dnsRcv.CAAIssuers) == 0 {
+				dnsRcv.CAAIssuers = []string{issuer}
+			}
+			certs = certMgr
+		} else {
+			certFiles = &certmgr.Files{Pairs: certPairs(&cfg)}
+			if err := certFiles.Load(); err != nil {
+				log.Fatalln("Failed to load TLS certificates:", err)
+			}
+			certs = certFiles
+		}
+		apiSrv.Certs = certs
+		httpRcv.GetCertificate = certs.GetCertificate
+		smtpRcv.GetCertificate = certs.GetCertificate
 	}
 
 	errMain := make(chan error, 1)
 
+	// Stopping the server persists the storage's state when configured to do so.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		s := <-sig
+		log.Info("Received %v. Stopping %s", s, prognver)
+		if closeStrg != nil {
+			if err := closeStrg(); err != nil {
+				log.Info("Failed to persist storage")
+				log.Debug("Error: %v", err)
+				os.Exit(1)
+			}
+		}
+		os.Exit(0)
+	}()
+
 	go strg.StartExpire(errMain)
+	if hooks != nil {
+		go hooks.Start()
+	}
 	go dnsRcv.ListenAndServe(errMain)
+	if certMgr != nil {
+		go certMgr.Start(errMain)
+	}
+	if certFiles != nil {
+		go certFiles.Watch()
+	}
 
 	if !dnsOnly {
 		go apiSrv.ListenAndServe(errMain)
 		go httpRcv.ListenAndServe(errMain)
+		go smtpRcv.ListenAndServe(errMain)
+		go ldapRcv.ListenAndServe(errMain)
+		go ftpRcv.ListenAndServe(errMain)
+		go rawRcv.ListenAndServe(errMain)
 	}
 
 	if exitErr := <-errMain; exitErr != nil {
@@ -137,3 +299,39 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// certPairs returns the distinct TLS certificate files of the API and the HTTP receiver.
+func certPairs(cfg *config.Config) []certmgr.Pair {
+	var pairs []certmgr.Pair
+	for _, p := range []certmgr.Pair{
+		{CertPath: cfg.API.TLSCertPath, KeyPath: cfg.API.TLSKeyPath},
+		{CertPath: cfg.HTTPRcv.TLS.CertPath, KeyPath: cfg.HTTPRcv.TLS.KeyPath},
+	} {
+		if p.CertPath == "" || p.KeyPath == "" || (len(pairs) > 0 && pairs[0] == p) {
+			continue
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs
+}
+
+// acmeNames returns the names besides the DNS receiver's domain and its wildcard to be
+// included in the ACME certificate, which is also used by the API. As the DNS-01
+// challenges are answered by the DNS receiver, the API's domain must be under its
+// domain.
+func acmeNames(cfg *config.Config) ([]string, error) {
+	domain := strings.ToLower(strings.TrimSuffix(cfg.DNSRcv.Domain, "."))
+	apiDomain := strings.ToLower(strings.TrimSuffix(cfg.API.Domain, "."))
+	if apiDomain == "" || apiDomain == domain {
+		return nil, nil
+	}
+	sub := strings.TrimSuffix(apiDomain, "."+domain)
+	if sub == apiDomain {
+		return nil, fmt.Errorf("the API domain %s is not under the DNS receiver's domain %s", apiDomain, domain)
+	}
+	if !strings.Contains(sub, ".") {
+		// Covered by the wildcard.
+		return nil, nil
+	}
+	return []string{apiDomain}, nil
+}
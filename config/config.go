@@ -15,7 +15,13 @@ type Config struct {
 	API     APIConfig     `toml:"api"`
 	HTTPRcv HTTPRcvConfig `toml:"http_receiver"`
 	DNSRcv  DNSRcvConfig  `toml:"dns_receiver"`
+	SMTPRcv SMTPRcvConfig `toml:"smtp_receiver"`
+	LDAPRcv LDAPRcvConfig `toml:"ldap_receiver"`
+	FTPRcv  FTPRcvConfig  `toml:"ftp_receiver"`
+	RawRcv  RawRcvConfig  `toml:"raw_receiver"`
 	Strg    StorageConfig `toml:"storage"`
+	Webhook WebhookConfig `toml:"webhook"`
+	ACME    ACMEConfig    `toml:"acme"`
 }
 
 // APIConfig represents the web API configuration.
@@ -51,20 +57,98 @@ type HTTPRcvConfigTLS struct {
 
 // DNSRcvConfig represents the DNS protocol receiver configuration.
 type DNSRcvConfig struct {
-	Domain   string   `toml:"domain"`
-	Host     string   `toml:"host"`
-	Ports    []int    `toml:"ports"`
-	PublicIP string   `toml:"public_ip"`
-	Txt      []string `toml:"txt"`
+	Domain     string   `toml:"domain"`
+	Host       string   `toml:"host"`
+	Ports      []int    `toml:"ports"`
+	PublicIP   string   `toml:"public_ip"`
+	PublicIPv6 string   `toml:"public_ipv6"`
+	Txt        []string `toml:"txt"`
+	CAAIssuers []string `toml:"caa_issuers"`
+}
+
+// SMTPRcvConfig represents the SMTP protocol receiver configuration.
+// The receiver uses the DNS receiver's domain and the HTTP receiver's TLS certificate.
+type SMTPRcvConfig struct {
+	Host  string `toml:"host"`
+	Ports []int  `toml:"ports"`
+}
+
+// LDAPRcvConfig represents the LDAP protocol receiver configuration.
+type LDAPRcvConfig struct {
+	Host  string `toml:"host"`
+	Ports []int  `toml:"ports"`
+}
+
+// FTPRcvConfig represents the FTP protocol receiver configuration.
+type FTPRcvConfig struct {
+	Host  string `toml:"host"`
+	Ports []int  `toml:"ports"`
+}
+
+// RawRcvConfig represents the raw TCP and UDP receiver configuration.
+type RawRcvConfig struct {
+	Host        string   `toml:"host"`
+	TCPPorts    []int    `toml:"tcp_ports"`
+	UDPPorts    []int    `toml:"udp_ports"`
+	MaxReadSize byteSize `toml:"max_read_size"`
+	IdleTimeout duration `toml:"idle_timeout"`
 }
 
 // StorageConfig represents the storage configuration.
 type StorageConfig struct {
-	MaxEvents       int          `toml:"max_events"`
-	MaxEventsByTest int          `toml:"max_events_by_test"`
-	MaxDumpSize     byteSize     `toml:"max_dump_size"`
-	HMACKey         hmacKey      `toml:"hmac_key"`
-	Expire          ExpireConfig `toml:"expire"`
+	Backend         string            `toml:"backend"`
+	MaxEvents       int               `toml:"max_events"`
+	MaxEventsByTest int               `toml:"max_events_by_test"`
+	MaxDumpSize     byteSize          `toml:"max_dump_size"`
+	HMACKey         hmacKey           `toml:"hmac_key"`
+	MaxArtifacts    int               `toml:"max_artifacts_by_test"`
+	MaxArtifactSize byteSize          `toml:"max_artifact_size"`
+	Expire          ExpireConfig      `toml:"expire"`
+	Disk            DiskStorageConfig `toml:"disk"`
+	Snapshot        SnapshotConfig    `toml:"snapshot"`
+}
+
+// Storage backends.
+const (
+	// MemoryBackend is the in-memory storage backend. It's the default backend.
+	MemoryBackend = "memory"
+	// DiskBackend is the on-disk storage backend.
+	DiskBackend = "disk"
+)
+
+// DiskStorageConfig represents the storage configuration specific to the disk backend.
+type DiskStorageConfig struct {
+	Path         string   `toml:"path"`
+	SyncInterval duration `toml:"sync_interval"`
+}
+
+// SnapshotConfig represents the storage configuration specific to the snapshots of the
+// memory backend.
+type SnapshotConfig struct {
+	Path     string   `toml:"path"`
+	Interval duration `toml:"interval"`
+}
+
+// WebhookConfig represents the outbound webhooks configuration.
+type WebhookConfig struct {
+	URL        string   `toml:"url"`
+	Secret     string   `toml:"secret"`
+	PerTest    bool     `toml:"per_test"`
+	QueueSize  int      `toml:"queue_size"`
+	Workers    int      `toml:"workers"`
+	MaxRetries int      `toml:"max_retries"`
+	Backoff    duration `toml:"backoff"`
+	Timeout    duration `toml:"timeout"`
+
+	PerTestAllowPrivate bool `toml:"per_test_allow_private"`
+}
+
+// ACMEConfig represents the configuration of the certificates obtained via ACME.
+type ACMEConfig struct {
+	Email        string   `toml:"email"`
+	DirectoryURL string   `toml:"directory_url"`
+	CacheDir     string   `toml:"cache_dir"`
+	RenewBefore  duration `toml:"renew_before"`
 }
 
 // ExpireConfig represents the storage configurations specific to its expiration feature.
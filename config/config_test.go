@@ -36,6 +36,8 @@ var data = []byte(`
   host = "0.0.0.0"
   ports = [53, 5353]
   public_ip = "203.0.113.77"
+  public_ipv6 = "2001:db8::77"
+  caa_issuers = ["letsencrypt.org"]
 
 [storage]
   max_events = 1_000_000
@@ -213,6 +215,292 @@ func TestConfigParse(t *testing.T) {
 		t.Errorf("wrong DNS receiver public IP: %v (want) != %v (got)",
 			wantDNSRcvPublicIP, gotDNSRcvPublicIP)
 	}
+
+	wantDNSRcvPublicIPv6 := "2001:db8::77"
+	gotDNSRcvPublicIPv6 := cfg.DNSRcv.PublicIPv6
+	if wantDNSRcvPublicIPv6 != gotDNSRcvPublicIPv6 {
+		t.Errorf("wrong DNS receiver public IPv6: %v (want) != %v (got)",
+			wantDNSRcvPublicIPv6, gotDNSRcvPublicIPv6)
+	}
+
+	wantDNSRcvCAAIssuers := []string{"letsencrypt.org"}
+	gotDNSRcvCAAIssuers := cfg.DNSRcv.CAAIssuers
+	if !reflect.DeepEqual(wantDNSRcvCAAIssuers, gotDNSRcvCAAIssuers) {
+		t.Errorf("wrong DNS receiver CAA issuers: %v (want) != %v (got)",
+			wantDNSRcvCAAIssuers, gotDNSRcvCAAIssuers)
+	}
+}
+
+func TestStorageBackend(t *testing.T) {
+	var backend = []byte(
+		`[storage]
+		   backend = "disk"
+
+		   [storage.disk]
+		     path = "/var/lib/boast/boast.log"
+		     sync_interval = "1s"`,
+	)
+	var cfg config.Config
+	if err := toml.Unmarshal(backend, &cfg); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)",
+			nil, err)
+	}
+
+	wantStrgBackend := config.DiskBackend
+	gotStrgBackend := cfg.Strg.Backend
+	if wantStrgBackend != gotStrgBackend {
+		t.Errorf("wrong Storage backend: %v (want) != %v (got)",
+			wantStrgBackend, gotStrgBackend)
+	}
+
+	wantStrgDiskPath := "/var/lib/boast/boast.log"
+	gotStrgDiskPath := cfg.Strg.Disk.Path
+	if wantStrgDiskPath != gotStrgDiskPath {
+		t.Errorf("wrong Storage disk path: %v (want) != %v (got)",
+			wantStrgDiskPath, gotStrgDiskPath)
+	}
+
+	wantStrgDiskSync := time.Second
+	gotStrgDiskSync := cfg.Strg.Disk.SyncInterval.Value()
+	if wantStrgDiskSync != gotStrgDiskSync {
+		t.Errorf("wrong Storage disk sync interval: %v (want) != %v (got)",
+			wantStrgDiskSync, gotStrgDiskSync)
+	}
+}
+
+func TestStorageSnapshot(t *testing.T) {
+	var snapshot = []byte(
+		`[storage.snapshot]
+		   path = "/var/lib/boast/boast.snapshot"
+		   interval = "10m"`,
+	)
+	var cfg config.Config
+	if err := toml.Unmarshal(snapshot, &cfg); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)",
+			nil, err)
+	}
+
+	wantStrgSnapshotPath := "/var/lib/boast/boast.snapshot"
+	gotStrgSnapshotPath := cfg.Strg.Snapshot.Path
+	if wantStrgSnapshotPath != gotStrgSnapshotPath {
+		t.Errorf("wrong Storage snapshot path: %v (want) != %v (got)",
+			wantStrgSnapshotPath, gotStrgSnapshotPath)
+	}
+
+	wantStrgSnapshotInterval := 10 * time.Minute
+	gotStrgSnapshotInterval := cfg.Strg.Snapshot.Interval.Value()
+	if wantStrgSnapshotInterval != gotStrgSnapshotInterval {
+		t.Errorf("wrong Storage snapshot interval: %v (want) != %v (got)",
+			wantStrgSnapshotInterval, gotStrgSnapshotInterval)
+	}
+}
+
+func TestStorageArtifacts(t *testing.T) {
+	var artifacts = []byte(
+		`[storage]
+		   max_artifacts_by_test = 5
+		   max_artifact_size = "64KiB"`,
+	)
+	var cfg config.Config
+	if err := toml.Unmarshal(artifacts, &cfg); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)",
+			nil, err)
+	}
+
+	wantStrgMaxArtifacts := 5
+	gotStrgMaxArtifacts := cfg.Strg.MaxArtifacts
+	if wantStrgMaxArtifacts != gotStrgMaxArtifacts {
+		t.Errorf("wrong Storage max artifacts: %v (want) != %v (got)",
+			wantStrgMaxArtifacts, gotStrgMaxArtifacts)
+	}
+
+	wantStrgMaxArtifactSize := 64 * 1024
+	gotStrgMaxArtifactSize := cfg.Strg.MaxArtifactSize.Value()
+	if wantStrgMaxArtifactSize != gotStrgMaxArtifactSize {
+		t.Errorf("wrong Storage max artifact size: %v (want) != %v (got)",
+			wantStrgMaxArtifactSize, gotStrgMaxArtifactSize)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var webhook = []byte(
+		`[webhook]
+		   url = "https://example.com/boast"
+		   secret = "s3cr3t"
+		   per_test = true
+		   per_test_allow_private = true
+		   queue_size = 500
+		   workers = 2
+		   max_retries = 3
+		   backoff = "2s"
+		   timeout = "5s"`,
+	)
+	var cfg config.Config
+	if err := toml.Unmarshal(webhook, &cfg); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)",
+			nil, err)
+	}
+
+	want := config.WebhookConfig{
+		URL:        "https://example.com/boast",
+		Secret:     "s3cr3t",
+		PerTest:    true,
+		QueueSize:  500,
+		Workers:    2,
+		MaxRetries: 3,
+
+		PerTestAllowPrivate: true,
+	}
+	got := cfg.Webhook
+	// The durations are checked below.
+	want.Backoff, want.Timeout = got.Backoff, got.Timeout
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong webhook: %v (want) != %v (got)", want, got)
+	}
+
+	wantBackoff := 2 * time.Second
+	gotBackoff := cfg.Webhook.Backoff.Value()
+	if wantBackoff != gotBackoff {
+		t.Errorf("wrong webhook backoff: %v (want) != %v (got)", wantBackoff, gotBackoff)
+	}
+
+	wantTimeout := 5 * time.Second
+	gotTimeout := cfg.Webhook.Timeout.Value()
+	if wantTimeout != gotTimeout {
+		t.Errorf("wrong webhook timeout: %v (want) != %v (got)", wantTimeout, gotTimeout)
+	}
+}
+
+func TestSMTPReceiver(t *testing.T) {
+	var smtp = []byte(
+		`[smtp_receiver]
+		   host = "0.0.0.0"
+		   ports = [25, 587]`,
+	)
+	var cfg config.Config
+	if err := toml.Unmarshal(smtp, &cfg); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)",
+			nil, err)
+	}
+
+	want := config.SMTPRcvConfig{Host: "0.0.0.0", Ports: []int{25, 587}}
+	got := cfg.SMTPRcv
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong SMTP receiver: %v (want) != %v (got)", want, got)
+	}
+}
+
+func TestLDAPReceiver(t *testing.T) {
+	var ldap = []byte(
+		`[ldap_receiver]
+		   host = "0.0.0.0"
+		   ports = [389, 1389]`,
+	)
+	var cfg config.Config
+	if err := toml.Unmarshal(ldap, &cfg); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)",
+			nil, err)
+	}
+
+	want := config.LDAPRcvConfig{Host: "0.0.0.0", Ports: []int{389, 1389}}
+	got := cfg.LDAPRcv
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong LDAP receiver: %v (want) != %v (got)", want, got)
+	}
+}
+
+func TestFTPReceiver(t *testing.T) {
+	var ftp = []byte(
+		`[ftp_receiver]
+		   host = "0.0.0.0"
+		   ports = [21, 2121]`,
+	)
+	var cfg config.Config
+	if err := toml.Unmarshal(ftp, &cfg); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)",
+			nil, err)
+	}
+
+	want := config.FTPRcvConfig{Host: "0.0.0.0", Ports: []int{21, 2121}}
+	got := cfg.FTPRcv
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong FTP receiver: %v (want) != %v (got)", want, got)
+	}
+}
+
+func TestRawReceiver(t *testing.T) {
+	var raw = []byte(
+		`[raw_receiver]
+		   host = "0.0.0.0"
+		   tcp_ports = [6379, 11211]
+		   udp_ports = [11211]
+		   max_read_size = "8KiB"
+		   idle_timeout = "3s"`,
+	)
+	var cfg config.Config
+	if err := toml.Unmarshal(raw, &cfg); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)",
+			nil, err)
+	}
+
+	want := config.RawRcvConfig{
+		Host:     "0.0.0.0",
+		TCPPorts: []int{6379, 11211},
+		UDPPorts: []int{11211},
+	}
+	got := cfg.RawRcv
+	// The size and the duration are checked below.
+	want.MaxReadSize, want.IdleTimeout = got.MaxReadSize, got.IdleTimeout
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong raw receiver: %v (want) != %v (got)", want, got)
+	}
+
+	wantMaxReadSize := 8 * 1024
+	gotMaxReadSize := cfg.RawRcv.MaxReadSize.Value()
+	if wantMaxReadSize != gotMaxReadSize {
+		t.Errorf("wrong raw receiver max read size: %v (want) != %v (got)",
+			wantMaxReadSize, gotMaxReadSize)
+	}
+
+	wantIdleTimeout := 3 * time.Second
+	gotIdleTimeout := cfg.RawRcv.IdleTimeout.Value()
+	if wantIdleTimeout != gotIdleTimeout {
+		t.Errorf("wrong raw receiver idle timeout: %v (want) != %v (got)",
+			wantIdleTimeout, gotIdleTimeout)
+	}
+}
+
+func TestACME(t *testing.T) {
+	var acme = []byte(
+		`[acme]
+		   email = "admin@example.com"
+		   directory_url = "https://acme-staging-v02.api.letsencrypt.org/directory"
+		   cache_dir = "/var/lib/boast/acme"
+		   renew_before = "480h"`,
+	)
+	var cfg config.Config
+	if err := toml.Unmarshal(acme, &cfg); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)",
+			nil, err)
+	}
+
+	want := config.ACMEConfig{
+		Email:        "admin@example.com",
+		DirectoryURL: "https://acme-staging-v02.api.letsencrypt.org/directory",
+		CacheDir:     "/var/lib/boast/acme",
+	}
+	got := cfg.ACME
+	// The duration is checked below.
+	want.RenewBefore = got.RenewBefore
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong ACME: %v (want) != %v (got)", want, got)
+	}
+
+	wantRenewBefore := 480 * time.Hour
+	gotRenewBefore := cfg.ACME.RenewBefore.Value()
+	if wantRenewBefore != gotRenewBefore {
+		t.Errorf("wrong ACME renew before: %v (want) != %v (got)", wantRenewBefore, gotRenewBefore)
+	}
 }
 
 func TestStorageMaxDumpFloatRounding(t *testing.T) {
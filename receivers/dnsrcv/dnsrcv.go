@@ -13,77 +13,114 @@ import (
 
 const shortTTL = 300
 
+// srvPorts are the ports of the SRV answers by service. Other services point to the
+// HTTP receiver's default port.
+var srvPorts = map[string]uint16{
+	"_ftp":        21,
+	"_smtp":       25,
+	"_domain":     53,
+	"_http":       80,
+	"_ldap":       389,
+	"_https":      443,
+	"_submission": 587,
+}
+
+// maxUDPSize is the maximum size of UDP responses to EDNS(0) queries. It's the size
+// recommended by the DNS Flag Day 2020 to avoid IP fragmentation.
+const maxUDPSize = 1232
+
+// networks are the transports served on each configured port.
+var networks = []string{"udp", "tcp"}
+
 // Receiver represents the DNS protocol receiver.
 type Receiver struct {
-	Name     string
-	Domain   string
-	Host     string
-	Ports    []int
-	PublicIP string
-	Txt      []string
-	Storage  app.Storage
+	Name       string
+	Domain     string
+	Host       string
+	Ports      []int
+	PublicIP   string
+	PublicIPv6 string
+	Txt        []string
+	Storage    app.Storage
+	// Challenges answers the ACME DNS-01 challenges if set.
+	Challenges ChallengeStore
+	// CAAIssuers are the domains of the CAs allowed to issue certificates for the
+	// domain (e.g. "letsencrypt.org"). If empty, CAA queries get no answers.
+	CAAIssuers []string
+}
+
+// ChallengeStore provides the TXT records of the pending ACME DNS-01 challenges.
+type ChallengeStore interface {
+	// ChallengeTXT returns the TXT records for name, a lower case fully qualified
+	// domain name (e.g. "_acme-challenge.example.com.").
+	ChallengeTXT(name string) []string
 }
 
 // ListenAndServe sets the necessary conditions for the underlying dns.Server
-// to serve the BOAST's custom DNS server for each configured port.
+// to serve the BOAST's custom DNS server over UDP and TCP for each configured port.
 //
 // For full functionality, this server must be used as nameserver for the domain.
 //
 // Any errors are returned via the received channel.
 func (r *Receiver) ListenAndServe(err chan error) {
+	handler := &dnsHandler{
+		domain:     r.Domain,
+		publicIP:   r.PublicIP,
+		publicIPv6: r.PublicIPv6,
+		txt:        r.Txt,
+		storage:    r.Storage,
+		challenges: r.Challenges,
+		caaIssuers: r.CAAIssuers,
+	}
 	for _, port := range r.Ports {
-		go func(p int) {
-			addr := r.Host + fmt.Sprintf(":%d", p)
-			srv := &dns.Server{
-				Addr: addr,
-				Net:  "udp",
-			}
-
-			srv.Handler = &dnsHandler{
-				domain:   r.Domain,
-				publicIP: r.PublicIP,
-				txt:      r.Txt,
-				storage:  r.Storage,
-			}
+		for _, network := range networks {
+			go func(p int, n string) {
+				addr := r.Host + fmt.Sprintf(":%d", p)
+				srv := &dns.Server{
+					Addr:    addr,
+					Net:     n,
+					Handler: handler,
+				}
 
-			log.Info("%s: Listening on %s\n", r.Name, addr)
-			err <- srv.ListenAndServe()
-		}(port)
+				log.Info("%s: Listening on %s (%s)\n", r.Name, addr, n)
+				err <- srv.ListenAndServe()
+			}(port, network)
+		}
 	}
 }
 
 type dnsHandler struct {
-	domain   string
-	publicIP string
-	txt      []string
-	storage  app.Storage
-}
-
-var queryTypeNames = map[uint16]string{
-	dns.TypeA:     "A",
-	dns.TypeNS:    "NS",
-	dns.TypeSOA:   "SOA",
-	dns.TypeMX:    "MX",
-	dns.TypeCNAME: "CNAME",
-	dns.TypeAAAA:  "AAAA",
-	dns.TypeTXT:   "TXT",
+	domain     string
+	publicIP   string
+	publicIPv6 string
+	txt        []string
+	storage    app.Storage
+	challenges ChallengeStore
+	caaIssuers []string
+	rebinds    rebinder
 }
 
 // ServeDNS is the handler for BOAST's DNS queries.
-// It responds to A, NS, SOA, and MX queries always pointing to the same IP.
+// It responds to A, AAAA, NS, SOA, MX, TXT, CNAME, PTR, SRV, CAA, HTTPS, and SVCB
+// queries always pointing to the same server.
 func (d *dnsHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	log.Info("DNS event received")
 	msg := dns.Msg{}
 	msg.SetReply(r)
 
-	id, canary := d.storage.SearchTest(
-		func(key, value string) bool {
-			return strings.Contains(msg.Question[0].Name, key)
-		},
-	)
+	id, canary := d.storage.LookupTest(msg.Question[0].Name)
+
+	if !d.setRebindingAnswer(&msg, r, id) && !d.setTestAnswer(&msg, r, id) &&
+		!d.setChallengeAnswer(&msg, r) {
+		d.setDNSAnswer(&msg, r)
+	}
+	if opt := r.IsEdns0(); opt != nil {
+		msg.SetEdns0(maxUDPSize, opt.Do())
+	}
+	msg.Truncate(responseSize(w, r))
 
 	if id != "" {
-		qTypeName := queryTypeNames[r.Question[0].Qtype]
+		qTypeName := dns.Type(r.Question[0].Qtype).String()
 		evt, err := app.NewDNSEvent(
 			id,
 			"DNS",
@@ -95,6 +132,11 @@ func (d *dnsHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			log.Info("Error creating a new DNS event")
 			log.Debug("New DNS event error: %v", err)
 		} else {
+			evt.Transport = w.RemoteAddr().Network()
+			evt.DNS = dnsInfo(r)
+			for _, rr := range msg.Answer {
+				evt.Answers = append(evt.Answers, rr.String())
+			}
 			if err := d.storage.StoreEvent(evt); err != nil {
 				log.Info("Error storing a new DNS event")
 				log.Debug("Store DNS event error: %v", err)
@@ -108,21 +150,179 @@ func (d *dnsHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			id, canary)
 	}
 
-	d.setDNSAnswer(&msg, r)
 	w.WriteMsg(&msg)
 }
 
-func (d *dnsHandler) setDNSAnswer(msg, r *dns.Msg) {
+// dnsInfo returns the details of the query r.
+func dnsInfo(r *dns.Msg) *app.DNSInfo {
+	q := r.Question[0]
+	info := &app.DNSInfo{
+		Name:        q.Name,
+		Type:        dns.Type(q.Qtype).String(),
+		Class:       dns.Class(q.Qclass).String(),
+		Flags:       flags(&r.MsgHdr),
+		CasePattern: casePattern(q.Name),
+	}
+	if opt := r.IsEdns0(); opt != nil {
+		info.DNSSECOK = opt.Do()
+		for _, o := range opt.Option {
+			switch o := o.(type) {
+			case *dns.EDNS0_SUBNET:
+				info.ClientSubnet = fmt.Sprintf("%s/%d", o.Address, o.SourceNetmask)
+			case *dns.EDNS0_COOKIE:
+				info.Cookie = o.Cookie
+			}
+		}
+	}
+	return info
+}
+
+// flags returns the names of the header flags that are set in hdr.
+func flags(hdr *dns.MsgHdr) []string {
+	var f []string
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{
+		{"aa", hdr.Authoritative},
+		{"tc", hdr.Truncated},
+		{"rd", hdr.RecursionDesired},
+		{"ra", hdr.RecursionAvailable},
+		{"ad", hdr.AuthenticatedData},
+		{"cd", hdr.CheckingDisabled},
+	} {
+		if flag.set {
+			f = append(f, flag.name)
+		}
+	}
+	return f
+}
+
+// casePattern returns name with its upper case letters replaced by "X" and the lower
+// case ones by "x".
+func casePattern(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'A' <= r && r <= 'Z':
+			return 'X'
+		case 'a' <= r && r <= 'z':
+			return 'x'
+		}
+		return r
+	}, name)
+}
+
+// responseSize returns the maximum size of the response to r.
+// UDP responses are limited to 512 bytes unless the client advertises a larger buffer
+// with EDNS(0), in which case they're limited to the smallest of the client's buffer and
+// maxUDPSize. Larger responses are truncated so the client retries over TCP.
+func responseSize(w dns.ResponseWriter, r *dns.Msg) int {
+	if w.RemoteAddr().Network() == "tcp" {
+		return dns.MaxMsgSize
+	}
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		size = int(opt.UDPSize())
+	}
+	if size > maxUDPSize {
+		size = maxUDPSize
+	}
+	return size
+}
+
+// setChallengeAnswer answers TXT queries with the records of the pending ACME DNS-01
+// challenges for the name and reports whether there were any.
+func (d *dnsHandler) setChallengeAnswer(msg, r *dns.Msg) bool {
+	if d.challenges == nil || r.Question[0].Qtype != dns.TypeTXT {
+		return false
+	}
 	qName := msg.Question[0].Name
-	if strings.HasSuffix(toFQDN(qName), toFQDN(d.domain)) {
-		msg.Authoritative = true
+	for _, txt := range d.challenges.ChallengeTXT(strings.ToLower(toFQDN(qName))) {
+		msg.Answer = append(msg.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: qName, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+			Txt: []string{txt},
+		})
+	}
+	if len(msg.Answer) == 0 {
+		return false
+	}
+	msg.Authoritative = true
+	return true
+}
+
+// setTestAnswer answers the query with the test id's records matching its type and
+// reports whether there were any. CNAME records match every query type.
+func (d *dnsHandler) setTestAnswer(msg, r *dns.Msg, id string) bool {
+	qName := msg.Question[0].Name
+	if id == "" || !strings.HasSuffix(toFQDN(qName), toFQDN(d.domain)) {
+		return false
+	}
+	recs, loaded := d.storage.LoadDNSRecords(id)
+	if !loaded {
+		return false
+	}
+
+	qType := r.Question[0].Qtype
+	for _, rec := range recs {
+		rrType := dns.StringToType[rec.Type]
+		if rrType != qType && rrType != dns.TypeCNAME && qType != dns.TypeANY {
+			continue
+		}
 		hdr := dns.RR_Header{
-			Name:  qName,
-			Class: dns.ClassINET,
-			Ttl:   shortTTL,
+			Name:   qName,
+			Rrtype: rrType,
+			Class:  dns.ClassINET,
+			Ttl:    rec.TTL,
+		}
+		switch rrType {
+		case dns.TypeA:
+			msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: net.ParseIP(rec.Value)})
+		case dns.TypeAAAA:
+			msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP(rec.Value)})
+		case dns.TypeCNAME:
+			msg.Answer = append(msg.Answer, &dns.CNAME{Hdr: hdr, Target: rec.Value})
+		case dns.TypeTXT:
+			msg.Answer = append(msg.Answer, &dns.TXT{Hdr: hdr, Txt: splitTXT(rec.Value)})
 		}
+	}
+	if len(msg.Answer) == 0 {
+		return false
+	}
+	msg.Authoritative = true
+	return true
+}
 
-		qType := r.Question[0].Qtype
+// splitTXT splits s in the 255 bytes long strings of a TXT record.
+func splitTXT(s string) []string {
+	txt := []string{}
+	for len(s) > 255 {
+		txt = append(txt, s[:255])
+		s = s[255:]
+	}
+	return append(txt, s)
+}
+
+func (d *dnsHandler) setDNSAnswer(msg, r *dns.Msg) {
+	qName := msg.Question[0].Name
+	hdr := dns.RR_Header{
+		Name:  qName,
+		Class: dns.ClassINET,
+		Ttl:   shortTTL,
+	}
+	qType := r.Question[0].Qtype
+
+	if qType == dns.TypePTR && d.isReverseName(qName) {
+		msg.Authoritative = true
+		hdr.Rrtype = dns.TypePTR
+		msg.Answer = append(msg.Answer, &dns.PTR{
+			Hdr: hdr,
+			Ptr: toFQDN(d.domain),
+		})
+		return
+	}
+
+	if strings.HasSuffix(toFQDN(qName), toFQDN(d.domain)) {
+		msg.Authoritative = true
 
 		if qType == dns.TypeA || qType == dns.TypeANY {
 			hdr.Rrtype = dns.TypeA
@@ -133,6 +333,17 @@ func (d *dnsHandler) setDNSAnswer(msg, r *dns.Msg) {
 				})
 		}
 
+		if d.publicIPv6 != "" {
+			if qType == dns.TypeAAAA || qType == dns.TypeANY {
+				hdr.Rrtype = dns.TypeAAAA
+				msg.Answer = append(msg.Answer,
+					&dns.AAAA{
+						Hdr:  hdr,
+						AAAA: net.ParseIP(d.publicIPv6),
+					})
+			}
+		}
+
 		if qType == dns.TypeNS || qType == dns.TypeANY {
 			hdr.Rrtype = dns.TypeNS
 			msg.Answer = append(msg.Answer, &dns.NS{
@@ -177,7 +388,103 @@ func (d *dnsHandler) setDNSAnswer(msg, r *dns.Msg) {
 				})
 			}
 		}
+
+		if qType == dns.TypeCAA || qType == dns.TypeANY {
+			hdr.Rrtype = dns.TypeCAA
+			for _, issuer := range d.caaIssuers {
+				msg.Answer = append(msg.Answer, &dns.CAA{
+					Hdr:   hdr,
+					Flag:  0,
+					Tag:   "issue",
+					Value: issuer,
+				})
+			}
+		}
+
+		// A CNAME can't coexist with other records, so the apex never has one.
+		if qType == dns.TypeCNAME && toFQDN(qName) != toFQDN(d.domain) {
+			hdr.Rrtype = dns.TypeCNAME
+			msg.Answer = append(msg.Answer, &dns.CNAME{
+				Hdr:    hdr,
+				Target: toFQDN(d.domain),
+			})
+		}
+
+		if qType == dns.TypePTR {
+			hdr.Rrtype = dns.TypePTR
+			msg.Answer = append(msg.Answer, &dns.PTR{
+				Hdr: hdr,
+				Ptr: toFQDN(d.domain),
+			})
+		}
+
+		if qType == dns.TypeSRV {
+			port := srvPort(qName)
+			hdr.Rrtype = dns.TypeSRV
+			msg.Answer = append(msg.Answer, &dns.SRV{
+				Hdr:      hdr,
+				Priority: 0,
+				Weight:   0,
+				Port:     port,
+				Target:   toFQDN(d.domain),
+			})
+		}
+
+		if qType == dns.TypeHTTPS || qType == dns.TypeSVCB {
+			hdr.Rrtype = qType
+			svcb := dns.SVCB{
+				Hdr:      hdr,
+				Priority: 1,
+				Target:   ".",
+				Value:    d.svcParams(),
+			}
+			if qType == dns.TypeHTTPS {
+				msg.Answer = append(msg.Answer, &dns.HTTPS{SVCB: svcb})
+			} else {
+				msg.Answer = append(msg.Answer, &svcb)
+			}
+		}
+	}
+}
+
+// srvPort returns the port of the SRV answer to name (e.g. "_ldap._tcp.example.com.").
+func srvPort(name string) uint16 {
+	labels := dns.SplitDomainName(name)
+	if len(labels) > 0 {
+		if port, ok := srvPorts[strings.ToLower(labels[0])]; ok {
+			return port
+		}
+	}
+	return 80
+}
+
+// svcParams returns the parameters of the HTTPS and SVCB answers. The HTTP receiver
+// only speaks HTTP/1.1 and the hints save the clients from another query.
+func (d *dnsHandler) svcParams() []dns.SVCBKeyValue {
+	params := []dns.SVCBKeyValue{
+		&dns.SVCBAlpn{Alpn: []string{"http/1.1"}},
+	}
+	if ip := net.ParseIP(d.publicIP).To4(); ip != nil {
+		params = append(params, &dns.SVCBIPv4Hint{Hint: []net.IP{ip}})
+	}
+	if ip := net.ParseIP(d.publicIPv6); ip != nil {
+		params = append(params, &dns.SVCBIPv6Hint{Hint: []net.IP{ip}})
+	}
+	return params
+}
+
+// isReverseName reports whether name is the reverse lookup name of one of the
+// server's public IPs.
+func (d *dnsHandler) isReverseName(name string) bool {
+	for _, ip := range []string{d.publicIP, d.publicIPv6} {
+		if ip == "" {
+			continue
+		}
+		if rev, err := dns.ReverseAddr(ip); err == nil && toFQDN(name) == rev {
+			return true
+		}
 	}
+	return false
 }
 
 func toFQDN(s string) string {
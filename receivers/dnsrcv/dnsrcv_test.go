@@ -2,10 +2,14 @@ package dnsrcv_test
 
 import (
 	"io/ioutil"
+	"net"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	app "github.com/ciphermarco/BOAST"
 	"github.com/ciphermarco/BOAST/log"
 	"github.com/ciphermarco/BOAST/receivers/dnsrcv"
 
@@ -21,6 +25,7 @@ func TestMain(m *testing.M) {
 
 var exampleDomain = "example.com."
 var exampleIP = "203.0.113.77"
+var exampleIPv6 = "2001:db8::77"
 
 func NewTestHandler() *dnsrcv.ExportDNSHandler {
 	return dnsrcv.NewExportDNSHandler(exampleDomain, exampleIP, []string{"testing"}, &mockStorage{})
@@ -249,3 +254,670 @@ func TestDNSResponseANY(t *testing.T) {
 		}
 	}
 }
+
+func TestDNSTransport(t *testing.T) {
+	for _, tcp := range []bool{false, true} {
+		strg := &mockStorage{}
+		handler := dnsrcv.NewExportDNSHandler(exampleDomain, exampleIP, nil, strg)
+		qr := dnstest.NewRecorder(&test.ResponseWriter{TCP: tcp})
+		dnsMsg := &dns.Msg{}
+		dnsMsg.SetQuestion(tID+"."+exampleDomain, dns.TypeA)
+
+		handler.ServeDNS(qr, dnsMsg)
+
+		want := "udp"
+		if tcp {
+			want = "tcp"
+		}
+		if strg.evt.Transport != want {
+			t.Errorf("wrong Transport: %v (want) != %v (got)", want, strg.evt.Transport)
+		}
+	}
+}
+
+func TestDNSTruncate(t *testing.T) {
+	txt := make([]string, 10)
+	for i := range txt {
+		txt[i] = strings.Repeat("a", 200)
+	}
+	handler := dnsrcv.NewExportDNSHandler(exampleDomain, exampleIP, txt, &mockStorage{})
+
+	tests := []struct {
+		name      string
+		tcp       bool
+		udpSize   uint16
+		truncated bool
+	}{
+		{"udp", false, 0, true},
+		{"udp edns0", false, 4096, true},
+		{"tcp", true, 0, false},
+	}
+	for _, tt := range tests {
+		qr := dnstest.NewRecorder(&test.ResponseWriter{TCP: tt.tcp})
+		dnsMsg := &dns.Msg{}
+		dnsMsg.SetQuestion(exampleDomain, dns.TypeTXT)
+		if tt.udpSize > 0 {
+			dnsMsg.SetEdns0(tt.udpSize, false)
+		}
+
+		handler.ServeDNS(qr, dnsMsg)
+
+		if qr.Msg == nil {
+			t.Fatalf("%s: got nil message", tt.name)
+		}
+		if qr.Msg.Truncated != tt.truncated {
+			t.Errorf("%s: wrong Truncated: %v (want) != %v (got)",
+				tt.name, tt.truncated, qr.Msg.Truncated)
+		}
+		if tt.truncated && len(qr.Msg.Answer) != 0 {
+			t.Errorf("%s: wrong answers: %v (want) != %v (got)",
+				tt.name, 0, len(qr.Msg.Answer))
+		}
+		if !tt.truncated && len(qr.Msg.Answer) != 1 {
+			t.Errorf("%s: wrong answers: %v (want) != %v (got)",
+				tt.name, 1, len(qr.Msg.Answer))
+		}
+	}
+}
+
+func TestDNSResponseAAAA(t *testing.T) {
+	handler := NewTestHandler()
+	handler.SetPublicIPv6(exampleIPv6)
+
+	qr := dnstest.NewRecorder(&test.ResponseWriter{})
+	dnsMsg := &dns.Msg{}
+	dnsMsg.SetQuestion("sub."+exampleDomain, dns.TypeAAAA)
+
+	handler.ServeDNS(qr, dnsMsg)
+
+	if qr.Msg == nil {
+		t.Fatal("got nil message")
+	}
+	if len(qr.Msg.Answer) != 1 {
+		t.Fatalf("wrong answers: %v (want) != %v (got)", 1, len(qr.Msg.Answer))
+	}
+	aaaa, ok := qr.Msg.Answer[0].(*dns.AAAA)
+	if !ok {
+		t.Fatal("wrong type")
+	}
+	if aaaa.AAAA.String() != exampleIPv6 {
+		t.Errorf("wrong AAAA: %v (want) != %v (got)", exampleIPv6, aaaa.AAAA)
+	}
+}
+
+func TestDNSResponseAAAAUnset(t *testing.T) {
+	handler := NewTestHandler()
+
+	qr := dnstest.NewRecorder(&test.ResponseWriter{})
+	dnsMsg := &dns.Msg{}
+	dnsMsg.SetQuestion("sub."+exampleDomain, dns.TypeAAAA)
+
+	handler.ServeDNS(qr, dnsMsg)
+
+	if qr.Msg == nil {
+		t.Fatal("got nil message")
+	}
+	if len(qr.Msg.Answer) != 0 {
+		t.Errorf("wrong answers: %v (want) != %v (got)", 0, len(qr.Msg.Answer))
+	}
+}
+
+func TestDNSResponseCNAME(t *testing.T) {
+	handler := NewTestHandler()
+
+	tests := []struct {
+		name    string
+		answers int
+	}{
+		{"sub." + exampleDomain, 1},
+		{exampleDomain, 0},
+	}
+	for _, tt := range tests {
+		qr := dnstest.NewRecorder(&test.ResponseWriter{})
+		dnsMsg := &dns.Msg{}
+		dnsMsg.SetQuestion(tt.name, dns.TypeCNAME)
+
+		handler.ServeDNS(qr, dnsMsg)
+
+		if qr.Msg == nil {
+			t.Fatal("got nil message")
+		}
+		if len(qr.Msg.Answer) != tt.answers {
+			t.Fatalf("wrong answers for %s: %v (want) != %v (got)",
+				tt.name, tt.answers, len(qr.Msg.Answer))
+		}
+		if tt.answers == 0 {
+			continue
+		}
+		cname, ok := qr.Msg.Answer[0].(*dns.CNAME)
+		if !ok {
+			t.Fatal("wrong type")
+		}
+		if cname.Target != exampleDomain {
+			t.Errorf("wrong Target: %v (want) != %v (got)", exampleDomain, cname.Target)
+		}
+	}
+}
+
+func TestDNSResponsePTR(t *testing.T) {
+	handler := NewTestHandler()
+	handler.SetPublicIPv6(exampleIPv6)
+
+	rev, _ := dns.ReverseAddr(exampleIP)
+	rev6, _ := dns.ReverseAddr(exampleIPv6)
+	for _, n := range []string{"sub." + exampleDomain, rev, rev6} {
+		qr := dnstest.NewRecorder(&test.ResponseWriter{})
+		dnsMsg := &dns.Msg{}
+		dnsMsg.SetQuestion(n, dns.TypePTR)
+
+		handler.ServeDNS(qr, dnsMsg)
+
+		if qr.Msg == nil {
+			t.Fatal("got nil message")
+		}
+		if len(qr.Msg.Answer) != 1 {
+			t.Fatalf("wrong answers for %s: %v (want) != %v (got)",
+				n, 1, len(qr.Msg.Answer))
+		}
+		ptr, ok := qr.Msg.Answer[0].(*dns.PTR)
+		if !ok {
+			t.Fatal("wrong type")
+		}
+		if ptr.Ptr != exampleDomain {
+			t.Errorf("wrong Ptr: %v (want) != %v (got)", exampleDomain, ptr.Ptr)
+		}
+	}
+
+	qr := dnstest.NewRecorder(&test.ResponseWriter{})
+	dnsMsg := &dns.Msg{}
+	dnsMsg.SetQuestion("1.0.0.127.in-addr.arpa.", dns.TypePTR)
+	handler.ServeDNS(qr, dnsMsg)
+	if len(qr.Msg.Answer) != 0 {
+		t.Errorf("wrong answers for other IPs: %v (want) != %v (got)",
+			0, len(qr.Msg.Answer))
+	}
+}
+
+func TestDNSResponseSRV(t *testing.T) {
+	handler := NewTestHandler()
+
+	tests := []struct {
+		name string
+		port uint16
+	}{
+		{"_ldap._tcp." + exampleDomain, 389},
+		{"_HTTPS._tcp." + exampleDomain, 443},
+		{"_unknown._udp." + exampleDomain, 80},
+	}
+	for _, tt := range tests {
+		qr := dnstest.NewRecorder(&test.ResponseWriter{})
+		dnsMsg := &dns.Msg{}
+		dnsMsg.SetQuestion(tt.name, dns.TypeSRV)
+
+		handler.ServeDNS(qr, dnsMsg)
+
+		if qr.Msg == nil {
+			t.Fatal("got nil message")
+		}
+		srv, ok := qr.Msg.Answer[0].(*dns.SRV)
+		if !ok {
+			t.Fatal("wrong type")
+		}
+		if srv.Port != tt.port {
+			t.Errorf("wrong Port for %s: %v (want) != %v (got)", tt.name, tt.port, srv.Port)
+		}
+		if srv.Target != exampleDomain {
+			t.Errorf("wrong Target: %v (want) != %v (got)", exampleDomain, srv.Target)
+		}
+	}
+}
+
+func TestDNSResponseCAA(t *testing.T) {
+	handler := NewTestHandler()
+	issuers := []string{"letsencrypt.org", "ca.example.net"}
+	handler.SetCAAIssuers(issuers)
+
+	qr := dnstest.NewRecorder(&test.ResponseWriter{})
+	dnsMsg := &dns.Msg{}
+	dnsMsg.SetQuestion(exampleDomain, dns.TypeCAA)
+
+	handler.ServeDNS(qr, dnsMsg)
+
+	if qr.Msg == nil {
+		t.Fatal("got nil message")
+	}
+	if len(qr.Msg.Answer) != len(issuers) {
+		t.Fatalf("wrong answers: %v (want) != %v (got)", len(issuers), len(qr.Msg.Answer))
+	}
+	for i, rr := range qr.Msg.Answer {
+		caa, ok := rr.(*dns.CAA)
+		if !ok {
+			t.Fatal("wrong type")
+		}
+		if caa.Tag != "issue" {
+			t.Errorf("wrong Tag: %v (want) != %v (got)", "issue", caa.Tag)
+		}
+		if caa.Value != issuers[i] {
+			t.Errorf("wrong Value: %v (want) != %v (got)", issuers[i], caa.Value)
+		}
+	}
+}
+
+func TestDNSResponseNoCAA(t *testing.T) {
+	handler := NewTestHandler()
+
+	for _, qType := range []uint16{dns.TypeCAA, dns.TypeANY} {
+		qr := dnstest.NewRecorder(&test.ResponseWriter{})
+		dnsMsg := &dns.Msg{}
+		dnsMsg.SetQuestion(exampleDomain, qType)
+
+		handler.ServeDNS(qr, dnsMsg)
+
+		if qr.Msg == nil {
+			t.Fatal("got nil message")
+		}
+		for _, rr := range qr.Msg.Answer {
+			if _, ok := rr.(*dns.CAA); ok {
+				t.Errorf("unexpected CAA answer to %s: %v", dns.TypeToString[qType], rr)
+			}
+		}
+	}
+}
+
+func TestDNSResponseHTTPS(t *testing.T) {
+	handler := NewTestHandler()
+	handler.SetPublicIPv6(exampleIPv6)
+
+	for _, qType := range []uint16{dns.TypeHTTPS, dns.TypeSVCB} {
+		qr := dnstest.NewRecorder(&test.ResponseWriter{})
+		dnsMsg := &dns.Msg{}
+		dnsMsg.SetQuestion("sub."+exampleDomain, qType)
+
+		handler.ServeDNS(qr, dnsMsg)
+
+		if qr.Msg == nil {
+			t.Fatal("got nil message")
+		}
+		if len(qr.Msg.Answer) != 1 {
+			t.Fatalf("wrong answers: %v (want) != %v (got)", 1, len(qr.Msg.Answer))
+		}
+		var svcb *dns.SVCB
+		switch rr := qr.Msg.Answer[0].(type) {
+		case *dns.HTTPS:
+			svcb = &rr.SVCB
+		case *dns.SVCB:
+			svcb = rr
+		}
+		if svcb == nil || svcb.Hdr.Rrtype != qType {
+			t.Fatalf("wrong type: %v (want) != %v (got)",
+				dns.Type(qType), qr.Msg.Answer[0].Header().Rrtype)
+		}
+		if svcb.Target != "." {
+			t.Errorf("wrong Target: %v (want) != %v (got)", ".", svcb.Target)
+		}
+
+		want := []string{
+			"alpn=http/1.1",
+			"ipv4hint=" + exampleIP,
+			"ipv6hint=" + exampleIPv6,
+		}
+		var got []string
+		for _, kv := range svcb.Value {
+			got = append(got, kv.Key().String()+"="+kv.String())
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("wrong Value: %v (want) != %v (got)", want, got)
+		}
+	}
+}
+
+func TestDNSQueryType(t *testing.T) {
+	tests := []struct {
+		qType uint16
+		want  string
+	}{
+		{dns.TypeA, "A"},
+		{dns.TypeAAAA, "AAAA"},
+		{dns.TypeHTTPS, "HTTPS"},
+		{dns.TypeCAA, "CAA"},
+		{65000, "TYPE65000"},
+	}
+	for _, tt := range tests {
+		strg := &mockStorage{}
+		handler := dnsrcv.NewExportDNSHandler(exampleDomain, exampleIP, nil, strg)
+		qr := dnstest.NewRecorder(&test.ResponseWriter{})
+		dnsMsg := &dns.Msg{}
+		dnsMsg.SetQuestion(tID+"."+exampleDomain, tt.qType)
+
+		handler.ServeDNS(qr, dnsMsg)
+
+		if strg.evt.QueryType != tt.want {
+			t.Errorf("wrong QueryType: %v (want) != %v (got)", tt.want, strg.evt.QueryType)
+		}
+	}
+}
+
+func TestDNSResponseTestRecords(t *testing.T) {
+	strg := &mockStorage{records: []app.DNSRecord{
+		{Type: "A", Value: "169.254.169.254"},
+		{Type: "TXT", Value: strings.Repeat("a", 300), TTL: 60},
+	}}
+	handler := dnsrcv.NewExportDNSHandler(exampleDomain, exampleIP, []string{"testing"}, strg)
+
+	qr := dnstest.NewRecorder(&test.ResponseWriter{})
+	dnsMsg := &dns.Msg{}
+	dnsMsg.SetQuestion("sub."+tID+"."+exampleDomain, dns.TypeA)
+	handler.ServeDNS(qr, dnsMsg)
+
+	if qr.Msg == nil {
+		t.Fatal("got nil message")
+	}
+	if len(qr.Msg.Answer) != 1 {
+		t.Fatalf("wrong answers: %v (want) != %v (got)", 1, len(qr.Msg.Answer))
+	}
+	a, ok := qr.Msg.Answer[0].(*dns.A)
+	if !ok {
+		t.Fatal("wrong type")
+	}
+	if a.A.String() != "169.254.169.254" {
+		t.Errorf("wrong A: %v (want) != %v (got)", "169.254.169.254", a.A)
+	}
+	if a.Hdr.Ttl != 0 {
+		t.Errorf("wrong TTL: %v (want) != %v (got)", 0, a.Hdr.Ttl)
+	}
+
+	qr = dnstest.NewRecorder(&test.ResponseWriter{})
+	dnsMsg = &dns.Msg{}
+	dnsMsg.SetQuestion(tID+"."+exampleDomain, dns.TypeTXT)
+	handler.ServeDNS(qr, dnsMsg)
+
+	txt, ok := qr.Msg.Answer[0].(*dns.TXT)
+	if !ok {
+		t.Fatal("wrong type")
+	}
+	wantTxt := []string{strings.Repeat("a", 255), strings.Repeat("a", 45)}
+	if !reflect.DeepEqual(wantTxt, txt.Txt) {
+		t.Errorf("wrong Txt: %v (want) != %v (got)", wantTxt, txt.Txt)
+	}
+
+	// Query types without test records get the server's defaults.
+	qr = dnstest.NewRecorder(&test.ResponseWriter{})
+	dnsMsg = &dns.Msg{}
+	dnsMsg.SetQuestion(tID+"."+exampleDomain, dns.TypeMX)
+	handler.ServeDNS(qr, dnsMsg)
+
+	mx, ok := qr.Msg.Answer[0].(*dns.MX)
+	if !ok {
+		t.Fatal("wrong type")
+	}
+	if mx.Mx != "mail."+exampleDomain {
+		t.Errorf("wrong Mx: %v (want) != %v (got)", "mail."+exampleDomain, mx.Mx)
+	}
+}
+
+func TestDNSResponseTestCNAME(t *testing.T) {
+	strg := &mockStorage{records: []app.DNSRecord{
+		{Type: "CNAME", Value: "internal.example.net."},
+	}}
+	handler := dnsrcv.NewExportDNSHandler(exampleDomain, exampleIP, nil, strg)
+
+	for _, qType := range []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeCNAME} {
+		qr := dnstest.NewRecorder(&test.ResponseWriter{})
+		dnsMsg := &dns.Msg{}
+		dnsMsg.SetQuestion(tID+"."+exampleDomain, qType)
+		handler.ServeDNS(qr, dnsMsg)
+
+		if len(qr.Msg.Answer) != 1 {
+			t.Fatalf("wrong answers: %v (want) != %v (got)", 1, len(qr.Msg.Answer))
+		}
+		cname, ok := qr.Msg.Answer[0].(*dns.CNAME)
+		if !ok {
+			t.Fatal("wrong type")
+		}
+		if cname.Target != "internal.example.net." {
+			t.Errorf("wrong Target: %v (want) != %v (got)", "internal.example.net.", cname.Target)
+		}
+	}
+}
+
+// rebindingAnswers sends n queries of type qType for name to handler and returns the
+// IPs answered.
+func rebindingAnswers(t *testing.T, handler *dnsrcv.ExportDNSHandler, name string, qType uint16, n int) []string {
+	var ips []string
+	for i := 0; i < n; i++ {
+		qr := dnstest.NewRecorder(&test.ResponseWriter{})
+		dnsMsg := &dns.Msg{}
+		dnsMsg.SetQuestion(name, qType)
+		handler.ServeDNS(qr, dnsMsg)
+		if qr.Msg == nil {
+			t.Fatal("got nil message")
+		}
+		for _, rr := range qr.Msg.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				ips = append(ips, rr.A.String())
+			case *dns.AAAA:
+				ips = append(ips, rr.AAAA.String())
+			}
+		}
+	}
+	return ips
+}
+
+func TestDNSRebindingLabel(t *testing.T) {
+	strg := &mockStorage{}
+	handler := dnsrcv.NewExportDNSHandler(exampleDomain, exampleIP, nil, strg)
+	name := "cb00714d.7F000001.R." + tID + "." + exampleDomain
+
+	want := []string{"203.0.113.77", "127.0.0.1", "203.0.113.77", "127.0.0.1"}
+	got := rebindingAnswers(t, handler, name, dns.TypeA, 4)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong answers: %v (want) != %v (got)", want, got)
+	}
+
+	// Every answer is recorded in the event, with a TTL of 0.
+	wantAnswer := name + "\t0\tIN\tA\t127.0.0.1"
+	if len(strg.evt.Answers) != 1 || strg.evt.Answers[0] != wantAnswer {
+		t.Errorf("wrong event answers: %v (want) != %v (got)",
+			[]string{wantAnswer}, strg.evt.Answers)
+	}
+
+	// The other IP family gets an empty answer.
+	if got := rebindingAnswers(t, handler, name, dns.TypeAAAA, 1); len(got) != 0 {
+		t.Errorf("wrong answers: %v (want) != %v (got)", nil, got)
+	}
+
+	// Labels for other ids are ignored.
+	other := "cb00714d.7f000001.r.aaaaaaaaaaaaaaaaaaaaaaaaaa." + tID + "." + exampleDomain
+	want = []string{exampleIP, exampleIP}
+	got = rebindingAnswers(t, handler, other, dns.TypeA, 2)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong answers: %v (want) != %v (got)", want, got)
+	}
+}
+
+func TestDNSRebindingLabelIPv6(t *testing.T) {
+	strg := &mockStorage{}
+	handler := dnsrcv.NewExportDNSHandler(exampleDomain, exampleIP, nil, strg)
+	handler.SetPublicIPv6("2001:db8::77")
+	name := "20010db8000000000000000000000077.00000000000000000000000000000001.r." +
+		tID + "." + exampleDomain
+	if _, ok := dns.IsDomainName(name); !ok {
+		t.Fatalf("invalid name: %v", name)
+	}
+
+	// Resolved through a packed message, which fails if any label is too long.
+	dnsMsg := &dns.Msg{}
+	dnsMsg.SetQuestion(name, dns.TypeAAAA)
+	packed, err := dnsMsg.Pack()
+	if err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	if err := dnsMsg.Unpack(packed); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	qr := dnstest.NewRecorder(&test.ResponseWriter{})
+	handler.ServeDNS(qr, dnsMsg)
+	if qr.Msg == nil || len(qr.Msg.Answer) != 1 {
+		t.Fatalf("wrong answers: %v (want) != %v (got)", 1, qr.Msg)
+	}
+	if aaaa, ok := qr.Msg.Answer[0].(*dns.AAAA); !ok || aaaa.AAAA.String() != "2001:db8::77" {
+		t.Errorf("wrong answer: %v (want) != %v (got)", "2001:db8::77", qr.Msg.Answer[0])
+	}
+
+	want := []string{"::1", "2001:db8::77"}
+	got := rebindingAnswers(t, handler, name, dns.TypeAAAA, 2)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong answers: %v (want) != %v (got)", want, got)
+	}
+
+	// The other IP family gets an empty answer.
+	if got := rebindingAnswers(t, handler, name, dns.TypeA, 1); len(got) != 0 {
+		t.Errorf("wrong answers: %v (want) != %v (got)", nil, got)
+	}
+
+	// IPs of different families are ignored.
+	mixed := "cb00714d.00000000000000000000000000000001.r." + tID + "." + exampleDomain
+	want = []string{exampleIP}
+	if got := rebindingAnswers(t, handler, mixed, dns.TypeA, 1); !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong answers: %v (want) != %v (got)", want, got)
+	}
+}
+
+func TestDNSRebindingPolicy(t *testing.T) {
+	tests := []struct {
+		rb    app.Rebinding
+		qType uint16
+		want  []string
+	}{
+		{
+			app.Rebinding{First: "203.0.113.77", Second: "127.0.0.1", Mode: app.RebindCount, Count: 2},
+			dns.TypeA,
+			[]string{"203.0.113.77", "203.0.113.77", "127.0.0.1", "127.0.0.1"},
+		},
+		{
+			app.Rebinding{First: "2001:db8::77", Second: "::1", Mode: app.RebindTime, Delay: time.Hour},
+			dns.TypeAAAA,
+			[]string{"2001:db8::77", "2001:db8::77"},
+		},
+		{
+			app.Rebinding{First: "2001:db8::77", Second: "::1", Mode: app.RebindTime, Delay: time.Nanosecond},
+			dns.TypeAAAA,
+			[]string{"::1", "::1"},
+		},
+	}
+	for _, tt := range tests {
+		rb := tt.rb
+		strg := &mockStorage{rebinding: &rb}
+		handler := dnsrcv.NewExportDNSHandler(exampleDomain, exampleIP, nil, strg)
+		if tt.rb.Delay == time.Nanosecond {
+			// Let the delay elapse after the first query.
+			rebindingAnswers(t, handler, tID+"."+exampleDomain, tt.qType, 1)
+			time.Sleep(time.Millisecond)
+		}
+
+		got := rebindingAnswers(t, handler, tID+"."+exampleDomain, tt.qType, len(tt.want))
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("wrong answers: %v (want) != %v (got)", tt.want, got)
+		}
+	}
+}
+
+func TestDNSEventInfo(t *testing.T) {
+	strg := &mockStorage{}
+	handler := dnsrcv.NewExportDNSHandler(exampleDomain, exampleIP, nil, strg)
+
+	name := "Sub." + strings.ToUpper(tID[:2]) + tID[2:] + ".eXample.com."
+	dnsMsg := &dns.Msg{}
+	dnsMsg.SetQuestion(name, dns.TypeA)
+	dnsMsg.CheckingDisabled = true
+	dnsMsg.SetEdns0(4096, true)
+	opt := dnsMsg.IsEdns0()
+	opt.Option = append(opt.Option,
+		&dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1,
+			SourceNetmask: 24,
+			Address:       net.ParseIP("198.51.100.0").To4(),
+		},
+		&dns.EDNS0_COOKIE{
+			Code:   dns.EDNS0COOKIE,
+			Cookie: "24a5ac1da4fa7bbf",
+		},
+	)
+
+	qr := dnstest.NewRecorder(&test.ResponseWriter{})
+	handler.ServeDNS(qr, dnsMsg)
+
+	want := app.DNSInfo{
+		Name:         name,
+		Type:         "A",
+		Class:        "IN",
+		Flags:        []string{"rd", "cd"},
+		ClientSubnet: "198.51.100.0/24",
+		Cookie:       "24a5ac1da4fa7bbf",
+		DNSSECOK:     true,
+		CasePattern:  "Xxx.XXxxxxxxxxx55x5xx32xxxxxxx.xXxxxxx.xxx.",
+	}
+	if strg.evt.DNS == nil {
+		t.Fatal("DNS info not recorded")
+	}
+	if !reflect.DeepEqual(want, *strg.evt.DNS) {
+		t.Errorf("wrong DNS info: %v (want) != %v (got)", want, *strg.evt.DNS)
+	}
+
+	wantAnswer := name + "\t300\tIN\tA\t" + exampleIP
+	if len(strg.evt.Answers) != 1 || strg.evt.Answers[0] != wantAnswer {
+		t.Errorf("wrong answers: %v (want) != %v (got)", []string{wantAnswer}, strg.evt.Answers)
+	}
+}
+
+type mockChallenges map[string][]string
+
+func (c mockChallenges) ChallengeTXT(name string) []string {
+	return c[name]
+}
+
+func TestDNSResponseACMEChallenge(t *testing.T) {
+	handler := NewTestHandler()
+	handler.SetChallenges(mockChallenges{
+		"_acme-challenge." + exampleDomain: {"first", "second"},
+	})
+
+	// Validators may randomize the name's case.
+	qr := dnstest.NewRecorder(&test.ResponseWriter{})
+	dnsMsg := &dns.Msg{}
+	dnsMsg.SetQuestion("_ACME-challenge.Example.com.", dns.TypeTXT)
+	handler.ServeDNS(qr, dnsMsg)
+
+	if qr.Msg == nil {
+		t.Fatal("got nil message")
+	}
+	var got []string
+	for _, rr := range qr.Msg.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			t.Fatal("wrong type")
+		}
+		if txt.Hdr.Ttl != 0 {
+			t.Errorf("wrong TTL: %v (want) != %v (got)", 0, txt.Hdr.Ttl)
+		}
+		got = append(got, txt.Txt...)
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong TXT: %v (want) != %v (got)", want, got)
+	}
+
+	// Other names get the default TXT answer.
+	qr = dnstest.NewRecorder(&test.ResponseWriter{})
+	dnsMsg = &dns.Msg{}
+	dnsMsg.SetQuestion("_acme-challenge.sub."+exampleDomain, dns.TypeTXT)
+	handler.ServeDNS(qr, dnsMsg)
+
+	if len(qr.Msg.Answer) != 1 {
+		t.Fatalf("wrong answers: %v (want) != %v (got)", 1, len(qr.Msg.Answer))
+	}
+	if txt := qr.Msg.Answer[0].(*dns.TXT); reflect.DeepEqual([]string{"first"}, txt.Txt) {
+		t.Errorf("challenge served for the wrong name: %v", txt.Txt)
+	}
+}
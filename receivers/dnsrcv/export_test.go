@@ -16,3 +16,15 @@ func NewExportDNSHandler(domain string, publicIP string, txt []string, strg app.
 		},
 	}
 }
+
+func (h *ExportDNSHandler) SetPublicIPv6(ip string) {
+	h.publicIPv6 = ip
+}
+
+func (h *ExportDNSHandler) SetCAAIssuers(issuers []string) {
+	h.caaIssuers = issuers
+}
+
+func (h *ExportDNSHandler) SetChallenges(c ChallengeStore) {
+	h.challenges = c
+}
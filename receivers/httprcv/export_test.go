@@ -1,3 +1,24 @@
 package httprcv
 
-var CatchAll = catchAll
+import (
+	"net"
+
+	app "github.com/ciphermarco/BOAST"
+)
+
+var (
+	CatchAll    = catchAll
+	ConnContext = connContext
+)
+
+func NewHelloListener(ln net.Listener, strg app.Storage) net.Listener {
+	return &helloListener{Listener: ln, strg: strg}
+}
+
+func ClientHelloInfo(msg []byte) (*app.ClientHelloInfo, error) {
+	h, err := parseClientHello(msg)
+	if err != nil {
+		return nil, err
+	}
+	return h.info(), nil
+}
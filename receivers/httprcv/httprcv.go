@@ -1,10 +1,14 @@
 package httprcv
 
 import (
+	"bytes"
 	"crypto/tls"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"path"
 	"strings"
 	"time"
 
@@ -12,6 +16,9 @@ import (
 	"github.com/ciphermarco/BOAST/log"
 )
 
+// writeTimeout leaves time for writing a test's HTTP response after its maximum delay.
+const writeTimeout = app.HTTPResponseMaxDelay + 2*time.Second
+
 // Receiver represents the HTTP protocol receiver.
 type Receiver struct {
 	Name        string
@@ -22,6 +29,8 @@ type Receiver struct {
 	TLSKeyPath  string
 	IPHeader    string
 	Storage     app.Storage
+	// GetCertificate, if set, provides the TLS certificate instead of the files.
+	GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 }
 
 // ListenAndServe sets the necessary conditions for the underlying http.Server
@@ -49,7 +58,7 @@ func (r *Receiver) serveHTTP(port int, err chan error) {
 	srv := &http.Server{
 		Addr:         addr,
 		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: writeTimeout,
 		IdleTimeout:  120 * time.Second,
 	}
 
@@ -64,19 +73,33 @@ func (r *Receiver) serveHTTPS(port int, err chan error) {
 			tls.CurveP256, tls.X25519,
 		},
 	}
+	certPath, keyPath := r.TLSCertPath, r.TLSKeyPath
+	if r.GetCertificate != nil {
+		tlsConfig.GetCertificate = r.GetCertificate
+		certPath, keyPath = "", ""
+	}
 
 	addr := r.Addr(port)
 	srv := &http.Server{
 		Addr:         addr,
 		TLSConfig:    tlsConfig,
 		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+		ConnContext:  connContext,
 		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: writeTimeout,
 		IdleTimeout:  120 * time.Second,
 	}
 
+	ln, e := net.Listen("tcp", addr)
+	if e != nil {
+		err <- e
+		return
+	}
+	// The ClientHellos are recorded even if the handshakes fail.
+	ln = &helloListener{Listener: ln, strg: r.Storage}
+
 	log.Info("%s: Listening on https://%s\n", r.Name, addr)
-	err <- srv.ListenAndServeTLS(r.TLSCertPath, r.TLSKeyPath)
+	err <- srv.ServeTLS(ln, certPath, keyPath)
 }
 
 // Addr returns an address in the format expected by http.Server.
@@ -98,10 +121,7 @@ func catchAll(strg app.Storage, ipHdr string) func(w http.ResponseWriter, r *htt
 		}
 
 		// Does the request contain any known test ID (id)?
-		searchDumpID := func(k, v string) bool {
-			return strings.Contains(string(dump), k)
-		}
-		id, canary := strg.SearchTest(searchDumpID)
+		id, canary := strg.LookupTest(string(dump))
 		if id == "" || canary == "" {
 			log.Debug("HTTP event test not found: id=\"%s\" canary=\"%s\"",
 				id, canary)
@@ -129,6 +149,10 @@ func catchAll(strg app.Storage, ipHdr string) func(w http.ResponseWriter, r *htt
 			log.Info("Error creating a new HTTP event")
 			log.Debug("New HTTP event error: %v", err)
 		} else {
+			evt.HTTP = httpInfo(r)
+			if hc, ok := r.Context().Value(connHelloKey{}).(*helloConn); ok {
+				evt.ClientHello = hc.clientHello()
+			}
 			if err := strg.StoreEvent(evt); err != nil {
 				log.Info("Error storing a new HTTP event")
 				log.Debug("Store HTTP event error: %v", err)
@@ -138,7 +162,81 @@ func catchAll(strg app.Storage, ipHdr string) func(w http.ResponseWriter, r *htt
 			log.Debug("HTTP event object:\n%s", evt.String())
 		}
 
-		// Respond the canary to the client
+		// Respond the test's artifact or response if set, or the canary to the client
+		if a, loaded := strg.LoadArtifact(id, path.Base(r.URL.Path)); loaded {
+			w.Header().Set("Content-Type", a.ContentType)
+			w.Write(a.Data)
+			return
+		}
+		if res, loaded := strg.LoadHTTPResponse(id); loaded {
+			respond(w, r, &res, id, canary)
+			return
+		}
 		fmt.Fprintf(w, "<html><body>%s</body></html>", canary)
 	}
 }
+
+// httpInfo returns the details of the request r.
+// It must be called after the body is read by httputil.DumpRequest so it's in memory.
+func httpInfo(r *http.Request) *app.HTTPInfo {
+	info := &app.HTTPInfo{
+		Method:  r.Method,
+		Host:    r.Host,
+		Path:    r.URL.Path,
+		Query:   r.URL.Query(),
+		Proto:   r.Proto,
+		Headers: r.Header.Clone(),
+	}
+	if r.Body != nil {
+		if body, err := io.ReadAll(r.Body); err == nil {
+			info.Body = string(body)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+	}
+	if r.TLS != nil {
+		info.TLS = &app.TLSInfo{
+			Version:    tls.VersionName(r.TLS.Version),
+			ServerName: r.TLS.ServerName,
+		}
+	}
+	return info
+}
+
+// respond writes a test's HTTP response after waiting for its delay, replacing the
+// placeholders with the test's id and canary.
+func respond(w http.ResponseWriter, r *http.Request, res *app.HTTPResponse, id, canary string) {
+	if res.Delay > 0 {
+		delay := res.Delay
+		if delay > app.HTTPResponseMaxDelay {
+			delay = app.HTTPResponseMaxDelay
+		}
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	repl := strings.NewReplacer("{{id}}", id, "{{canary}}", canary)
+	for k, v := range res.Headers {
+		w.Header().Set(k, repl.Replace(v))
+	}
+	if res.ContentType != "" {
+		w.Header().Set("Content-Type", res.ContentType)
+	}
+
+	status := res.Status
+	if res.Location != "" {
+		w.Header().Set("Location", repl.Replace(res.Location))
+		if status == 0 {
+			status = http.StatusFound
+		}
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	fmt.Fprint(w, repl.Replace(res.Body))
+}
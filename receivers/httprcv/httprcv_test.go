@@ -1,13 +1,18 @@
 package httprcv_test
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	app "github.com/ciphermarco/BOAST"
 	"github.com/ciphermarco/BOAST/log"
 	"github.com/ciphermarco/BOAST/receivers/httprcv"
 )
@@ -38,9 +43,159 @@ func TestExpectedCanary(t *testing.T) {
 	}
 }
 
+func TestEventHTTPInfo(t *testing.T) {
+	body := "user=admin&password=s3cr3t"
+	req, err := http.NewRequest("POST", "https://sub.example.com/mpqhomfbxab55m5de32mywvfoy?a=1&a=2", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.TLS = &tls.ConnectionState{Version: tls.VersionTLS13, ServerName: "sub.example.com"}
+
+	mockStrg := &mockStorage{}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(httprcv.CatchAll(mockStrg, ""))
+	handler.ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+
+	want := &app.HTTPInfo{
+		Method:  "POST",
+		Host:    "sub.example.com",
+		Path:    "/mpqhomfbxab55m5de32mywvfoy",
+		Query:   map[string][]string{"a": {"1", "2"}},
+		Proto:   "HTTP/1.1",
+		Headers: map[string][]string{"Content-Type": {"application/x-www-form-urlencoded"}},
+		Body:    body,
+		TLS:     &app.TLSInfo{Version: "TLS 1.3", ServerName: "sub.example.com"},
+	}
+	got := mockStrg.evt.HTTP
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong HTTP info: %+v (want) != %+v (got)", want, got)
+	}
+
+	if wantRcv := "HTTPS"; wantRcv != mockStrg.evt.Receiver {
+		t.Errorf("wrong receiver: %v (want) != %v (got)", wantRcv, mockStrg.evt.Receiver)
+	}
+}
+
 func checkStatusCode(want int, got int, t *testing.T) {
 	if want != got {
 		t.Errorf("handler returned wrong status code: %v (want) != %v (got)",
 			want, got)
 	}
 }
+
+type responseMockStorage struct {
+	mockStorage
+	res app.HTTPResponse
+}
+
+func (s *responseMockStorage) LoadHTTPResponse(id string) (app.HTTPResponse, bool) {
+	return s.res, true
+}
+
+func TestCustomResponse(t *testing.T) {
+	req, err := http.NewRequest("GET", "/mpqhomfbxab55m5de32mywvfoy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &responseMockStorage{res: app.HTTPResponse{
+		Status:      http.StatusTeapot,
+		Headers:     map[string]string{"X-Test": "{{id}}"},
+		Body:        `<?xml version="1.0"?><canary>{{canary}}</canary>`,
+		ContentType: "application/xml",
+	}}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(httprcv.CatchAll(mockStrg, ""))
+	handler.ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusTeapot, rr.Code, t)
+
+	wantBody := fmt.Sprintf(`<?xml version="1.0"?><canary>%s</canary>`, tCanary)
+	if gotBody := rr.Body.String(); wantBody != gotBody {
+		t.Errorf("wrong body: %v (want) != %v (got)", wantBody, gotBody)
+	}
+	if gotHdr := rr.Header().Get("X-Test"); tID != gotHdr {
+		t.Errorf("wrong header: %v (want) != %v (got)", tID, gotHdr)
+	}
+	wantType := "application/xml"
+	if gotType := rr.Header().Get("Content-Type"); wantType != gotType {
+		t.Errorf("wrong content type: %v (want) != %v (got)", wantType, gotType)
+	}
+}
+
+func TestCustomResponseRedirect(t *testing.T) {
+	req, err := http.NewRequest("GET", "/mpqhomfbxab55m5de32mywvfoy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &responseMockStorage{res: app.HTTPResponse{
+		Location: "http://169.254.169.254/latest/meta-data/?{{canary}}",
+		Delay:    50 * time.Millisecond,
+	}}
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(httprcv.CatchAll(mockStrg, ""))
+	handler.ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusFound, rr.Code, t)
+
+	wantLoc := "http://169.254.169.254/latest/meta-data/?" + tCanary
+	if gotLoc := rr.Header().Get("Location"); wantLoc != gotLoc {
+		t.Errorf("wrong location: %v (want) != %v (got)", wantLoc, gotLoc)
+	}
+	if elapsed := time.Since(start); elapsed < mockStrg.res.Delay {
+		t.Errorf("did not delay: >= %v (want) != %v (got)", mockStrg.res.Delay, elapsed)
+	}
+}
+
+type artifactMockStorage struct {
+	mockStorage
+	a      app.Artifact
+	stored int
+}
+
+func (s *artifactMockStorage) LoadArtifact(id, name string) (app.Artifact, bool) {
+	return s.a, name == s.a.Name
+}
+
+func (s *artifactMockStorage) StoreEvent(evt app.Event) error {
+	s.stored++
+	return nil
+}
+
+func TestArtifact(t *testing.T) {
+	req, err := http.NewRequest("GET", "/mpqhomfbxab55m5de32mywvfoy/evil.dtd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockStrg := &artifactMockStorage{a: app.Artifact{
+		Name:        "evil.dtd",
+		ContentType: "application/xml-dtd",
+		Data:        []byte(`<!ENTITY % data SYSTEM "file:///etc/passwd">`),
+	}}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(httprcv.CatchAll(mockStrg, ""))
+	handler.ServeHTTP(rr, req)
+
+	checkStatusCode(http.StatusOK, rr.Code, t)
+
+	wantBody := string(mockStrg.a.Data)
+	if gotBody := rr.Body.String(); wantBody != gotBody {
+		t.Errorf("wrong body: %v (want) != %v (got)", wantBody, gotBody)
+	}
+	wantType := mockStrg.a.ContentType
+	if gotType := rr.Header().Get("Content-Type"); wantType != gotType {
+		t.Errorf("wrong content type: %v (want) != %v (got)", wantType, gotType)
+	}
+
+	// The fetch is still recorded.
+	wantStored := 1
+	if wantStored != mockStrg.stored {
+		t.Errorf("wrong stored events: %v (want) != %v (got)", wantStored, mockStrg.stored)
+	}
+}
@@ -1,11 +1,20 @@
 package httprcv_test
 
-import app "github.com/ciphermarco/BOAST"
+import (
+	"sync"
+
+	app "github.com/ciphermarco/BOAST"
+)
 
 var tID = "mpqhomfbxab55m5de32mywvfoy"
 var tCanary = "k2b27meg7dfifvxuxmnfnm24oa"
 
-type mockStorage struct{}
+type mockStorage struct {
+	mu  sync.Mutex
+	evt app.Event
+	// stored is the number of events stored.
+	stored int
+}
 
 func (s *mockStorage) SetTest(secret []byte) (id string, canary string, err error) {
 	return tID, tCanary, nil
@@ -15,11 +24,87 @@ func (s *mockStorage) LoadEvents(id string) (evts []app.Event, loaded bool) {
 	return evts, false
 }
 
+func (s *mockStorage) LoadEventsRange(id string, rng app.EventsRange) (evts []app.Event, loaded bool) {
+	return evts, false
+}
+
+func (s *mockStorage) DeleteEvents(id string) (deleted int, err error) {
+	return 0, nil
+}
+
+func (s *mockStorage) DeleteEvent(id, evtID string) (deleted bool, err error) {
+	return false, nil
+}
+
+func (s *mockStorage) WaitEvents(id string) (notify <-chan struct{}, cancel func()) {
+	return nil, func() {}
+}
+
+func (s *mockStorage) Subscribe(id string) (evts <-chan app.Event, cancel func() (dropped int)) {
+	return nil, func() int { return 0 }
+}
+
+func (s *mockStorage) SetWebhook(id string, wh app.Webhook) error {
+	return nil
+}
+
+func (s *mockStorage) LoadWebhook(id string) (wh app.Webhook, loaded bool) {
+	return wh, false
+}
+
+func (s *mockStorage) SetPublicKey(id string, key []byte) error {
+	return nil
+}
+
+func (s *mockStorage) SetHTTPResponse(id string, res *app.HTTPResponse) error {
+	return nil
+}
+
+func (s *mockStorage) LoadHTTPResponse(id string) (res app.HTTPResponse, loaded bool) {
+	return res, false
+}
+
+func (s *mockStorage) SetArtifact(id string, a app.Artifact) error {
+	return nil
+}
+
+func (s *mockStorage) LoadArtifact(id, name string) (a app.Artifact, loaded bool) {
+	return a, false
+}
+
+func (s *mockStorage) DeleteArtifact(id, name string) (deleted bool, err error) {
+	return false, nil
+}
+
+func (s *mockStorage) SetDNSRecords(id string, recs []app.DNSRecord) error {
+	return nil
+}
+
+func (s *mockStorage) LoadDNSRecords(id string) (recs []app.DNSRecord, loaded bool) {
+	return recs, false
+}
+
+func (s *mockStorage) SetRebinding(id string, rb *app.Rebinding) error {
+	return nil
+}
+
+func (s *mockStorage) LoadRebinding(id string) (rb app.Rebinding, loaded bool) {
+	return rb, false
+}
+
 func (s *mockStorage) SearchTest(f func(k, v string) bool) (id string, canary string) {
 	return tID, tCanary
 }
 
+func (s *mockStorage) LookupTest(str string) (id string, canary string) {
+	return tID, tCanary
+}
+
 func (s *mockStorage) StoreEvent(evt app.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evt = evt
+	s.stored++
 	return nil
 }
 
@@ -0,0 +1,71 @@
+// Package accept provides the accept loop shared by the receivers serving TCP sessions.
+package accept
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/ciphermarco/BOAST/log"
+)
+
+// retryDelay is the wait before accepting again after a temporary error.
+const retryDelay = 100 * time.Millisecond
+
+// busyTimeout is the deadline of the connections passed to busy.
+const busyTimeout = time.Second
+
+// Serve accepts the connections to ln and calls serve for each of them in a new
+// goroutine, closing them when it returns. At most max connections are served at the
+// same time: the others are passed to busy, if not nil, in a new goroutine with a short
+// deadline and then closed. If max connections are already being passed to busy, the
+// others are closed right away. The accept errors are logged with name (e.g. "SMTP").
+// It only returns when ln is closed.
+func Serve(ln net.Listener, name string, max int, serve, busy func(net.Conn)) error {
+	sessions := make(chan struct{}, max)
+	busies := make(chan struct{}, max)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			log.Debug("%s accept error: %v", name, err)
+			time.Sleep(retryDelay)
+			continue
+		}
+		select {
+		case sessions <- struct{}{}:
+		default:
+			rejectBusy(conn, busy, busies)
+			continue
+		}
+		go func() {
+			defer func() { <-sessions }()
+			defer conn.Close()
+			serve(conn)
+		}()
+	}
+}
+
+// rejectBusy passes conn to busy in a new goroutine with a short deadline, so a client
+// not reading the reply doesn't stall the accept loop, and then closes it. conn is closed
+// right away if busy is nil or busies is full.
+func rejectBusy(conn net.Conn, busy func(net.Conn), busies chan struct{}) {
+	if busy == nil {
+		conn.Close()
+		return
+	}
+	select {
+	case busies <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+	go func() {
+		defer func() { <-busies }()
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(busyTimeout))
+		busy(conn)
+	}()
+}
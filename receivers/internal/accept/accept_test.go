@@ -0,0 +1,129 @@
+package accept_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ciphermarco/BOAST/log"
+	"github.com/ciphermarco/BOAST/receivers/internal/accept"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func TestServe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	served := make(chan struct{})
+	ret := make(chan error, 1)
+	go func() {
+		ret <- accept.Serve(ln, "Test", 1, func(conn net.Conn) {
+			conn.Write([]byte("served"))
+			served <- struct{}{}
+			<-release
+		}, func(conn net.Conn) {
+			conn.Write([]byte("busy"))
+		})
+	}()
+
+	read := func() string {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		b, _ := io.ReadAll(conn)
+		return string(b)
+	}
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	<-served
+	// The second connection exceeds the maximum.
+	if got := read(); got != "busy" {
+		t.Errorf("wrong response: %v (want) != %v (got)", "busy", got)
+	}
+
+	// The first connection is closed once served, making room for another.
+	close(release)
+	first.SetDeadline(time.Now().Add(5 * time.Second))
+	if b, _ := io.ReadAll(first); string(b) != "served" {
+		t.Errorf("wrong response: %v (want) != %v (got)", "served", string(b))
+	}
+	go func() { <-served }()
+	if got := read(); got != "served" {
+		t.Errorf("wrong response: %v (want) != %v (got)", "served", got)
+	}
+
+	ln.Close()
+	select {
+	case err := <-ret:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("wrong error: %v (want) != %v (got)", net.ErrClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after closing the listener")
+	}
+}
+
+func TestServeBusyBlocked(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	release := make(chan struct{})
+	defer close(release)
+	served := make(chan struct{})
+	rejected := make(chan struct{})
+	go accept.Serve(ln, "Test", 1, func(conn net.Conn) {
+		served <- struct{}{}
+		<-release
+	}, func(conn net.Conn) {
+		rejected <- struct{}{}
+		<-release
+	})
+
+	var conns []net.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+		return conn
+	}
+
+	dial()
+	<-served
+	dial()
+	<-rejected
+
+	// A blocked busy does not stall the accept loop, and the connections exceeding
+	// the busy ones are closed without a reply.
+	conn := dial()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	b, err := io.ReadAll(conn)
+	if err != nil || len(b) != 0 {
+		t.Errorf("wrong response: %v (want) != %v, %v (got)", "", string(b), err)
+	}
+}
@@ -0,0 +1,44 @@
+// Package recordertest provides a boast.Recorder for testing the receivers.
+package recordertest
+
+import (
+	"strings"
+	"sync"
+
+	app "github.com/ciphermarco/BOAST"
+)
+
+// ID and Canary are the test found by Recorder.LookupTest.
+const (
+	ID     = "mpqhomfbxab55m5de32mywvfoy"
+	Canary = "k2b27meg7dfifvxuxmnfnm24oa"
+)
+
+// Recorder keeps the events stored in memory. Its zero value is ready to use.
+type Recorder struct {
+	mu   sync.Mutex
+	evts []app.Event
+}
+
+// LookupTest returns ID and Canary if s contains ID.
+func (r *Recorder) LookupTest(s string) (id string, canary string) {
+	if strings.Contains(s, ID) {
+		return ID, Canary
+	}
+	return "", ""
+}
+
+// StoreEvent keeps evt.
+func (r *Recorder) StoreEvent(evt app.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evts = append(r.evts, evt)
+	return nil
+}
+
+// Events returns the events stored.
+func (r *Recorder) Events() []app.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.evts
+}
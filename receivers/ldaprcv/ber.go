@@ -0,0 +1,132 @@
+package ldaprcv
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// BER identifiers used by LDAP (RFC 4511).
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+
+	tagBindRequest     = 0x60
+	tagBindResponse    = 0x61
+	tagUnbindRequest   = 0x42
+	tagSearchRequest   = 0x63
+	tagSearchResDone   = 0x65
+	tagExtendedRequest = 0x77
+	tagExtendedResp    = 0x78
+)
+
+var errBER = errors.New("invalid BER encoding")
+
+// readElement reads a BER element from r and returns its identifier, its value and the
+// whole element's encoding. Elements longer than max bytes are rejected.
+// Only the definite length forms allowed by LDAP are supported.
+func readElement(r *bufio.Reader, max int) (tag byte, value, raw []byte, err error) {
+	tag, err = r.ReadByte()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	raw = append(raw, tag)
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	raw = append(raw, b)
+	length := int(b)
+	if b&0x80 != 0 {
+		n := int(b & 0x7f)
+		if n == 0 || n > 4 {
+			return 0, nil, nil, errBER
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			if b, err = r.ReadByte(); err != nil {
+				return 0, nil, nil, err
+			}
+			raw = append(raw, b)
+			length = length<<8 | int(b)
+		}
+	}
+	if length > max {
+		return 0, nil, nil, errors.New("BER element too large")
+	}
+	hdr := len(raw)
+	raw = append(raw, make([]byte, length)...)
+	if _, err := io.ReadFull(r, raw[hdr:]); err != nil {
+		return 0, nil, nil, err
+	}
+	return tag, raw[hdr:], raw, nil
+}
+
+// parseElement parses the BER element at the start of b and returns its identifier, its
+// value and the bytes after it.
+func parseElement(b []byte) (tag byte, value, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errBER
+	}
+	tag, length, off := b[0], int(b[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(b) < 2+n {
+			return 0, nil, nil, errBER
+		}
+		length = 0
+		for _, c := range b[2 : 2+n] {
+			length = length<<8 | int(c)
+		}
+		off += n
+	}
+	if length < 0 || len(b)-off < length {
+		return 0, nil, nil, errBER
+	}
+	return tag, b[off : off+length], b[off+length:], nil
+}
+
+// parseInt parses the value of a BER INTEGER or ENUMERATED of up to 4 bytes.
+func parseInt(b []byte) (int, error) {
+	if len(b) == 0 || len(b) > 4 {
+		return 0, errBER
+	}
+	n := int(int8(b[0]))
+	for _, c := range b[1:] {
+		n = n<<8 | int(c)
+	}
+	return n, nil
+}
+
+// encodeElement returns the BER encoding of an element with the identifier tag and the
+// given value.
+func encodeElement(tag byte, value []byte) []byte {
+	b := []byte{tag}
+	switch l := len(value); {
+	case l < 0x80:
+		b = append(b, byte(l))
+	case l <= 0xff:
+		b = append(b, 0x81, byte(l))
+	case l <= 0xffff:
+		b = append(b, 0x82, byte(l>>8), byte(l))
+	default:
+		b = append(b, 0x84, byte(l>>24), byte(l>>16), byte(l>>8), byte(l))
+	}
+	return append(b, value...)
+}
+
+// encodeInt returns the BER encoding of an INTEGER or ENUMERATED with the identifier tag
+// and the value n.
+func encodeInt(tag byte, n int) []byte {
+	b := []byte{byte(n)}
+	for n >>= 8; n != 0 && n != -1; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	// A leading bit not matching the sign needs one more byte.
+	if (n == 0) != (b[0]&0x80 == 0) {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return encodeElement(tag, b)
+}
@@ -0,0 +1,14 @@
+package ldaprcv
+
+import "net"
+
+var (
+	EncodeElement = encodeElement
+	EncodeInt     = encodeInt
+	ParseElement  = parseElement
+	ParseInt      = parseInt
+)
+
+func (r *Receiver) Serve(ln net.Listener) error {
+	return r.serve(ln)
+}
@@ -0,0 +1,168 @@
+package ldaprcv
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+	"github.com/ciphermarco/BOAST/receivers/internal/accept"
+)
+
+const (
+	// maxMessageSize is the maximum size of an LDAP message.
+	maxMessageSize = 64 << 10
+	// maxSessions limits the concurrent sessions of each listener.
+	maxSessions    = 1000
+	messageTimeout = time.Minute
+)
+
+// LDAP result codes (RFC 4511).
+const (
+	resultSuccess       = 0
+	resultProtocolError = 2
+)
+
+// Receiver represents the LDAP protocol receiver.
+type Receiver struct {
+	Name    string
+	Host    string
+	Ports   []int
+	Storage app.Recorder
+}
+
+// ListenAndServe listens on each configured port and serves the BOAST's LDAP server.
+//
+// The server answers bind requests with success and search requests with no entries,
+// so JNDI-style lookups (e.g. ldap://example.com:389/<id>) can be recorded without
+// serving anything to the client.
+//
+// Any errors are returned via the received channel.
+func (r *Receiver) ListenAndServe(err chan error) {
+	if len(r.Ports) == 0 {
+		return
+	}
+	for _, port := range r.Ports {
+		go func(p int) {
+			addr := r.Host + fmt.Sprintf(":%d", p)
+			ln, e := net.Listen("tcp", addr)
+			if e != nil {
+				err <- e
+				return
+			}
+			log.Info("%s: Listening on ldap://%s\n", r.Name, addr)
+			err <- r.serve(ln)
+		}(port)
+	}
+}
+
+// serve accepts the connections to ln and serves each of them in a new goroutine.
+// It only returns when ln is closed.
+func (r *Receiver) serve(ln net.Listener) error {
+	return accept.Serve(ln, "LDAP", maxSessions, r.serveConn, nil)
+}
+
+// serveConn reads the client's messages and answers them until the client unbinds, an
+// invalid message is received, or an error occurs.
+func (r *Receiver) serveConn(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for {
+		conn.SetDeadline(time.Now().Add(messageTimeout))
+		tag, msg, raw, err := readElement(br, maxMessageSize)
+		if err != nil || tag != tagSequence {
+			if err != nil {
+				log.Debug("LDAP read error: %v", err)
+			}
+			return
+		}
+		_, v, op, err := parseElement(msg)
+		if err != nil {
+			return
+		}
+		msgID, err := parseInt(v)
+		if err != nil {
+			return
+		}
+		opTag, opValue, _, err := parseElement(op)
+		if err != nil {
+			return
+		}
+
+		var resp []byte
+		switch opTag {
+		case tagBindRequest:
+			// BindRequest ::= [APPLICATION 0] SEQUENCE { version INTEGER, name LDAPDN, ... }
+			_, _, rest, err := parseElement(opValue)
+			if err != nil {
+				return
+			}
+			_, name, _, err := parseElement(rest)
+			if err != nil {
+				return
+			}
+			r.store(conn, "bind", string(name), raw)
+			resp = result(msgID, tagBindResponse, resultSuccess)
+		case tagSearchRequest:
+			// SearchRequest ::= [APPLICATION 3] SEQUENCE { baseObject LDAPDN, ... }
+			_, base, _, err := parseElement(opValue)
+			if err != nil {
+				return
+			}
+			r.store(conn, "search", string(base), raw)
+			resp = result(msgID, tagSearchResDone, resultSuccess)
+		case tagUnbindRequest:
+			return
+		case tagExtendedRequest:
+			// Extended operations such as StartTLS are not supported.
+			resp = result(msgID, tagExtendedResp, resultProtocolError)
+		default:
+			// Other operations (e.g. abandon) are ignored.
+			continue
+		}
+		if _, err := conn.Write(resp); err != nil {
+			log.Debug("LDAP write error: %v", err)
+			return
+		}
+	}
+}
+
+// store records the request as an event if its DN contains a test's id.
+func (r *Receiver) store(conn net.Conn, op, dn string, raw []byte) {
+	log.Info("LDAP event received")
+	id, canary := r.Storage.LookupTest(dn)
+	if id == "" {
+		log.Debug("LDAP event test not found: id=\"%s\" canary=\"%s\"", id, canary)
+		return
+	}
+
+	evt, err := app.NewEvent(id, "LDAP", conn.RemoteAddr().String(), hex.Dump(raw))
+	if err != nil {
+		log.Info("Error creating a new LDAP event")
+		log.Debug("New LDAP event error: %v", err)
+		return
+	}
+	evt.LDAP = &app.LDAPInfo{Operation: op, DN: dn}
+	if err := r.Storage.StoreEvent(evt); err != nil {
+		log.Info("Error storing a new LDAP event")
+		log.Debug("Store LDAP event error: %v", err)
+	} else {
+		log.Info("New LDAP event stored")
+	}
+	log.Debug("LDAP event object:\n%s", evt.String())
+}
+
+// result returns an LDAPMessage with the message id msgID and an LDAPResult with the
+// identifier tag and the result code.
+func result(msgID int, tag byte, code int) []byte {
+	var op []byte
+	op = append(op, encodeInt(tagEnumerated, code)...)
+	op = append(op, encodeElement(tagOctetString, nil)...) // matchedDN
+	op = append(op, encodeElement(tagOctetString, nil)...) // diagnosticMessage
+	msg := encodeInt(tagInteger, msgID)
+	msg = append(msg, encodeElement(tag, op)...)
+	return encodeElement(tagSequence, msg)
+}
@@ -0,0 +1,231 @@
+package ldaprcv_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+	"github.com/ciphermarco/BOAST/receivers/internal/recordertest"
+	"github.com/ciphermarco/BOAST/receivers/ldaprcv"
+)
+
+const tID = recordertest.ID
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestConn serves a receiver on a random local port and returns a connection to it.
+func newTestConn(t *testing.T, strg app.Recorder) net.Conn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	rcv := &ldaprcv.Receiver{Name: "LDAP receiver", Storage: strg}
+	go rcv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func message(id int, tag byte, op ...[]byte) []byte {
+	msg := ldaprcv.EncodeInt(0x02, id)
+	msg = append(msg, ldaprcv.EncodeElement(tag, bytes.Join(op, nil))...)
+	return ldaprcv.EncodeElement(0x30, msg)
+}
+
+func bindRequest(id int, name string) []byte {
+	return message(id, 0x60,
+		ldaprcv.EncodeInt(0x02, 3),
+		ldaprcv.EncodeElement(0x04, []byte(name)),
+		ldaprcv.EncodeElement(0x80, nil), // simple authentication
+	)
+}
+
+func searchRequest(id int, base string) []byte {
+	return message(id, 0x63,
+		ldaprcv.EncodeElement(0x04, []byte(base)),
+		ldaprcv.EncodeInt(0x0a, 0), // scope
+		ldaprcv.EncodeInt(0x0a, 3), // derefAliases
+		ldaprcv.EncodeInt(0x02, 0), // sizeLimit
+		ldaprcv.EncodeInt(0x02, 0), // timeLimit
+		[]byte{0x01, 0x01, 0x00},   // typesOnly
+		ldaprcv.EncodeElement(0x87, []byte("objectClass")),
+		ldaprcv.EncodeElement(0x30, nil),
+	)
+}
+
+// readResult reads an LDAPMessage and returns its message id, its protocolOp's
+// identifier and its result code.
+func readResult(t *testing.T, r io.Reader) (id int, tag byte, code int) {
+	b := make([]byte, 512)
+	n, err := r.Read(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	_, msg, _, err := ldaprcv.ParseElement(b[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, v, op, err := ldaprcv.ParseElement(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err = ldaprcv.ParseInt(v); err != nil {
+		t.Fatal(err)
+	}
+	tag, res, _, err := ldaprcv.ParseElement(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, v, _, err = ldaprcv.ParseElement(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, err = ldaprcv.ParseInt(v); err != nil {
+		t.Fatal(err)
+	}
+	return id, tag, code
+}
+
+func TestLDAPBindAndSearch(t *testing.T) {
+	strg := &recordertest.Recorder{}
+	conn := newTestConn(t, strg)
+
+	tests := []struct {
+		req     []byte
+		tag     byte
+		op      string
+		dn      string
+		receive bool
+	}{
+		{bindRequest(1, ""), 0x61, "bind", "", false},
+		{searchRequest(2, tID), 0x65, "search", tID, true},
+		{bindRequest(3, "cn="+tID+",dc=example,dc=com"), 0x61, "bind", "cn=" + tID + ",dc=example,dc=com", true},
+	}
+	for i, tt := range tests {
+		if _, err := conn.Write(tt.req); err != nil {
+			t.Fatal(err)
+		}
+		id, tag, code := readResult(t, conn)
+		if id != i+1 {
+			t.Errorf("wrong message id: %v (want) != %v (got)", i+1, id)
+		}
+		if tag != tt.tag {
+			t.Errorf("wrong response: %#x (want) != %#x (got)", tt.tag, tag)
+		}
+		if code != 0 {
+			t.Errorf("wrong result code: %v (want) != %v (got)", 0, code)
+		}
+	}
+
+	evts := strg.Events()
+	if len(evts) != 2 {
+		t.Fatalf("wrong events: %v (want) != %v (got)", 2, len(evts))
+	}
+	for i, tt := range tests[1:] {
+		evt := evts[i]
+		if evt.Receiver != "LDAP" {
+			t.Errorf("wrong receiver: %v (want) != %v (got)", "LDAP", evt.Receiver)
+		}
+		want := app.LDAPInfo{Operation: tt.op, DN: tt.dn}
+		if evt.LDAP == nil || *evt.LDAP != want {
+			t.Errorf("wrong LDAP info: %v (want) != %v (got)", want, evt.LDAP)
+		}
+		if !strings.Contains(evt.Dump, "|0") {
+			t.Errorf("wrong dump: %q", evt.Dump)
+		}
+	}
+}
+
+func TestLDAPExtendedRequest(t *testing.T) {
+	conn := newTestConn(t, &recordertest.Recorder{})
+
+	// StartTLS extended request.
+	req := message(1, 0x77, ldaprcv.EncodeElement(0x80, []byte("1.3.6.1.4.1.1466.20037")))
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	_, tag, code := readResult(t, conn)
+	if tag != 0x78 {
+		t.Errorf("wrong response: %#x (want) != %#x (got)", 0x78, tag)
+	}
+	if code != 2 {
+		t.Errorf("wrong result code: %v (want) != %v (got)", 2, code)
+	}
+}
+
+func TestLDAPUnbind(t *testing.T) {
+	conn := newTestConn(t, &recordertest.Recorder{})
+
+	if _, err := conn.Write(message(1, 0x42)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bufio.NewReader(conn).ReadByte(); err != io.EOF {
+		t.Errorf("connection not closed: %v (want) != %v (got)", io.EOF, err)
+	}
+}
+
+func TestLDAPInvalidMessage(t *testing.T) {
+	strg := &recordertest.Recorder{}
+	conn := newTestConn(t, strg)
+
+	// A bind request with a truncated name.
+	if _, err := conn.Write([]byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x60, 0x07, 0x02, 0x01, 0x03, 0x04, 0x10, 'a', 'b'}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bufio.NewReader(conn).ReadByte(); err != io.EOF {
+		t.Errorf("connection not closed: %v (want) != %v (got)", io.EOF, err)
+	}
+	if evts := strg.Events(); len(evts) != 0 {
+		t.Errorf("wrong events: %v (want) != %v (got)", 0, len(evts))
+	}
+}
+
+func TestBERInt(t *testing.T) {
+	for _, n := range []int{0, 1, 127, 128, 255, 256, -1, -128, -129, 65535, 1 << 30} {
+		_, v, rest, err := ldaprcv.ParseElement(ldaprcv.EncodeInt(0x02, n))
+		if err != nil || len(rest) != 0 {
+			t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+		}
+		got, err := ldaprcv.ParseInt(v)
+		if err != nil {
+			t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+		}
+		if got != n {
+			t.Errorf("wrong integer: %v (want) != %v (got)", n, got)
+		}
+	}
+}
+
+func TestBERLongLength(t *testing.T) {
+	for _, size := range []int{0x7f, 0x80, 0xff, 0x100, 0x10000} {
+		value := bytes.Repeat([]byte{'a'}, size)
+		tag, v, rest, err := ldaprcv.ParseElement(ldaprcv.EncodeElement(0x04, value))
+		if err != nil {
+			t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+		}
+		if tag != 0x04 || !bytes.Equal(v, value) || len(rest) != 0 {
+			t.Errorf("wrong element for size %d", size)
+		}
+	}
+
+	if _, _, _, err := ldaprcv.ParseElement([]byte{0x04, 0x82, 0x01}); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+}
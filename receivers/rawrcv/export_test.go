@@ -0,0 +1,11 @@
+package rawrcv
+
+import "net"
+
+func (r *Receiver) ServeTCP(ln net.Listener) error {
+	return r.serveTCP(ln)
+}
+
+func (r *Receiver) ServeUDP(pc net.PacketConn) error {
+	return r.serveUDP(pc)
+}
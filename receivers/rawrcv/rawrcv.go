@@ -0,0 +1,157 @@
+package rawrcv
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+	"github.com/ciphermarco/BOAST/receivers/internal/accept"
+)
+
+const (
+	defaultMaxReadSize = 4096
+	defaultIdleTimeout = 5 * time.Second
+	// maxConnDuration limits how long a TCP connection is read regardless of the idle
+	// timeout so slow clients can't hold it forever.
+	maxConnDuration = time.Minute
+	// maxSessions limits the concurrent connections of each TCP listener.
+	maxSessions = 1000
+)
+
+// Receiver represents the raw TCP and UDP receiver.
+// It records the first bytes of any interaction with its ports containing a test's id.
+type Receiver struct {
+	Name     string
+	Host     string
+	TCPPorts []int
+	UDPPorts []int
+	// MaxReadSize is the maximum number of bytes read from each TCP connection or UDP
+	// datagram. It defaults to 4096.
+	MaxReadSize int
+	// IdleTimeout is how long a TCP connection is read without receiving any data
+	// before it's closed. It defaults to 5s.
+	IdleTimeout time.Duration
+	Storage     app.Recorder
+}
+
+// ListenAndServe listens on each configured TCP and UDP port and records the data
+// received. Nothing is ever sent back to the clients.
+//
+// Any errors are returned via the received channel.
+func (r *Receiver) ListenAndServe(err chan error) {
+	for _, port := range r.TCPPorts {
+		go func(p int) {
+			addr := r.Host + fmt.Sprintf(":%d", p)
+			ln, e := net.Listen("tcp", addr)
+			if e != nil {
+				err <- e
+				return
+			}
+			log.Info("%s: Listening on tcp://%s\n", r.Name, addr)
+			err <- r.serveTCP(ln)
+		}(port)
+	}
+	for _, port := range r.UDPPorts {
+		go func(p int) {
+			addr := r.Host + fmt.Sprintf(":%d", p)
+			pc, e := net.ListenPacket("udp", addr)
+			if e != nil {
+				err <- e
+				return
+			}
+			log.Info("%s: Listening on udp://%s\n", r.Name, addr)
+			err <- r.serveUDP(pc)
+		}(port)
+	}
+}
+
+// serveTCP accepts the connections to ln and reads each of them in a new goroutine.
+// It only returns when ln is closed.
+func (r *Receiver) serveTCP(ln net.Listener) error {
+	return accept.Serve(ln, "Raw TCP", maxSessions, func(conn net.Conn) {
+		data := r.read(conn)
+		r.store("tcp", conn.LocalAddr(), conn.RemoteAddr(), data)
+	}, nil)
+}
+
+// read reads from conn until MaxReadSize bytes are read, the idle timeout elapses
+// without receiving any data, or the client closes the connection.
+func (r *Receiver) read(conn net.Conn) []byte {
+	idle := r.IdleTimeout
+	if idle <= 0 {
+		idle = defaultIdleTimeout
+	}
+	end := time.Now().Add(maxConnDuration)
+	data := make([]byte, r.maxReadSize())
+	n := 0
+	for n < len(data) {
+		deadline := time.Now().Add(idle)
+		if deadline.After(end) {
+			deadline = end
+		}
+		conn.SetReadDeadline(deadline)
+		m, err := conn.Read(data[n:])
+		n += m
+		if err != nil {
+			break
+		}
+	}
+	return data[:n]
+}
+
+// serveUDP reads the datagrams sent to pc. Datagrams larger than MaxReadSize are
+// truncated. It only returns when pc is closed.
+func (r *Receiver) serveUDP(pc net.PacketConn) error {
+	buf := make([]byte, r.maxReadSize())
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			log.Debug("Raw UDP read error: %v", err)
+			continue
+		}
+		r.store("udp", pc.LocalAddr(), addr, buf[:n])
+	}
+}
+
+// store records the data as an event if it contains a test's id.
+func (r *Receiver) store(transport string, local, remote net.Addr, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	log.Info("Raw %s event received", transport)
+	id, canary := r.Storage.LookupTest(string(data))
+	if id == "" || canary == "" {
+		log.Debug("Raw event test not found: id=\"%s\" canary=\"%s\"", id, canary)
+		return
+	}
+
+	evt, err := app.NewEvent(id, "RAW", remote.String(), hex.Dump(data))
+	if err != nil {
+		log.Info("Error creating a new raw event")
+		log.Debug("New raw event error: %v", err)
+		return
+	}
+	evt.Transport = transport
+	evt.LocalAddr = local.String()
+	if err := r.Storage.StoreEvent(evt); err != nil {
+		log.Info("Error storing a new raw event")
+		log.Debug("Store raw event error: %v", err)
+	} else {
+		log.Info("New raw event stored")
+	}
+	log.Debug("Raw event object:\n%s", evt.String())
+}
+
+func (r *Receiver) maxReadSize() int {
+	if r.MaxReadSize <= 0 {
+		return defaultMaxReadSize
+	}
+	return r.MaxReadSize
+}
@@ -0,0 +1,159 @@
+package rawrcv_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+	"github.com/ciphermarco/BOAST/receivers/internal/recordertest"
+	"github.com/ciphermarco/BOAST/receivers/rawrcv"
+)
+
+const tID = recordertest.ID
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// sendTCP serves rcv on a random local port, sends data to it, and waits for the
+// server to close the connection. It returns the server's address.
+func sendTCP(t *testing.T, rcv *rawrcv.Receiver, data []byte, closeWrite bool) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go rcv.ServeTCP(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if closeWrite {
+		conn.(*net.TCPConn).CloseWrite()
+	}
+	// The event is stored before the server closes the connection. The connection is
+	// reset instead if the server closes it with unread data.
+	if _, err := io.Copy(ioutil.Discard, conn); err != nil && !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	return ln.Addr().String()
+}
+
+func TestRawTCP(t *testing.T) {
+	strg := &recordertest.Recorder{}
+	rcv := &rawrcv.Receiver{Name: "Raw receiver", Storage: strg}
+	data := []byte("*2\r\n$3\r\nGET\r\n$26\r\n" + tID + "\r\n")
+	addr := sendTCP(t, rcv, data, true)
+
+	evts := strg.Events()
+	if len(evts) != 1 {
+		t.Fatalf("wrong events: %v (want) != %v (got)", 1, len(evts))
+	}
+	evt := evts[0]
+	if evt.Receiver != "RAW" {
+		t.Errorf("wrong receiver: %v (want) != %v (got)", "RAW", evt.Receiver)
+	}
+	if evt.Transport != "tcp" {
+		t.Errorf("wrong transport: %v (want) != %v (got)", "tcp", evt.Transport)
+	}
+	if evt.LocalAddr != addr {
+		t.Errorf("wrong local address: %v (want) != %v (got)", addr, evt.LocalAddr)
+	}
+	if want := hex.Dump(data); evt.Dump != want {
+		t.Errorf("wrong dump: %q (want) != %q (got)", want, evt.Dump)
+	}
+}
+
+func TestRawTCPIdleTimeout(t *testing.T) {
+	strg := &recordertest.Recorder{}
+	rcv := &rawrcv.Receiver{Name: "Raw receiver", IdleTimeout: 50 * time.Millisecond, Storage: strg}
+	// The client doesn't close the connection, waiting for a reply that never comes.
+	sendTCP(t, rcv, []byte("stats "+tID+"\r\n"), false)
+
+	if evts := strg.Events(); len(evts) != 1 {
+		t.Errorf("wrong events: %v (want) != %v (got)", 1, len(evts))
+	}
+}
+
+func TestRawTCPMaxReadSize(t *testing.T) {
+	strg := &recordertest.Recorder{}
+	rcv := &rawrcv.Receiver{Name: "Raw receiver", MaxReadSize: len(tID) + 2, Storage: strg}
+	data := []byte("_ " + tID + " the rest is not read")
+	sendTCP(t, rcv, data, false)
+
+	evts := strg.Events()
+	if len(evts) != 1 {
+		t.Fatalf("wrong events: %v (want) != %v (got)", 1, len(evts))
+	}
+	if want := hex.Dump(data[:len(tID)+2]); evts[0].Dump != want {
+		t.Errorf("wrong dump: %q (want) != %q (got)", want, evts[0].Dump)
+	}
+}
+
+func TestRawTCPNoID(t *testing.T) {
+	strg := &recordertest.Recorder{}
+	rcv := &rawrcv.Receiver{Name: "Raw receiver", Storage: strg}
+	sendTCP(t, rcv, []byte("PING\r\n"), true)
+
+	if evts := strg.Events(); len(evts) != 0 {
+		t.Errorf("wrong events: %v (want) != %v (got)", 0, len(evts))
+	}
+}
+
+func TestRawUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	strg := &recordertest.Recorder{}
+	rcv := &rawrcv.Receiver{Name: "Raw receiver", MaxReadSize: 64, Storage: strg}
+	go rcv.ServeUDP(pc)
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data := []byte("\x00\x01\x00\x00\x00\x01\x00\x00get " + tID + "\r\n")
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	var evts []app.Event
+	for i := 0; i < 100 && len(evts) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		evts = strg.Events()
+	}
+	if len(evts) != 1 {
+		t.Fatalf("wrong events: %v (want) != %v (got)", 1, len(evts))
+	}
+	evt := evts[0]
+	if evt.Transport != "udp" {
+		t.Errorf("wrong transport: %v (want) != %v (got)", "udp", evt.Transport)
+	}
+	if evt.LocalAddr != pc.LocalAddr().String() {
+		t.Errorf("wrong local address: %v (want) != %v (got)", pc.LocalAddr(), evt.LocalAddr)
+	}
+	if evt.RemoteAddr != conn.LocalAddr().String() {
+		t.Errorf("wrong remote address: %v (want) != %v (got)", conn.LocalAddr(), evt.RemoteAddr)
+	}
+	if want := hex.Dump(data); evt.Dump != want {
+		t.Errorf("wrong dump: %q (want) != %q (got)", want, evt.Dump)
+	}
+}
@@ -0,0 +1,10 @@
+package smtprcv
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+func (r *Receiver) Serve(ln net.Listener, tlsConfig *tls.Config) error {
+	return r.serve(ln, tlsConfig)
+}
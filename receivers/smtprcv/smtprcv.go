@@ -0,0 +1,326 @@
+package smtprcv
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+	"github.com/ciphermarco/BOAST/receivers/internal/accept"
+)
+
+const (
+	// maxMessageSize is the maximum size of a message. It's advertised with EHLO.
+	maxMessageSize = 1 << 20
+	// maxLineSize is the maximum size of a command line, including the line break.
+	maxLineSize   = 1024
+	maxRecipients = 100
+	// maxSessions limits the concurrent sessions of each listener.
+	maxSessions    = 1000
+	commandTimeout = time.Minute
+	dataTimeout    = 5 * time.Minute
+)
+
+// Receiver represents the SMTP protocol receiver.
+type Receiver struct {
+	Name        string
+	Domain      string
+	Host        string
+	Ports       []int
+	TLSCertPath string
+	TLSKeyPath  string
+	Storage     app.Recorder
+	// GetCertificate, if set, provides the TLS certificate instead of the files.
+	GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// ListenAndServe listens on each configured port and serves the BOAST's SMTP server.
+// STARTTLS is offered if the TLS certificate and key or GetCertificate are set.
+//
+// For full functionality, the DNS receiver must be the domain's nameserver so its MX
+// answers point to this server.
+//
+// Any errors are returned via the received channel.
+func (r *Receiver) ListenAndServe(err chan error) {
+	if len(r.Ports) == 0 {
+		return
+	}
+	var tlsConfig *tls.Config
+	if r.GetCertificate != nil {
+		tlsConfig = &tls.Config{GetCertificate: r.GetCertificate}
+	} else if r.TLSCertPath != "" && r.TLSKeyPath != "" {
+		cert, e := tls.LoadX509KeyPair(r.TLSCertPath, r.TLSKeyPath)
+		if e != nil {
+			err <- e
+			return
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	for _, port := range r.Ports {
+		go func(p int) {
+			addr := r.Host + fmt.Sprintf(":%d", p)
+			ln, e := net.Listen("tcp", addr)
+			if e != nil {
+				err <- e
+				return
+			}
+			log.Info("%s: Listening on smtp://%s\n", r.Name, addr)
+			err <- r.serve(ln, tlsConfig)
+		}(port)
+	}
+}
+
+// serve accepts the connections to ln and serves each of them in a new goroutine.
+// It only returns when ln is closed.
+func (r *Receiver) serve(ln net.Listener, tlsConfig *tls.Config) error {
+	return accept.Serve(ln, "SMTP", maxSessions, func(conn net.Conn) {
+		newSession(r, conn, tlsConfig).serve()
+	}, func(conn net.Conn) {
+		fmt.Fprintf(conn, "421 %s Too many connections\r\n", r.Domain)
+	})
+}
+
+// session represents an SMTP session with a client.
+type session struct {
+	rcv       *Receiver
+	conn      net.Conn
+	br        *bufio.Reader
+	bw        *bufio.Writer
+	tlsConfig *tls.Config
+	tls       *app.TLSInfo
+	helo      string
+	// from is set by MAIL and reset with the recipients after each message.
+	from string
+	mail bool
+	to   []string
+}
+
+func newSession(r *Receiver, conn net.Conn, tlsConfig *tls.Config) *session {
+	return &session{
+		rcv:       r,
+		conn:      conn,
+		br:        bufio.NewReaderSize(conn, maxLineSize),
+		bw:        bufio.NewWriter(conn),
+		tlsConfig: tlsConfig,
+	}
+}
+
+// serve runs the session until the client quits or an error occurs.
+func (s *session) serve() {
+	defer s.conn.Close()
+	s.reply(220, s.rcv.Domain+" ESMTP BOAST")
+	for {
+		s.conn.SetDeadline(time.Now().Add(commandTimeout))
+		line, err := s.readLine()
+		if err != nil {
+			if errors.Is(err, bufio.ErrBufferFull) {
+				s.reply(500, "Line too long")
+			}
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			s.helo = arg
+			s.reset()
+			s.reply(250, s.rcv.Domain)
+		case "EHLO":
+			s.helo = arg
+			s.reset()
+			ext := []string{s.rcv.Domain, fmt.Sprintf("SIZE %d", maxMessageSize), "8BITMIME"}
+			if s.tlsConfig != nil && s.tls == nil {
+				ext = append(ext, "STARTTLS")
+			}
+			s.reply(250, ext...)
+		case "STARTTLS":
+			if s.tlsConfig == nil || s.tls != nil {
+				s.reply(502, "Command not implemented")
+				continue
+			}
+			s.reply(220, "Ready to start TLS")
+			if err := s.startTLS(); err != nil {
+				log.Debug("SMTP STARTTLS error: %v", err)
+				return
+			}
+		case "MAIL":
+			from, ok := path(arg, "FROM:")
+			if !ok {
+				s.reply(501, "Syntax: MAIL FROM:<address>")
+				continue
+			}
+			s.reset()
+			s.from, s.mail = from, true
+			s.reply(250, "OK")
+		case "RCPT":
+			to, ok := path(arg, "TO:")
+			switch {
+			case !ok:
+				s.reply(501, "Syntax: RCPT TO:<address>")
+			case !s.mail:
+				s.reply(503, "Need MAIL before RCPT")
+			case len(s.to) >= maxRecipients:
+				s.reply(452, "Too many recipients")
+			default:
+				s.to = append(s.to, to)
+				s.reply(250, "OK")
+			}
+		case "DATA":
+			if len(s.to) == 0 {
+				s.reply(503, "Need RCPT before DATA")
+				continue
+			}
+			s.reply(354, "End data with <CR><LF>.<CR><LF>")
+			if err := s.data(); err != nil {
+				return
+			}
+		case "RSET":
+			s.reset()
+			s.reply(250, "OK")
+		case "NOOP":
+			s.reply(250, "OK")
+		case "VRFY":
+			s.reply(252, "Cannot VRFY user")
+		case "QUIT":
+			s.reply(221, "Bye")
+			return
+		default:
+			s.reply(502, "Command not implemented")
+		}
+	}
+}
+
+// data reads the message after DATA and stores it if it's for a test.
+// Errors reading the message are returned so the session ends.
+func (s *session) data() error {
+	s.conn.SetDeadline(time.Now().Add(dataTimeout))
+	dr := textproto.NewReader(s.br).DotReader()
+	msg, err := io.ReadAll(io.LimitReader(dr, maxMessageSize+1))
+	if err != nil {
+		return err
+	}
+	defer s.reset()
+	if len(msg) > maxMessageSize {
+		// The rest of the message is discarded so the session can go on.
+		if _, err := io.Copy(io.Discard, dr); err != nil {
+			return err
+		}
+		s.reply(552, "Message too large")
+		return nil
+	}
+	s.store(string(msg))
+	s.reply(250, "OK")
+	return nil
+}
+
+// store records the message and its envelope as an event if they contain a test's id.
+func (s *session) store(msg string) {
+	log.Info("SMTP event received")
+	var b strings.Builder
+	fmt.Fprintf(&b, "HELO %s\r\nMAIL FROM:<%s>\r\n", s.helo, s.from)
+	for _, to := range s.to {
+		fmt.Fprintf(&b, "RCPT TO:<%s>\r\n", to)
+	}
+	b.WriteString("DATA\r\n")
+	b.WriteString(msg)
+	dump := b.String()
+
+	id, canary := s.rcv.Storage.LookupTest(dump)
+	if id == "" {
+		log.Debug("SMTP event test not found: id=\"%s\" canary=\"%s\"", id, canary)
+		return
+	}
+
+	evt, err := app.NewEvent(id, "SMTP", s.conn.RemoteAddr().String(), dump)
+	if err != nil {
+		log.Info("Error creating a new SMTP event")
+		log.Debug("New SMTP event error: %v", err)
+		return
+	}
+	evt.SMTP = &app.SMTPInfo{
+		Helo: s.helo,
+		From: s.from,
+		To:   s.to,
+		TLS:  s.tls,
+	}
+	if err := s.rcv.Storage.StoreEvent(evt); err != nil {
+		log.Info("Error storing a new SMTP event")
+		log.Debug("Store SMTP event error: %v", err)
+	} else {
+		log.Info("New SMTP event stored")
+	}
+	log.Debug("SMTP event object:\n%s", evt.String())
+}
+
+// startTLS upgrades the session's connection to TLS and resets its state as required
+// by RFC 3207.
+func (s *session) startTLS() error {
+	conn := tls.Server(s.conn, s.tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	state := conn.ConnectionState()
+	s.tls = &app.TLSInfo{
+		Version:    tls.VersionName(state.Version),
+		ServerName: state.ServerName,
+	}
+	// Any commands pipelined before the handshake are discarded with the old reader so
+	// they can't be injected into the TLS session.
+	s.conn = conn
+	s.br = bufio.NewReaderSize(conn, maxLineSize)
+	s.bw = bufio.NewWriter(conn)
+	s.helo = ""
+	s.reset()
+	return nil
+}
+
+// reset clears the envelope of the current message.
+func (s *session) reset() {
+	s.from, s.mail, s.to = "", false, nil
+}
+
+// readLine reads a command line without its line break.
+// It returns bufio.ErrBufferFull if the line is longer than maxLineSize.
+func (s *session) readLine() (string, error) {
+	line, err := s.br.ReadSlice('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// reply writes a reply with the code and one line for each text.
+func (s *session) reply(code int, text ...string) {
+	for i, t := range text {
+		sep := "-"
+		if i == len(text)-1 {
+			sep = " "
+		}
+		fmt.Fprintf(s.bw, "%d%s%s\r\n", code, sep, t)
+	}
+	s.bw.Flush()
+}
+
+// path returns the address in the MAIL or RCPT argument arg, which must start with
+// prefix (case insensitive), ignoring any parameters after it.
+func path(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", false
+	}
+	end := strings.Index(arg, ">")
+	if end < 0 {
+		return "", false
+	}
+	return arg[1:end], true
+}
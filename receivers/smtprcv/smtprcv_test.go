@@ -0,0 +1,194 @@
+package smtprcv_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+	"github.com/ciphermarco/BOAST/receivers/internal/recordertest"
+	"github.com/ciphermarco/BOAST/receivers/smtprcv"
+)
+
+const tID = recordertest.ID
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+var exampleDomain = "example.com"
+
+// newTestServer serves a receiver on a random local port and returns its address.
+func newTestServer(t *testing.T, strg app.Recorder, tlsConfig *tls.Config) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	rcv := &smtprcv.Receiver{Name: "SMTP receiver", Domain: exampleDomain, Storage: strg}
+	go rcv.Serve(ln, tlsConfig)
+	return ln.Addr().String()
+}
+
+func newTestTLSConfig(t *testing.T) *tls.Config {
+	cert, err := tls.LoadX509KeyPair("../../testdata/cert.pem", "../../testdata/key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+func TestSendMail(t *testing.T) {
+	strg := &recordertest.Recorder{}
+	addr := newTestServer(t, strg, nil)
+
+	to := fmt.Sprintf("admin@%s.%s", tID, exampleDomain)
+	msg := "Subject: Password reset\r\n\r\nhttps://example.net/reset?token=1\r\n"
+	if err := smtp.SendMail(addr, nil, "noreply@example.net", []string{to}, []byte(msg)); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	evts := strg.Events()
+	if len(evts) != 1 {
+		t.Fatalf("wrong events: %v (want) != %v (got)", 1, len(evts))
+	}
+	evt := evts[0]
+	if evt.Receiver != "SMTP" {
+		t.Errorf("wrong receiver: %v (want) != %v (got)", "SMTP", evt.Receiver)
+	}
+	want := &app.SMTPInfo{Helo: "localhost", From: "noreply@example.net", To: []string{to}}
+	if !reflect.DeepEqual(want, evt.SMTP) {
+		t.Errorf("wrong SMTP info: %v (want) != %v (got)", want, evt.SMTP)
+	}
+	// The message's line breaks are normalized when decoding it.
+	if !strings.HasSuffix(evt.Dump, "DATA\r\n"+strings.ReplaceAll(msg, "\r\n", "\n")) {
+		t.Errorf("wrong dump: %q", evt.Dump)
+	}
+	if !strings.Contains(evt.Dump, "RCPT TO:<"+to+">\r\n") {
+		t.Errorf("envelope not found in dump: %q", evt.Dump)
+	}
+}
+
+func TestSendMailIDInHeaders(t *testing.T) {
+	strg := &recordertest.Recorder{}
+	addr := newTestServer(t, strg, nil)
+
+	msg := fmt.Sprintf("Subject: Hi\r\nX-Forwarded: %s\r\n\r\nTest\r\n", tID)
+	if err := smtp.SendMail(addr, nil, "a@example.net", []string{"b@example.org"}, []byte(msg)); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	// Mail without any test's id is accepted but not stored.
+	if err := smtp.SendMail(addr, nil, "a@example.net", []string{"b@example.org"}, []byte("Test\r\n")); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	if got := len(strg.Events()); got != 1 {
+		t.Errorf("wrong events: %v (want) != %v (got)", 1, got)
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	strg := &recordertest.Recorder{}
+	addr := newTestServer(t, strg, newTestTLSConfig(t))
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		t.Fatal("STARTTLS not offered")
+	}
+	if err := c.StartTLS(&tls.Config{InsecureSkipVerify: true, ServerName: "mail." + exampleDomain}); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		t.Error("STARTTLS offered after TLS was started")
+	}
+	if err := c.Mail("a@example.net"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rcpt(tID + "@" + exampleDomain); err != nil {
+		t.Fatal(err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(w, "Test\r\n")
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	c.Quit()
+
+	evts := strg.Events()
+	if len(evts) != 1 {
+		t.Fatalf("wrong events: %v (want) != %v (got)", 1, len(evts))
+	}
+	want := &app.TLSInfo{Version: "TLS 1.3", ServerName: "mail." + exampleDomain}
+	if !reflect.DeepEqual(want, evts[0].SMTP.TLS) {
+		t.Errorf("wrong TLS info: %v (want) != %v (got)", want, evts[0].SMTP.TLS)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	addr := newTestServer(t, &recordertest.Recorder{}, nil)
+	c, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, _, err := c.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cmd  string
+		code int
+	}{
+		{"STARTTLS", 502},
+		{"RCPT TO:<a@example.com>", 503},
+		{"DATA", 503},
+		{"MAIL <a@example.com>", 501},
+		{"MAIL FROM:<a@example.com> SIZE=10", 250},
+		{"RCPT TO:a@example.com", 501},
+		{"VRFY admin", 252},
+		{"UNKNOWN", 502},
+		{"QUIT", 221},
+	}
+	for _, tt := range tests {
+		id, err := c.Cmd("%s", tt.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.StartResponse(id)
+		code, _, _ := c.ReadResponse(0)
+		c.EndResponse(id)
+		if tt.code != code {
+			t.Errorf("wrong code for %s: %v (want) != %v (got)", tt.cmd, tt.code, code)
+		}
+	}
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	strg := &recordertest.Recorder{}
+	addr := newTestServer(t, strg, nil)
+
+	msg := tID + strings.Repeat("A", 1<<20) + "\r\n"
+	err := smtp.SendMail(addr, nil, "a@example.net", []string{"b@example.org"}, []byte(msg))
+	if e, ok := err.(*textproto.Error); !ok || e.Code != 552 {
+		t.Errorf("wrong error: %v (want) != %v (got)", 552, err)
+	}
+	if got := len(strg.Events()); got != 0 {
+		t.Errorf("wrong events: %v (want) != %v (got)", 0, got)
+	}
+}
@@ -0,0 +1,612 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+)
+
+// Disk represents a storage persisted to disk.
+//
+// It keeps the same state as Storage in memory to serve reads and applies the same
+// limits and expiration semantics, but every change to this state is also appended to a
+// log file. On startup the log is replayed to recover the state, so a restart or a crash
+// does not lose the recorded events. Each record is synced to disk before the change is
+// acknowledged unless a sync interval is configured, in which case a power loss can lose
+// the changes made since the last sync. As the changes are serialized with the writes to
+// the log, each change waits for the syncs of the previous ones, which limits how many
+// changes are made per second; the sync interval lifts this limit.
+//
+// If a record can not be written or synced, the log is truncated back to its previous
+// size so the following records can still be replayed. If it can't be truncated either,
+// the changes stop being logged, and fail, until the log is compacted.
+//
+// The log is compacted (i.e. rewritten with only the live state) on startup and at every
+// configured check interval so it does not grow indefinitely.
+type Disk struct {
+	*Storage
+	// wmu serializes the changes to the state with the writes to the log so the log
+	// records are always in the same order as the changes they represent.
+	wmu  sync.Mutex
+	path string
+	f    *os.File
+	// size is the size of the log after its last complete record.
+	size int64
+	// dirty reports whether records were appended since the log was last synced.
+	dirty bool
+	// broken is the error that left a partial record at the end of the log, if any.
+	broken error
+
+	// cmu serializes the compactions. While compacting, the appended records are also
+	// kept in pending to be appended to the new log.
+	cmu        sync.Mutex
+	compacting bool
+	pending    []*record
+}
+
+// Log records' operations.
+const (
+	opSetTest    = "test"
+	opStoreEvent = "event"
+	opSetWebhook = "webhook"
+	opSetKey     = "key"
+	// opSetHTTPResponse records the test's HTTP response, or its removal if Response
+	// is not set.
+	opSetHTTPResponse = "response"
+	opSetArtifact     = "artifact"
+	// opDeleteArtifact records the deletion of the test's artifact Name.
+	opDeleteArtifact = "delete_artifact"
+	// opSetDNSRecords records the test's DNS records, or their removal if Records is
+	// empty.
+	opSetDNSRecords = "dns"
+	// opSetRebinding records the test's rebinding policy, or its removal if Rebinding is
+	// not set.
+	opSetRebinding = "rebinding"
+	// opDeleteEvents records the deletion of all of a test's events, or only of the
+	// event EventID if it's set.
+	opDeleteEvents = "delete"
+	// opTouch records the test's Configured time after it was used.
+	opTouch = "touch"
+)
+
+// touchLogDivisor makes the configured times of the tests in use be logged when more
+// than TTL/touchLogDivisor elapsed since they were last changed.
+const touchLogDivisor = 4
+
+// record represents a change to the storage's state as written to the log.
+type record struct {
+	Op        string            `json:"op"`
+	ID        string            `json:"id"`
+	Canary    string            `json:"canary,omitempty"`
+	Event     *app.Event        `json:"event,omitempty"`
+	Webhook   *app.Webhook      `json:"webhook,omitempty"`
+	Key       []byte            `json:"key,omitempty"`
+	EventID   string            `json:"eventID,omitempty"`
+	Response  *app.HTTPResponse `json:"response,omitempty"`
+	Artifact  *app.Artifact     `json:"artifact,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Records   []app.DNSRecord   `json:"records,omitempty"`
+	Rebinding *app.Rebinding    `json:"rebinding,omitempty"`
+	// Configured is the test's configured time after the change, so replaying the log
+	// does not extend the test's TTL.
+	Configured *time.Time `json:"configured,omitempty"`
+}
+
+// recordHeaderLen is the length of each record's header.
+// The header is composed by the payload's length and its CRC-32 checksum, both
+// big-endian uint32 values, so partially written records can be detected on recovery.
+const recordHeaderLen = 8
+
+// maxRecordLen limits the size of a record read from the log so a corrupted length can
+// not cause huge allocations.
+const maxRecordLen = 64 << 20
+
+// NewDisk contains the logic to construct and return a new *Disk according to the passed
+// *Config object and log file path. The log file is created if it does not exist,
+// otherwise it's replayed to recover the storage's state. In case of error, it returns
+// the error to the caller.
+func NewDisk(cfg *Config, path string) (*Disk, error) {
+	strg, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	d := &Disk{Storage: strg, path: path}
+	if err := d.recover(); err != nil {
+		return nil, err
+	}
+	if err := d.compact(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// SetTest works like Storage.SetTest but logs the test if it was created by this call,
+// and once in a while the extended configured time of an existing one.
+func (d *Disk) SetTest(secret []byte) (id string, canary string, err error) {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	id, canary, created, err := d.setTest(secret)
+	if err != nil {
+		return id, canary, err
+	}
+	if !created {
+		// The extended configured time is only logged once in a while so using a test
+		// doesn't always write to the log. A restart shortens its TTL by
+		// TTL/touchLogDivisor at most.
+		if prev, touched := d.touch(id); touched && time.Since(prev) > d.cfg.TTL/touchLogDivisor {
+			return id, canary, d.append(&record{Op: opTouch, ID: id, Configured: d.configured(id)})
+		}
+		return id, canary, nil
+	}
+	if err := d.append(&record{Op: opSetTest, ID: id, Canary: canary}); err != nil {
+		return "", "", err
+	}
+	return id, canary, nil
+}
+
+// StoreEvent works like Storage.StoreEvent but logs the event if it was stored.
+func (d *Disk) StoreEvent(evt app.Event) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	stored, err := d.storeEvent(evt)
+	if err != nil {
+		return err
+	}
+	return d.append(&record{Op: opStoreEvent, ID: stored.TestID, Event: &stored})
+}
+
+// DeleteEvents works like Storage.DeleteEvents but logs the deletion if any event was
+// deleted.
+func (d *Disk) DeleteEvents(id string) (deleted int, err error) {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	deleted, err = d.Storage.DeleteEvents(id)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+	return deleted, d.append(&record{Op: opDeleteEvents, ID: id})
+}
+
+// DeleteEvent works like Storage.DeleteEvent but logs the deletion if the event was
+// deleted.
+func (d *Disk) DeleteEvent(id, evtID string) (deleted bool, err error) {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	deleted, err = d.Storage.DeleteEvent(id, evtID)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+	return deleted, d.append(&record{Op: opDeleteEvents, ID: id, EventID: evtID})
+}
+
+// SetWebhook works like Storage.SetWebhook but logs the webhook if it was set.
+func (d *Disk) SetWebhook(id string, wh app.Webhook) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	if err := d.Storage.SetWebhook(id, wh); err != nil {
+		return err
+	}
+	return d.append(&record{Op: opSetWebhook, ID: id, Webhook: &wh, Configured: d.configured(id)})
+}
+
+// SetPublicKey works like Storage.SetPublicKey but logs the key if it was set.
+func (d *Disk) SetPublicKey(id string, key []byte) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	if err := d.Storage.SetPublicKey(id, key); err != nil {
+		return err
+	}
+	return d.append(&record{Op: opSetKey, ID: id, Key: key, Configured: d.configured(id)})
+}
+
+// SetHTTPResponse works like Storage.SetHTTPResponse but logs the response if it was
+// set.
+func (d *Disk) SetHTTPResponse(id string, res *app.HTTPResponse) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	if err := d.Storage.SetHTTPResponse(id, res); err != nil {
+		return err
+	}
+	return d.append(&record{Op: opSetHTTPResponse, ID: id, Response: res, Configured: d.configured(id)})
+}
+
+// SetDNSRecords works like Storage.SetDNSRecords but logs the records if they were set.
+func (d *Disk) SetDNSRecords(id string, recs []app.DNSRecord) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	if err := d.Storage.SetDNSRecords(id, recs); err != nil {
+		return err
+	}
+	return d.append(&record{Op: opSetDNSRecords, ID: id, Records: recs, Configured: d.configured(id)})
+}
+
+// SetRebinding works like Storage.SetRebinding but logs the policy if it was set.
+func (d *Disk) SetRebinding(id string, rb *app.Rebinding) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	if err := d.Storage.SetRebinding(id, rb); err != nil {
+		return err
+	}
+	return d.append(&record{Op: opSetRebinding, ID: id, Rebinding: rb, Configured: d.configured(id)})
+}
+
+// SetArtifact works like Storage.SetArtifact but logs the artifact if it was stored.
+func (d *Disk) SetArtifact(id string, a app.Artifact) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	if err := d.Storage.SetArtifact(id, a); err != nil {
+		return err
+	}
+	return d.append(&record{Op: opSetArtifact, ID: id, Artifact: &a, Configured: d.configured(id)})
+}
+
+// DeleteArtifact works like Storage.DeleteArtifact but logs the deletion if the
+// artifact was deleted.
+func (d *Disk) DeleteArtifact(id, name string) (deleted bool, err error) {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	deleted, err = d.Storage.DeleteArtifact(id, name)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+	return deleted, d.append(&record{Op: opDeleteArtifact, ID: id, Name: name})
+}
+
+// StartExpire works like Storage.StartExpire but also compacts the log at every check
+// interval and, if there's a sync interval, syncs the log at every sync interval.
+func (d *Disk) StartExpire(ret chan error) {
+	if d.cfg.SyncInterval > 0 {
+		go func() {
+			for range time.Tick(d.cfg.SyncInterval) {
+				if err := d.sync(); err != nil {
+					log.Info("Could not sync the storage log")
+					log.Debug("Disk.sync error: %v", err)
+				}
+			}
+		}()
+	}
+	go func() {
+		for range time.Tick(d.cfg.CheckInterval) {
+			if err := d.compact(); err != nil {
+				log.Info("Could not compact the storage log")
+				log.Debug("Disk.compact error: %v", err)
+			}
+		}
+	}()
+	d.Storage.StartExpire(ret)
+}
+
+// Close compacts the log and closes its file.
+func (d *Disk) Close() error {
+	err := d.compact()
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	if cerr := d.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// recover replays the log file to recover the storage's state.
+//
+// A partially written or corrupted record means the server stopped while writing it,
+// so the log is considered to end right before it. Events whose TTL already elapsed are
+// not recovered.
+func (d *Disk) recover() error {
+	f, err := os.Open(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	r := bufio.NewReader(f)
+	n := 0
+	for {
+		rec, err := readRecord(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Info("Storage log is truncated or corrupted after %d records; ignoring the rest", n)
+			log.Debug("Disk.recover error: %v", err)
+			break
+		}
+		d.unsafeReplay(rec)
+		n++
+	}
+	log.Info("Storage recovered %d tests and %d events from %s", d.totalTests, d.totalEvents, d.path)
+	return nil
+}
+
+// unsafeReplay applies a log record to the storage's state leaving the mutex lock to the
+// caller. It's unsafe to be used without setting the appropriate lock externally.
+func (d *Disk) unsafeReplay(rec *record) {
+	switch rec.Op {
+	case opSetTest:
+		if _, exists := d.tests[rec.ID]; !exists && d.totalTests < d.maxTests {
+			d.unsafeAddTest(rec.ID, rec.Canary)
+		}
+	case opStoreEvent:
+		if rec.Event == nil || time.Since(rec.Event.Time) > d.cfg.TTL {
+			return
+		}
+		d.unsafeStoreEvent(*rec.Event)
+	case opSetWebhook:
+		if rec.Webhook != nil {
+			d.unsafeSetWebhook(rec.ID, *rec.Webhook)
+		}
+	case opSetKey:
+		d.unsafeSetPublicKey(rec.ID, rec.Key)
+	case opSetHTTPResponse:
+		d.unsafeSetHTTPResponse(rec.ID, rec.Response)
+	case opSetArtifact:
+		if rec.Artifact != nil {
+			d.unsafeSetArtifact(rec.ID, *rec.Artifact)
+		}
+	case opDeleteArtifact:
+		d.unsafeDeleteArtifact(rec.ID, rec.Name)
+	case opSetDNSRecords:
+		d.unsafeSetDNSRecords(rec.ID, rec.Records)
+	case opSetRebinding:
+		d.unsafeSetRebinding(rec.ID, rec.Rebinding)
+	case opDeleteEvents:
+		if rec.EventID != "" {
+			d.unsafeDeleteEvent(rec.ID, rec.EventID)
+		} else {
+			d.unsafeDeleteEvents(rec.ID)
+		}
+	case opTouch:
+		// Only the configured time below is changed.
+	default:
+		log.Debug("Disk.unsafeReplay unknown operation: %s", rec.Op)
+	}
+	if t, exists := d.tests[rec.ID]; exists && rec.Configured != nil {
+		t.configured = *rec.Configured
+		d.tests[rec.ID] = t
+	}
+}
+
+// configured returns the test id's configured time.
+func (d *Disk) configured(id string) *time.Time {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	c := d.tests[id].configured
+	return &c
+}
+
+// compact rewrites the log with only the storage's live state.
+// The state is copied while holding the locks, but the new log is written without them
+// so the storage can keep changing meanwhile. The records appended to the current log in
+// the meantime are also appended to the new log, which atomically replaces the current
+// one only after being synced to disk, so a crash while compacting does not lose any
+// data.
+func (d *Disk) compact() error {
+	d.cmu.Lock()
+	defer d.cmu.Unlock()
+	tmp := d.path + ".tmp"
+	defer os.Remove(tmp)
+	f, err := d.writeState(tmp)
+	return d.replaceLog(tmp, f, err)
+}
+
+// writeState writes the storage's current state to a new log file at path.
+// It must be called with the cmu lock held.
+func (d *Disk) writeState(path string) (*os.File, error) {
+	d.wmu.Lock()
+	recs := d.stateRecords()
+	d.compacting = true
+	d.wmu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return f, writeRecords(f, recs)
+}
+
+// replaceLog appends the records appended to the current log since writeState to the new
+// log f at path and makes it the current log. If err is not nil, it's returned and the
+// current log is kept. It must be called with the cmu lock held.
+func (d *Disk) replaceLog(path string, f *os.File, err error) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	pending := d.pending
+	d.compacting, d.pending = false, nil
+	if err == nil {
+		err = writeRecords(f, pending)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if f != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(path, d.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(d.path)); err != nil {
+		return err
+	}
+
+	if d.f != nil {
+		d.f.Close()
+	}
+	d.dirty, d.broken = false, nil
+	if d.f, err = os.OpenFile(d.path, os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return err
+	}
+	fi, err := d.f.Stat()
+	if err != nil {
+		return err
+	}
+	d.size = fi.Size()
+	return nil
+}
+
+// stateRecords returns the records needed to recover the storage's current state.
+// The records only reference values that are replaced, never modified, by the changes to
+// the state, so they can be written after releasing the lock.
+func (d *Disk) stateRecords() []*record {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var recs []*record
+	for id, t := range d.tests {
+		recs = append(recs, &record{Op: opSetTest, ID: id, Canary: t.canary})
+		if t.webhook.URL != "" {
+			wh := t.webhook
+			recs = append(recs, &record{Op: opSetWebhook, ID: id, Webhook: &wh})
+		}
+		if t.publicKey != nil {
+			recs = append(recs, &record{Op: opSetKey, ID: id, Key: t.publicKey[:]})
+		}
+		if t.httpResponse != nil {
+			recs = append(recs, &record{Op: opSetHTTPResponse, ID: id, Response: t.httpResponse})
+		}
+		for _, a := range t.artifacts {
+			a := a
+			recs = append(recs, &record{Op: opSetArtifact, ID: id, Artifact: &a})
+		}
+		if len(t.dnsRecords) > 0 {
+			recs = append(recs, &record{Op: opSetDNSRecords, ID: id, Records: t.dnsRecords})
+		}
+		if t.rebinding != nil {
+			recs = append(recs, &record{Op: opSetRebinding, ID: id, Rebinding: t.rebinding})
+		}
+		// The last configuration record, or the test's record if its configuration was
+		// removed, restores the configured time.
+		if !t.configured.IsZero() {
+			configured := t.configured
+			recs[len(recs)-1].Configured = &configured
+		}
+		evts := make([]app.Event, t.events.Len())
+		copy(evts, *t.events)
+		sort.Slice(evts, func(i, j int) bool { return evts[i].Time.Before(evts[j].Time) })
+		for i := range evts {
+			recs = append(recs, &record{Op: opStoreEvent, ID: id, Event: &evts[i]})
+		}
+	}
+	return recs
+}
+
+// writeRecords writes recs to w through a buffer.
+func writeRecords(w io.Writer, recs []*record) error {
+	bw := bufio.NewWriter(w)
+	for _, rec := range recs {
+		if _, err := writeRecord(bw, rec); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// append writes a record to the end of the log and, if there's no sync interval, syncs
+// it to disk. If it fails, the log is truncated back to its previous size or, if that
+// fails too, marked as broken. It must be called with the wmu lock held.
+func (d *Disk) append(rec *record) error {
+	if d.broken != nil {
+		return fmt.Errorf("storage log is broken until compacted: %w", d.broken)
+	}
+	n, err := writeRecord(d.f, rec)
+	if err == nil && d.cfg.SyncInterval <= 0 {
+		err = d.f.Sync()
+	}
+	if err != nil {
+		if terr := d.f.Truncate(d.size); terr != nil {
+			d.broken = err
+		}
+		return err
+	}
+	d.size += int64(n)
+	if d.compacting {
+		d.pending = append(d.pending, rec)
+	}
+	if d.cfg.SyncInterval > 0 {
+		d.dirty = true
+	}
+	return nil
+}
+
+// sync syncs the log to disk if any record was appended since the last sync.
+func (d *Disk) sync() error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	if !d.dirty {
+		return nil
+	}
+	d.dirty = false
+	return d.f.Sync()
+}
+
+// writeRecord writes rec to w with its header in a single Write call and returns the
+// number of bytes written.
+func writeRecord(w io.Writer, rec *record) (int, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return 0, err
+	}
+	b := make([]byte, recordHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(payload))
+	copy(b[recordHeaderLen:], payload)
+	return w.Write(b)
+}
+
+// readRecord reads the next record from r.
+// It returns io.EOF only if r ends exactly at the end of the previous record.
+func readRecord(r io.Reader) (*record, error) {
+	var hdr [recordHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated record header")
+		}
+		return nil, err
+	}
+	l := binary.BigEndian.Uint32(hdr[0:4])
+	sum := binary.BigEndian.Uint32(hdr[4:8])
+	if l > maxRecordLen {
+		return nil, fmt.Errorf("record too long: %d bytes", l)
+	}
+	payload := make([]byte, l)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, errors.New("truncated record payload")
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, errors.New("record checksum mismatch")
+	}
+	rec := &record{}
+	if err := json.Unmarshal(payload, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// syncDir syncs a directory so a rename inside it is persisted.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,458 @@
+package storage_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/storage"
+)
+
+func newTestDisk(t *testing.T, cfg *storage.Config, path string) *storage.Disk {
+	d, err := storage.NewDisk(cfg, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	return d
+}
+
+func TestDiskRecover(t *testing.T) {
+	cfg := storage.NewTestConfig()
+	path := filepath.Join(t.TempDir(), "boast.log")
+
+	d := newTestDisk(t, cfg, path)
+	id, canary, err := d.SetTest(storage.TTest.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totalEvts := 3
+	for i := 0; i < totalEvts; i++ {
+		if err := d.StoreEvent(storage.NewTestEvent()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not closing the storage simulates a crash.
+
+	d = newTestDisk(t, cfg, path)
+	defer d.Close()
+
+	wantTotal := totalEvts
+	gotTotal := d.TotalEvents()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total events: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+
+	wantTotal = 1
+	gotTotal = d.TotalTests()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total tests: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+
+	gotID, gotCanary := d.LookupTest(id)
+	if id != gotID {
+		t.Errorf("wrong ID: %v (want) != %v (got)", id, gotID)
+	}
+	if canary != gotCanary {
+		t.Errorf("wrong canary: %v (want) != %v (got)", canary, gotCanary)
+	}
+
+	evts, loaded := d.LoadEvents(id)
+	if !loaded || len(evts) != totalEvts {
+		t.Errorf("wrong events: %v (want) != %v (got)", totalEvts, len(evts))
+	}
+}
+
+func TestDiskRecoverLimits(t *testing.T) {
+	cfg := storage.NewTestConfig()
+	path := filepath.Join(t.TempDir(), "boast.log")
+
+	d := newTestDisk(t, cfg, path)
+	d.SetTest(storage.TTest.Secret)
+	for i := 0; i < cfg.MaxEventsByTest+10; i++ {
+		if err := d.StoreEvent(storage.NewTestEvent()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Close()
+
+	d = newTestDisk(t, cfg, path)
+	defer d.Close()
+
+	wantTotal := cfg.MaxEventsByTest
+	gotTotal := d.TotalEvents()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+}
+
+func TestDiskRecoverExpired(t *testing.T) {
+	cfg := storage.NewTestConfig()
+	path := filepath.Join(t.TempDir(), "boast.log")
+
+	d := newTestDisk(t, cfg, path)
+	d.SetTest(storage.TTest.Secret)
+	evt := storage.NewTestEvent()
+	evt.Time = time.Now().Add(-2 * cfg.TTL)
+	d.StoreEvent(evt)
+	d.StoreEvent(storage.NewTestEvent())
+	d.Close()
+
+	d = newTestDisk(t, cfg, path)
+	defer d.Close()
+
+	wantTotal := 1
+	gotTotal := d.TotalEvents()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+}
+
+func TestDiskRecoverTruncatedLog(t *testing.T) {
+	cfg := storage.NewTestConfig()
+	path := filepath.Join(t.TempDir(), "boast.log")
+
+	d := newTestDisk(t, cfg, path)
+	d.SetTest(storage.TTest.Secret)
+	d.StoreEvent(storage.NewTestEvent())
+	d.StoreEvent(storage.NewTestEvent())
+
+	// A crash in the middle of a write leaves a partial record behind.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 0xde, 0xad, '{', '"'})
+	f.Close()
+
+	d = newTestDisk(t, cfg, path)
+
+	wantTotal := 2
+	gotTotal := d.TotalEvents()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+
+	// The partial record is dropped by compaction so new records are not lost after it.
+	d.StoreEvent(storage.NewTestEvent())
+	d = newTestDisk(t, cfg, path)
+	defer d.Close()
+
+	wantTotal = 3
+	gotTotal = d.TotalEvents()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+}
+
+func TestDiskStoreEventUnknownTest(t *testing.T) {
+	d := newTestDisk(t, storage.NewTestConfig(), filepath.Join(t.TempDir(), "boast.log"))
+	defer d.Close()
+
+	if err := d.StoreEvent(storage.NewTestEvent()); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+}
+
+func TestDiskRecoverConfiguration(t *testing.T) {
+	cfg := storage.NewTestConfig()
+	cfg.MaxArtifactsByTest = 2
+	cfg.MaxArtifactSize = 1024
+	path := filepath.Join(t.TempDir(), "boast.log")
+
+	d := newTestDisk(t, cfg, path)
+	id, _, err := d.SetTest(storage.TTest.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := app.Webhook{URL: "https://example.com/hook", Secret: "s3cr3t"}
+	if err := d.SetWebhook(id, want); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	wantRes := app.HTTPResponse{Status: 307, Location: "http://{{canary}}.example.com/"}
+	if err := d.SetHTTPResponse(id, &wantRes); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	wantRecs := []app.DNSRecord{{Type: "CNAME", Value: "internal.example.com."}}
+	if err := d.SetDNSRecords(id, wantRecs); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	wantRebinding := app.Rebinding{First: "203.0.113.77", Second: "127.0.0.1", Mode: app.RebindTime, Delay: time.Second}
+	if err := d.SetRebinding(id, &wantRebinding); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	wantArtifact := app.Artifact{Name: "x.js", ContentType: "text/javascript", Data: []byte("alert(1)")}
+	d.SetArtifact(id, wantArtifact)
+	d.SetArtifact(id, app.Artifact{Name: "deleted.js"})
+	if deleted, err := d.DeleteArtifact(id, "deleted.js"); err != nil || !deleted {
+		t.Fatalf("wrong deleted: %v (want) != %v (got) (error: %v)", true, deleted, err)
+	}
+
+	// The configuration survives both a crash and a compaction.
+	for i := 0; i < 2; i++ {
+		d = newTestDisk(t, cfg, path)
+		got, loaded := d.LoadWebhook(id)
+		if !loaded || want != got {
+			t.Errorf("wrong webhook: %v (want) != %v (got)", want, got)
+		}
+		gotRes, loaded := d.LoadHTTPResponse(id)
+		if !loaded || wantRes.Status != gotRes.Status || wantRes.Location != gotRes.Location {
+			t.Errorf("wrong response: %v (want) != %v (got)", wantRes, gotRes)
+		}
+		gotRecs, loaded := d.LoadDNSRecords(id)
+		if !loaded || !reflect.DeepEqual(wantRecs, gotRecs) {
+			t.Errorf("wrong records: %v (want) != %v (got)", wantRecs, gotRecs)
+		}
+		gotRebinding, loaded := d.LoadRebinding(id)
+		if !loaded || wantRebinding != gotRebinding {
+			t.Errorf("wrong rebinding: %v (want) != %v (got)", wantRebinding, gotRebinding)
+		}
+		gotArtifact, loaded := d.LoadArtifact(id, wantArtifact.Name)
+		if !loaded || !bytes.Equal(wantArtifact.Data, gotArtifact.Data) {
+			t.Errorf("wrong artifact: %v (want) != %v (got)", wantArtifact, gotArtifact)
+		}
+		if _, loaded := d.LoadArtifact(id, "deleted.js"); loaded {
+			t.Errorf("deleted artifact recovered")
+		}
+	}
+	d.Close()
+}
+
+func TestDiskSealedEvent(t *testing.T) {
+	cfg := storage.NewTestConfig()
+	cfg.MaxDumpSize = 1000
+	path := filepath.Join(t.TempDir(), "boast.log")
+
+	d := newTestDisk(t, cfg, path)
+	id, _, err := d.SetTest(storage.TTest.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{7}, app.KeySize)
+	if err := d.SetPublicKey(id, key); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	evt := storage.NewTestEvent()
+	evt.Dump = "PLAINTEXT CREDENTIALS"
+	if err := d.StoreEvent(evt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the sealed event is written to disk.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(evt.Dump)) {
+		t.Errorf("plaintext dump written to disk")
+	}
+
+	// The key survives a restart so new events are still sealed.
+	d = newTestDisk(t, cfg, path)
+	defer d.Close()
+	d.StoreEvent(storage.NewTestEvent())
+	evts, _ := d.LoadEvents(id)
+	for _, e := range evts {
+		if e.Sealed == "" {
+			t.Errorf("event not sealed after restart: %v", &e)
+		}
+	}
+}
+
+func TestDiskRecoverDeletes(t *testing.T) {
+	cfg := storage.NewTestConfig()
+	path := filepath.Join(t.TempDir(), "boast.log")
+
+	d := newTestDisk(t, cfg, path)
+	id, _, err := d.SetTest(storage.TTest.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var evts []app.Event
+	for i := 0; i < 3; i++ {
+		evt := storage.NewTestEvent()
+		d.StoreEvent(evt)
+		evts = append(evts, evt)
+	}
+	if deleted, err := d.DeleteEvent(id, evts[1].ID); err != nil || !deleted {
+		t.Fatalf("wrong deleted: %v (want) != %v (got) (error: %v)", true, deleted, err)
+	}
+
+	d = newTestDisk(t, cfg, path)
+	wantTotal := 2
+	gotTotal := d.TotalEvents()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total events: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+
+	if _, err := d.DeleteEvents(id); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	d = newTestDisk(t, cfg, path)
+	defer d.Close()
+	wantTotal = 0
+	gotTotal = d.TotalEvents()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total events: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+}
+
+func TestDiskCompactConcurrentChanges(t *testing.T) {
+	for _, syncInterval := range []time.Duration{0, time.Hour} {
+		cfg := storage.NewTestConfig()
+		cfg.SyncInterval = syncInterval
+		path := filepath.Join(t.TempDir(), "boast.log")
+
+		d := newTestDisk(t, cfg, path)
+		if _, _, err := d.SetTest(storage.TTest.Secret); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.StoreEvent(storage.NewTestEvent()); err != nil {
+			t.Fatal(err)
+		}
+
+		// The changes made while the new log is written must not be lost.
+		totalEvts := 3
+		err := d.CompactWhile(func() {
+			for i := 1; i < totalEvts; i++ {
+				evt := storage.NewTestEvent()
+				evt.ID = fmt.Sprintf("event%d", i)
+				if err := d.StoreEvent(evt); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+		}
+		// Not closing the storage simulates a crash.
+
+		d = newTestDisk(t, cfg, path)
+		if got := d.TotalEvents(); got != totalEvts {
+			t.Errorf("wrong total events: %v (want) != %v (got)", totalEvts, got)
+		}
+		d.Close()
+	}
+}
+
+func TestDiskRecoverConfiguredTime(t *testing.T) {
+	cfg := storage.NewTestConfig()
+	path := filepath.Join(t.TempDir(), "boast.log")
+
+	d := newTestDisk(t, cfg, path)
+	id, _, err := d.SetTest(storage.TTest.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetWebhook(id, app.Webhook{URL: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	want := d.Configured(id)
+
+	// Both the appended and the compacted records keep the configured time.
+	for _, compact := range []bool{false, true} {
+		if compact {
+			if err := d.Compact(); err != nil {
+				t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+			}
+		}
+		time.Sleep(time.Millisecond)
+		d = newTestDisk(t, cfg, path)
+		if got := d.Configured(id); !got.Equal(want) {
+			t.Errorf("wrong configured time: %v (want) != %v (got)", want, got)
+		}
+	}
+	d.Close()
+}
+
+func TestDiskRecoverTouchedTest(t *testing.T) {
+	cfg := storage.NewTestConfig()
+	cfg.TTL = 40 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "boast.log")
+
+	d := newTestDisk(t, cfg, path)
+	id, _, err := d.SetTest(storage.TTest.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetWebhook(id, app.Webhook{URL: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	// Using the test after a while logs its extended configured time.
+	time.Sleep(cfg.TTL / 2)
+	if _, _, err := d.SetTest(storage.TTest.Secret); err != nil {
+		t.Fatal(err)
+	}
+	want := d.Configured(id)
+
+	d = newTestDisk(t, cfg, path)
+	defer d.Close()
+	if got := d.Configured(id); !got.Equal(want) {
+		t.Errorf("wrong configured time: %v (want) != %v (got)", want, got)
+	}
+}
+
+func TestDiskRecoverClearedConfiguration(t *testing.T) {
+	cfg := storage.NewTestConfig()
+	path := filepath.Join(t.TempDir(), "boast.log")
+
+	d := newTestDisk(t, cfg, path)
+	id, _, err := d.SetTest(storage.TTest.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetWebhook(id, app.Webhook{URL: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetWebhook(id, app.Webhook{}); err != nil {
+		t.Fatal(err)
+	}
+	want := d.Configured(id)
+
+	// The compacted log keeps the configured time without any configuration left.
+	if err := d.Compact(); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	d = newTestDisk(t, cfg, path)
+	defer d.Close()
+	if got := d.Configured(id); !got.Equal(want) {
+		t.Errorf("wrong configured time: %v (want) != %v (got)", want, got)
+	}
+}
+
+func TestDiskBrokenLog(t *testing.T) {
+	cfg := storage.NewTestConfig()
+	path := filepath.Join(t.TempDir(), "boast.log")
+
+	d := newTestDisk(t, cfg, path)
+	d.SetTest(storage.TTest.Secret)
+	if err := d.BreakLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The log can be neither written nor truncated, so the changes fail until the log is
+	// compacted from the storage's state.
+	for i := 0; i < 2; i++ {
+		if err := d.StoreEvent(storage.NewTestEvent()); err == nil {
+			t.Errorf("did not fail: error (want) != %v (got)", err)
+		}
+	}
+	if err := d.Compact(); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	if err := d.StoreEvent(storage.NewTestEvent()); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	d = newTestDisk(t, cfg, path)
+	defer d.Close()
+	wantTotal := 3
+	gotTotal := d.TotalEvents()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"hash"
 	"math/rand"
+	"os"
 	"time"
 
 	app "github.com/ciphermarco/BOAST"
@@ -132,3 +133,49 @@ func RandBytes(l int) []byte {
 	}
 	return b
 }
+
+func (d *Disk) Compact() error {
+	return d.compact()
+}
+
+// CompactWhile compacts the log calling change after the state is written to the new log
+// but before it replaces the current one.
+func (d *Disk) CompactWhile(change func()) error {
+	d.cmu.Lock()
+	defer d.cmu.Unlock()
+	tmp := d.path + ".tmp"
+	defer os.Remove(tmp)
+	f, err := d.writeState(tmp)
+	change()
+	return d.replaceLog(tmp, f, err)
+}
+
+// BreakLog makes the writes to the log and its truncation fail by replacing its file
+// with a read-only one.
+func (d *Disk) BreakLog() error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	f, err := os.Open(d.path)
+	if err != nil {
+		return err
+	}
+	d.f.Close()
+	d.f = f
+	return nil
+}
+
+func (s *Storage) ExpireOnce() {
+	s.expireOnce()
+}
+
+func (s *Storage) Configured(id string) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tests[id].configured
+}
+
+func (s *Storage) TotalWaiters() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.waiters)
+}
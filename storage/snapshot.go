@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+)
+
+// snapshotVersion is the version of the snapshot format written by Snapshot.
+// It must be incremented whenever the format changes in a way older versions of Restore
+// can not read.
+const snapshotVersion = 1
+
+// snapshot represents the storage's state as written to a snapshot file.
+type snapshot struct {
+	Version int            `json:"version"`
+	Time    time.Time      `json:"time"`
+	Tests   []testSnapshot `json:"tests"`
+}
+
+// testSnapshot represents a test as written to a snapshot file.
+// Events are ordered by time so they can be pushed back to the events heap in order.
+type testSnapshot struct {
+	ID           string            `json:"id"`
+	Canary       string            `json:"canary"`
+	Events       []app.Event       `json:"events"`
+	Webhook      *app.Webhook      `json:"webhook,omitempty"`
+	PublicKey    []byte            `json:"publicKey,omitempty"`
+	HTTPResponse *app.HTTPResponse `json:"httpResponse,omitempty"`
+	Artifacts    []app.Artifact    `json:"artifacts,omitempty"`
+	DNSRecords   []app.DNSRecord   `json:"dnsRecords,omitempty"`
+	Rebinding    *app.Rebinding    `json:"rebinding,omitempty"`
+	// Configured is the test's configured time.
+	Configured time.Time `json:"configured"`
+}
+
+// Snapshot serializes all tests, canaries and events to w.
+func (s *Storage) Snapshot(w io.Writer) error {
+	s.mu.RLock()
+	snap := snapshot{
+		Version: snapshotVersion,
+		Time:    time.Now(),
+		Tests:   make([]testSnapshot, 0, len(s.tests)),
+	}
+	for id, t := range s.tests {
+		evts := make([]app.Event, t.events.Len())
+		copy(evts, *t.events)
+		sort.Slice(evts, func(i, j int) bool { return evts[i].Time.Before(evts[j].Time) })
+		ts := testSnapshot{
+			ID:         id,
+			Canary:     t.canary,
+			Events:     evts,
+			Configured: t.configured,
+		}
+		if t.webhook.URL != "" {
+			wh := t.webhook
+			ts.Webhook = &wh
+		}
+		if t.publicKey != nil {
+			ts.PublicKey = t.publicKey[:]
+		}
+		ts.HTTPResponse = t.httpResponse
+		for _, a := range t.artifacts {
+			ts.Artifacts = append(ts.Artifacts, a)
+		}
+		ts.DNSRecords = t.dnsRecords
+		ts.Rebinding = t.rebinding
+		snap.Tests = append(snap.Tests, ts)
+	}
+	s.mu.RUnlock()
+
+	return json.NewEncoder(w).Encode(&snap)
+}
+
+// Restore reloads the tests, canaries and events serialized by Snapshot from r.
+// Events whose TTL already elapsed are discarded and the configured limits are applied
+// as if the events were being stored again. The tests keep the time they were configured
+// so restoring them does not extend their TTL.
+func (s *Storage) Restore(r io.Reader) error {
+	var snap snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ts := range snap.Tests {
+		if _, exists := s.tests[ts.ID]; !exists {
+			if s.totalTests >= s.maxTests {
+				continue
+			}
+			s.unsafeAddTest(ts.ID, ts.Canary)
+		}
+		if ts.Webhook != nil {
+			s.unsafeSetWebhook(ts.ID, *ts.Webhook)
+		}
+		if ts.PublicKey != nil {
+			s.unsafeSetPublicKey(ts.ID, ts.PublicKey)
+		}
+		if ts.HTTPResponse != nil {
+			s.unsafeSetHTTPResponse(ts.ID, ts.HTTPResponse)
+		}
+		for _, a := range ts.Artifacts {
+			s.unsafeSetArtifact(ts.ID, a)
+		}
+		if len(ts.DNSRecords) > 0 {
+			s.unsafeSetDNSRecords(ts.ID, ts.DNSRecords)
+		}
+		if ts.Rebinding != nil {
+			s.unsafeSetRebinding(ts.ID, ts.Rebinding)
+		}
+		if t, exists := s.tests[ts.ID]; exists {
+			t.configured = ts.Configured
+			s.tests[ts.ID] = t
+		}
+		for _, evt := range ts.Events {
+			if time.Since(evt.Time) > s.cfg.TTL {
+				continue
+			}
+			s.unsafeStoreEvent(evt)
+		}
+	}
+	return nil
+}
+
+// SaveSnapshot writes a snapshot to the file in path.
+// The snapshot is written to a temporary file that atomically replaces the file in path
+// only after being synced to disk, so a failed write does not destroy a previous
+// snapshot.
+func (s *Storage) SaveSnapshot(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := s.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// LoadSnapshot restores the snapshot in the file in path.
+// A nonexistent file is not an error as there's nothing to be restored.
+func (s *Storage) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := s.Restore(f); err != nil {
+		return err
+	}
+	log.Info("Storage restored %d tests and %d events from %s",
+		s.TotalTests(), s.TotalEvents(), path)
+	return nil
+}
+
+// StartSnapshots writes a snapshot to the file in path at every interval.
+// Errors are only logged so a failed snapshot does not stop the server.
+func (s *Storage) StartSnapshots(path string, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.SaveSnapshot(path); err != nil {
+			log.Info("Could not save the storage snapshot")
+			log.Debug("Storage.SaveSnapshot error: %v", err)
+		}
+	}
+}
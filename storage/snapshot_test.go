@@ -0,0 +1,202 @@
+package storage_test
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/storage"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	env := newTestEnv()
+	env.strg.SetTest(storage.TTest.Secret)
+	env.strg.SetTest(storage.RandBytes(8))
+
+	// An expired event is discarded on restore.
+	expired := storage.NewTestEvent()
+	expired.Time = time.Now().Add(-2 * env.cfg.TTL)
+	env.strg.StoreEvent(expired)
+
+	totalEvts := env.cfg.MaxEventsByTest - 1
+	for i := 0; i < totalEvts; i++ {
+		env.strg.StoreEvent(storage.NewTestEvent())
+	}
+
+	var buf bytes.Buffer
+	if err := env.strg.Snapshot(&buf); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	restored := storage.NewTestStorage(env.cfg)
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	wantTotal := 2
+	gotTotal := restored.TotalTests()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total tests: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+
+	wantTotal = totalEvts
+	gotTotal = restored.TotalEvents()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total events: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+
+	wantID, wantCanary := storage.TTest.ID(), storage.TTest.Canary()
+	gotID, gotCanary := restored.LookupTest(wantID)
+	if wantID != gotID {
+		t.Errorf("wrong ID: %v (want) != %v (got)", wantID, gotID)
+	}
+	if wantCanary != gotCanary {
+		t.Errorf("wrong canary: %v (want) != %v (got)", wantCanary, gotCanary)
+	}
+
+	// The restored heap keeps expiring the oldest events first.
+	wantEvts, _ := env.strg.LoadEvents(wantID)
+	gotEvts, _ := restored.LoadEvents(wantID)
+	sortEvents(wantEvts)
+	if len(gotEvts) == 0 || !gotEvts[0].Time.Equal(wantEvts[1].Time) {
+		t.Errorf("wrong oldest event after restore")
+	}
+
+	// Per-test limits still hold after restoring.
+	for i := 0; i < env.cfg.MaxEventsByTest; i++ {
+		restored.StoreEvent(storage.NewTestEvent())
+	}
+	wantTotal = env.cfg.MaxEventsByTest
+	gotTotal = restored.TotalEvents()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total events: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+}
+
+func TestRestoreConfiguredTime(t *testing.T) {
+	env := newTestEnv()
+	now := time.Now()
+	old := now.Add(-2 * env.cfg.TTL).Format(time.RFC3339Nano)
+	test := fmt.Sprintf(`{"id":%q,"canary":%q,"events":[],"webhook":{"url":"https://example.com"}`,
+		storage.TTest.ID(), storage.TTest.Canary())
+
+	tests := []struct {
+		name     string
+		snapshot string
+		wantKept bool
+	}{
+		{
+			"configured before TTL",
+			fmt.Sprintf(`{"version":1,"time":%q,"tests":[%s,"configured":%q}]}`,
+				now.Format(time.RFC3339Nano), test, old),
+			false,
+		},
+		{
+			"configured within TTL",
+			fmt.Sprintf(`{"version":1,"time":%q,"tests":[%s,"configured":%q}]}`,
+				now.Format(time.RFC3339Nano), test, now.Format(time.RFC3339Nano)),
+			true,
+		},
+	}
+	for _, tt := range tests {
+		restored := storage.NewTestStorage(env.cfg)
+		if err := restored.Restore(strings.NewReader(tt.snapshot)); err != nil {
+			t.Fatalf("%s: unexpected error: %v (want) != %v (got)", tt.name, nil, err)
+		}
+		if got := restored.TotalTests(); got != 1 {
+			t.Fatalf("%s: wrong total tests: %v (want) != %v (got)", tt.name, 1, got)
+		}
+
+		restored.ExpireOnce()
+		want := 0
+		if tt.wantKept {
+			want = 1
+		}
+		if got := restored.TotalTests(); got != want {
+			t.Errorf("%s: wrong total tests: %v (want) != %v (got)", tt.name, want, got)
+		}
+	}
+}
+
+func TestRestoreWrongVersion(t *testing.T) {
+	env := newTestEnv()
+	snap := strings.NewReader(`{"version":0,"tests":[]}`)
+	if err := env.strg.Restore(snap); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+}
+
+func TestSaveLoadSnapshot(t *testing.T) {
+	env := newTestEnv()
+	env.cfg.MaxArtifactsByTest = 1
+	env.cfg.MaxArtifactSize = 1024
+	env.strg = storage.NewTestStorage(env.cfg)
+	path := filepath.Join(t.TempDir(), "boast.snapshot")
+
+	// A nonexistent snapshot has nothing to be restored.
+	if err := env.strg.LoadSnapshot(path); err != nil {
+		t.Errorf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	env.strg.SetTest(storage.TTest.Secret)
+	env.strg.StoreEvent(storage.NewTestEvent())
+	wantWebhook := app.Webhook{URL: "https://example.com/hook"}
+	env.strg.SetWebhook(storage.TTest.ID(), wantWebhook)
+	wantResponse := app.HTTPResponse{Body: "{{canary}}", Delay: time.Second}
+	env.strg.SetHTTPResponse(storage.TTest.ID(), &wantResponse)
+	wantArtifact := app.Artifact{Name: "x.svg", ContentType: "image/svg+xml", Data: []byte("<svg/>")}
+	env.strg.SetArtifact(storage.TTest.ID(), wantArtifact)
+	wantRecs := []app.DNSRecord{{Type: "AAAA", Value: "fd00::1", TTL: 5}}
+	env.strg.SetDNSRecords(storage.TTest.ID(), wantRecs)
+	wantRebinding := app.Rebinding{First: "203.0.113.77", Second: "127.0.0.1"}
+	env.strg.SetRebinding(storage.TTest.ID(), &wantRebinding)
+	if err := env.strg.SaveSnapshot(path); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	restored := storage.NewTestStorage(env.cfg)
+	if err := restored.LoadSnapshot(path); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	wantTotal := 1
+	gotTotal := restored.TotalEvents()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total events: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+
+	gotWebhook, _ := restored.LoadWebhook(storage.TTest.ID())
+	if wantWebhook != gotWebhook {
+		t.Errorf("wrong webhook: %v (want) != %v (got)", wantWebhook, gotWebhook)
+	}
+
+	gotResponse, _ := restored.LoadHTTPResponse(storage.TTest.ID())
+	if wantResponse.Body != gotResponse.Body || wantResponse.Delay != gotResponse.Delay {
+		t.Errorf("wrong response: %v (want) != %v (got)", wantResponse, gotResponse)
+	}
+
+	gotArtifact, _ := restored.LoadArtifact(storage.TTest.ID(), wantArtifact.Name)
+	if !bytes.Equal(wantArtifact.Data, gotArtifact.Data) {
+		t.Errorf("wrong artifact: %v (want) != %v (got)", wantArtifact, gotArtifact)
+	}
+
+	gotRecs, _ := restored.LoadDNSRecords(storage.TTest.ID())
+	if !reflect.DeepEqual(wantRecs, gotRecs) {
+		t.Errorf("wrong records: %v (want) != %v (got)", wantRecs, gotRecs)
+	}
+
+	gotRebinding, _ := restored.LoadRebinding(storage.TTest.ID())
+	if wantRebinding != gotRebinding {
+		t.Errorf("wrong rebinding: %v (want) != %v (got)", wantRebinding, gotRebinding)
+	}
+}
+
+func sortEvents(evts []app.Event) {
+	sort.Slice(evts, func(i, j int) bool { return evts[i].Time.Before(evts[j].Time) })
+}
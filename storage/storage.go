@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"hash"
+	"sort"
 	"sync"
 	"time"
 
@@ -23,6 +24,13 @@ type Config struct {
 	MaxEventsByTest int
 	MaxDumpSize     int
 	HMACKey         []byte
+	// MaxArtifactsByTest and MaxArtifactSize limit the artifacts stored for each test.
+	// Artifacts can not be stored if any of them is 0.
+	MaxArtifactsByTest int
+	MaxArtifactSize    int
+	// SyncInterval is the interval for syncing the disk backend's log to disk.
+	// If 0, the log is synced after every change.
+	SyncInterval time.Duration
 }
 
 // Storage represents the storage itself, holding its configurations and state.
@@ -34,15 +42,59 @@ type Storage struct {
 	totalEvents int
 	hmac        hash.Hash
 	cfg         Config
+	// waiters holds, for each test id, the waiter notified when a new event is stored
+	// for the test. Waiters are created on demand by WaitEvents and deleted when they
+	// are notified or all their callers cancel their waits.
+	waiters map[string]*waiter
+	// subscribers holds, for each test id, the subscriptions created by Subscribe.
+	subscribers map[string]map[*subscription]struct{}
+}
+
+// waiter represents the callers of WaitEvents waiting for a test's next event.
+type waiter struct {
+	// ch is closed when the next event is stored.
+	ch chan struct{}
+	// n is the number of callers waiting.
+	n int
+}
+
+// subscriberBufferLen is the number of events buffered for each subscription.
+// A subscription whose buffer is full when a new event is stored is ended so a slow
+// subscriber can never block the receivers storing events.
+const subscriberBufferLen = 64
+
+// subscription represents a subscription to a test's events.
+type subscription struct {
+	ch      chan app.Event
+	closed  bool // ch was closed because the subscriber fell behind
+	dropped int  // events not sent since ch was closed
 }
 
 // test represents a test of this application.
 // A test is identified by an id. It holds a canary token to be used in the response
 // from receivers when it may aid testing and recorded events for this test's id.
 type test struct {
-	id     string
-	canary string
-	events *eventHeap
+	id      string
+	canary  string
+	events  *eventHeap
+	webhook app.Webhook
+	// publicKey is the key the test's events are sealed to, if set.
+	publicKey *[app.KeySize]byte
+	// httpResponse is the response served by the HTTP receiver, if set.
+	httpResponse *app.HTTPResponse
+	// artifacts holds the test's artifacts by name. It's created on demand.
+	artifacts map[string]app.Artifact
+	// dnsRecords are the records served by the DNS receiver, if set.
+	dnsRecords []app.DNSRecord
+	// rebinding is the DNS receiver's rebinding policy, if set.
+	rebinding *app.Rebinding
+	// removed holds the ID and time of the last removed events, up to MaxEventsByTest,
+	// so the cursors referencing them still select the events after them.
+	removed []app.Event
+	// configured is the last time the test's webhook, public key, HTTP response,
+	// artifacts, DNS records or rebinding policy were set, or the test was used
+	// afterwards. Tests without events are kept until TTL elapses since then.
+	configured time.Time
 }
 
 // New contains the logic to construct and return a new *Storage according to the passed
@@ -67,25 +119,43 @@ func New(cfg *Config) (*Storage, error) {
 
 // SetTest creates a new test or fetches an existing one to return a newly generated or
 // already existing test id. In case of error, it returns the error to the caller.
+// An existing test's configuration is kept for another TTL, as its client still uses it.
 func (s *Storage) SetTest(secret []byte) (id string, canary string, err error) {
+	id, canary, created, err := s.setTest(secret)
+	if err == nil && !created {
+		s.touch(id)
+	}
+	return id, canary, err
+}
+
+// touch sets the test id's configured time to now if it was configured, so the
+// configuration (e.g. the public key) doesn't expire while the test is used. It returns
+// the previous configured time and reports whether it was changed.
+func (s *Storage) touch(id string) (prev time.Time, touched bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, exists := s.tests[id]
+	if !exists || t.configured.IsZero() {
+		return prev, false
+	}
+	prev, t.configured = t.configured, time.Now()
+	s.tests[id] = t
+	return prev, true
+}
+
+// setTest works like SetTest but also reports if the test was created by this call.
+func (s *Storage) setTest(secret []byte) (id string, canary string, created bool, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	sum := s.unsafeHmac(secret)
 	id, canary = app.ToBase32(sum[:len(sum)/2]), app.ToBase32(sum[len(sum)/2:])
 	if t, exists := s.tests[id]; exists {
-		return t.id, t.canary, nil
+		return t.id, t.canary, false, nil
 	} else if s.totalTests < s.maxTests {
-		events := &eventHeap{}
-		heap.Init(events)
-		s.tests[id] = test{
-			id:     id,
-			canary: canary,
-			events: events,
-		}
-		s.totalTests++
-		return id, canary, nil
+		s.unsafeAddTest(id, canary)
+		return id, canary, true, nil
 	}
-	return "", "", errors.New("could not create test")
+	return "", "", false, errors.New("could not create test")
 }
 
 // SearchTest receives a function to be run against each tests' id and canary, and
@@ -102,20 +172,67 @@ func (s *Storage) SearchTest(f func(k, v string) bool) (id string, canary string
 	return "", ""
 }
 
+// LookupTest tokenizes s into candidate test ids using boast.ScanIDs and returns the id
+// and canary of the first candidate that is a known test. If none of the candidates is
+// known empty strings are returned to the caller.
+//
+// Differently from SearchTest, each candidate is resolved with a map lookup so the cost
+// of a lookup depends only on the length of s and not on the number of stored tests.
+func (s *Storage) LookupTest(str string) (id string, canary string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	app.ScanIDs(str, func(candidate string) bool {
+		if t, exists := s.tests[candidate]; exists {
+			id, canary = t.id, t.canary
+			return true
+		}
+		return false
+	})
+	return id, canary
+}
+
 // StoreEvent appends an event to an existing test if it exists, otherwise it will
 // return an error to the caller.
 func (s *Storage) StoreEvent(evt app.Event) error {
+	_, err := s.storeEvent(evt)
+	return err
+}
+
+// storeEvent works like StoreEvent but also returns the event as stored.
+// If the test has a public key, the event is sealed to it before being stored so its
+// sensitive fields are never kept in plaintext.
+func (s *Storage) storeEvent(evt app.Event) (app.Event, error) {
+	s.mu.RLock()
+	t, exists := s.tests[evt.TestID]
+	s.mu.RUnlock()
+	if !exists {
+		return evt, fmt.Errorf("test id %s does not exist", evt.TestID)
+	}
+
+	if t.publicKey != nil {
+		// The dump is truncated before being sealed as it can not be truncated after.
+		truncate(&evt, s.cfg.MaxDumpSize)
+		// Sealing is done outside of the lock as it's relatively expensive.
+		if err := evt.Seal(t.publicKey); err != nil {
+			return evt, err
+		}
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return evt, s.unsafeStoreEvent(evt)
+}
+
+// unsafeStoreEvent works like StoreEvent leaving the mutex lock to the caller.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafeStoreEvent(evt app.Event) error {
 	id := evt.TestID
 	if t, exists := s.tests[id]; exists {
 		if s.cfg.MaxEvents > 0 && s.cfg.MaxEventsByTest > 0 && s.totalEvents <= s.cfg.MaxEvents {
 			if t.events.Len() >= s.cfg.MaxEventsByTest {
 				s.unsafePopEvent(id)
 			}
-			if len(evt.Dump) > s.cfg.MaxDumpSize {
-				evt.Dump = evt.Dump[:s.cfg.MaxDumpSize]
-			}
+			truncate(&evt, s.cfg.MaxDumpSize)
 			s.unsafePushEvent(id, evt)
 		}
 		return nil
@@ -123,6 +240,53 @@ func (s *Storage) StoreEvent(evt app.Event) error {
 	return fmt.Errorf("test id %s does not exist", id)
 }
 
+// DeleteEvents deletes all the events of the test id and returns how many were deleted.
+// The test itself is kept until it's expired like any other test without events.
+func (s *Storage) DeleteEvents(id string) (deleted int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unsafeDeleteEvents(id)
+}
+
+// unsafeDeleteEvents works like DeleteEvents leaving the mutex lock to the caller.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafeDeleteEvents(id string) (deleted int, err error) {
+	t, exists := s.tests[id]
+	if !exists {
+		return 0, fmt.Errorf("test id %s does not exist", id)
+	}
+	deleted = t.events.Len()
+	*t.events = eventHeap{}
+	s.totalEvents -= deleted
+	return deleted, nil
+}
+
+// DeleteEvent deletes the event evtID of the test id and reports whether it existed.
+// The test itself is kept until it's expired like any other test without events.
+func (s *Storage) DeleteEvent(id, evtID string) (deleted bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unsafeDeleteEvent(id, evtID)
+}
+
+// unsafeDeleteEvent works like DeleteEvent leaving the mutex lock to the caller.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafeDeleteEvent(id, evtID string) (deleted bool, err error) {
+	t, exists := s.tests[id]
+	if !exists {
+		return false, fmt.Errorf("test id %s does not exist", id)
+	}
+	for i := range *t.events {
+		if (*t.events)[i].ID == evtID {
+			evt := heap.Remove(t.events, i).(app.Event)
+			s.totalEvents--
+			s.unsafeAddRemoved(id, evt)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // LoadEvents returns the copy of an test's events slice if the test exists.
 func (s *Storage) LoadEvents(id string) (evts []app.Event, loaded bool) {
 	s.mu.RLock()
@@ -135,6 +299,353 @@ func (s *Storage) LoadEvents(id string) (evts []app.Event, loaded bool) {
 	return evts, false
 }
 
+// LoadEventsRange returns the copy of the range rng of a test's events if the test
+// exists. The events are ordered as defined by boast.Event.Before.
+//
+// Only the events in the range are copied, so it's cheaper than LoadEvents when the
+// caller only needs the newest events.
+func (s *Storage) LoadEventsRange(id string, rng app.EventsRange) (evts []app.Event, loaded bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, exists := s.tests[id]
+	if !exists {
+		return evts, false
+	}
+
+	var since *app.Event
+	if rng.SinceID != "" {
+		for i := range *t.events {
+			if (*t.events)[i].ID == rng.SinceID {
+				since = &(*t.events)[i]
+				break
+			}
+		}
+		// The event may have been evicted, expired, or deleted.
+		for i := range t.removed {
+			if since == nil && t.removed[i].ID == rng.SinceID {
+				since = &t.removed[i]
+			}
+		}
+	}
+
+	evts = []app.Event{}
+	for i := range *t.events {
+		evt := &(*t.events)[i]
+		if since != nil && !since.Before(evt) {
+			continue
+		}
+		if !rng.SinceTime.IsZero() && !evt.Time.After(rng.SinceTime) {
+			continue
+		}
+		evts = append(evts, *evt)
+	}
+	sort.Slice(evts, func(i, j int) bool { return evts[i].Before(&evts[j]) })
+	if rng.Limit > 0 && len(evts) > rng.Limit {
+		evts = evts[:rng.Limit]
+	}
+	return evts, true
+}
+
+// WaitEvents returns a channel that is closed when the next event for the test id is
+// stored or when the test is deleted, and a function to cancel the wait. Callers must
+// cancel the wait when they stop waiting, even if the channel was closed.
+//
+// Callers must get the channel before loading the events they already know of so no
+// event stored in between is missed.
+func (s *Storage) WaitEvents(id string) (notify <-chan struct{}, cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.waiters == nil {
+		s.waiters = make(map[string]*waiter)
+	}
+	w, exists := s.waiters[id]
+	if !exists {
+		w = &waiter{ch: make(chan struct{})}
+		s.waiters[id] = w
+	}
+	w.n++
+
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			w.n--
+			if w.n == 0 && s.waiters[id] == w {
+				delete(s.waiters, id)
+			}
+		})
+	}
+	return w.ch, cancel
+}
+
+// Subscribe returns a channel receiving each new event stored for the test id and a
+// function to cancel the subscription, closing the channel. If id is empty, the events
+// of all tests are received.
+//
+// The channel is buffered and is also closed if the subscriber falls behind and its
+// buffer gets full. The events published from then on are counted until the
+// subscription is canceled and cancel returns their number. Subscribers are expected to
+// resume from the last received event with LoadEventsRange if needed.
+func (s *Storage) Subscribe(id string) (evts <-chan app.Event, cancel func() (dropped int)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[string]map[*subscription]struct{})
+	}
+	if s.subscribers[id] == nil {
+		s.subscribers[id] = make(map[*subscription]struct{})
+	}
+	sub := &subscription{ch: make(chan app.Event, subscriberBufferLen)}
+	s.subscribers[id][sub] = struct{}{}
+
+	cancel = func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.unsafeUnsubscribe(id, sub)
+	}
+	return sub.ch, cancel
+}
+
+// SetWebhook sets the webhook to be notified of the test id's events.
+// A webhook with an empty URL removes the test's webhook.
+func (s *Storage) SetWebhook(id string, wh app.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unsafeSetWebhook(id, wh)
+}
+
+// unsafeSetWebhook works like SetWebhook leaving the mutex lock to the caller.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafeSetWebhook(id string, wh app.Webhook) error {
+	t, exists := s.tests[id]
+	if !exists {
+		return fmt.Errorf("test id %s does not exist", id)
+	}
+	t.webhook = wh
+	t.configured = time.Now()
+	s.tests[id] = t
+	return nil
+}
+
+// SetPublicKey sets the X25519 public key the test id's new events are sealed to.
+// An empty key removes the test's public key.
+func (s *Storage) SetPublicKey(id string, key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unsafeSetPublicKey(id, key)
+}
+
+// unsafeSetPublicKey works like SetPublicKey leaving the mutex lock to the caller.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafeSetPublicKey(id string, key []byte) error {
+	t, exists := s.tests[id]
+	if !exists {
+		return fmt.Errorf("test id %s does not exist", id)
+	}
+	switch len(key) {
+	case 0:
+		t.publicKey = nil
+	case app.KeySize:
+		t.publicKey = new([app.KeySize]byte)
+		copy(t.publicKey[:], key)
+	default:
+		return fmt.Errorf("public key must be %d bytes long", app.KeySize)
+	}
+	t.configured = time.Now()
+	s.tests[id] = t
+	return nil
+}
+
+// SetHTTPResponse sets the response served by the HTTP receiver to the test id's
+// requests. A nil response removes the test's response.
+func (s *Storage) SetHTTPResponse(id string, res *app.HTTPResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unsafeSetHTTPResponse(id, res)
+}
+
+// unsafeSetHTTPResponse works like SetHTTPResponse leaving the mutex lock to the caller.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafeSetHTTPResponse(id string, res *app.HTTPResponse) error {
+	t, exists := s.tests[id]
+	if !exists {
+		return fmt.Errorf("test id %s does not exist", id)
+	}
+	t.httpResponse = nil
+	if res != nil {
+		// The response is copied so it can not be changed by the caller afterwards.
+		r := *res
+		r.Headers = make(map[string]string, len(res.Headers))
+		for k, v := range res.Headers {
+			r.Headers[k] = v
+		}
+		t.httpResponse = &r
+	}
+	t.configured = time.Now()
+	s.tests[id] = t
+	return nil
+}
+
+// LoadHTTPResponse returns the response set for the test id if there's one.
+// The returned response must not be modified.
+func (s *Storage) LoadHTTPResponse(id string) (res app.HTTPResponse, loaded bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, exists := s.tests[id]; exists && t.httpResponse != nil {
+		return *t.httpResponse, true
+	}
+	return res, false
+}
+
+// SetArtifact stores the artifact a for the test id, replacing any artifact with the
+// same name. It returns boast.ErrArtifactLimit if the artifact is too large or the test
+// already has the maximum number of artifacts.
+func (s *Storage) SetArtifact(id string, a app.Artifact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unsafeSetArtifact(id, a)
+}
+
+// unsafeSetArtifact works like SetArtifact leaving the mutex lock to the caller.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafeSetArtifact(id string, a app.Artifact) error {
+	t, exists := s.tests[id]
+	if !exists {
+		return fmt.Errorf("test id %s does not exist", id)
+	}
+	if len(a.Data) > s.cfg.MaxArtifactSize {
+		return app.ErrArtifactLimit
+	}
+	if _, replaced := t.artifacts[a.Name]; !replaced && len(t.artifacts) >= s.cfg.MaxArtifactsByTest {
+		return app.ErrArtifactLimit
+	}
+	if t.artifacts == nil {
+		t.artifacts = make(map[string]app.Artifact)
+	}
+	// The data is copied so the caller can't modify the stored artifact.
+	a.Data = append([]byte(nil), a.Data...)
+	t.artifacts[a.Name] = a
+	t.configured = time.Now()
+	s.tests[id] = t
+	return nil
+}
+
+// LoadArtifact returns the test id's artifact with the given name if there's one.
+// The returned artifact's data must not be modified.
+func (s *Storage) LoadArtifact(id, name string) (a app.Artifact, loaded bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, exists := s.tests[id]; exists {
+		a, loaded = t.artifacts[name]
+	}
+	return a, loaded
+}
+
+// DeleteArtifact deletes the test id's artifact with the given name and reports whether
+// it existed.
+func (s *Storage) DeleteArtifact(id, name string) (deleted bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unsafeDeleteArtifact(id, name)
+}
+
+// unsafeDeleteArtifact works like DeleteArtifact leaving the mutex lock to the caller.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafeDeleteArtifact(id, name string) (deleted bool, err error) {
+	t, exists := s.tests[id]
+	if !exists {
+		return false, fmt.Errorf("test id %s does not exist", id)
+	}
+	if _, deleted = t.artifacts[name]; deleted {
+		delete(t.artifacts, name)
+	}
+	return deleted, nil
+}
+
+// SetDNSRecords sets the records served by the DNS receiver to the queries for the test
+// id's names. An empty recs removes the test's records.
+func (s *Storage) SetDNSRecords(id string, recs []app.DNSRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unsafeSetDNSRecords(id, recs)
+}
+
+// unsafeSetDNSRecords works like SetDNSRecords leaving the mutex lock to the caller.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafeSetDNSRecords(id string, recs []app.DNSRecord) error {
+	t, exists := s.tests[id]
+	if !exists {
+		return fmt.Errorf("test id %s does not exist", id)
+	}
+	t.dnsRecords = nil
+	if len(recs) > 0 {
+		// The records are copied so they can not be changed by the caller afterwards.
+		t.dnsRecords = make([]app.DNSRecord, len(recs))
+		copy(t.dnsRecords, recs)
+	}
+	t.configured = time.Now()
+	s.tests[id] = t
+	return nil
+}
+
+// LoadDNSRecords returns the records set for the test id if there are any.
+// The returned records must not be modified.
+func (s *Storage) LoadDNSRecords(id string) (recs []app.DNSRecord, loaded bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, exists := s.tests[id]; exists && len(t.dnsRecords) > 0 {
+		return t.dnsRecords, true
+	}
+	return recs, false
+}
+
+// SetRebinding sets the DNS receiver's rebinding policy for the test id's names.
+// A nil policy removes the test's policy.
+func (s *Storage) SetRebinding(id string, rb *app.Rebinding) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unsafeSetRebinding(id, rb)
+}
+
+// unsafeSetRebinding works like SetRebinding leaving the mutex lock to the caller.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafeSetRebinding(id string, rb *app.Rebinding) error {
+	t, exists := s.tests[id]
+	if !exists {
+		return fmt.Errorf("test id %s does not exist", id)
+	}
+	t.rebinding = nil
+	if rb != nil {
+		// The policy is copied so it can not be changed by the caller afterwards.
+		p := *rb
+		t.rebinding = &p
+	}
+	t.configured = time.Now()
+	s.tests[id] = t
+	return nil
+}
+
+// LoadRebinding returns the rebinding policy set for the test id if there's one.
+func (s *Storage) LoadRebinding(id string) (rb app.Rebinding, loaded bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, exists := s.tests[id]; exists && t.rebinding != nil {
+		return *t.rebinding, true
+	}
+	return rb, false
+}
+
+// LoadWebhook returns the webhook set for the test id if there's one.
+func (s *Storage) LoadWebhook(id string) (wh app.Webhook, loaded bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, exists := s.tests[id]; exists && t.webhook.URL != "" {
+		return t.webhook, true
+	}
+	return wh, false
+}
+
 // TotalTests returns the number of total tests recorded in the storage at the moment.
 func (s *Storage) TotalTests() int {
 	s.mu.RLock()
@@ -159,32 +670,37 @@ func (s *Storage) expire() (err error) {
 		return err
 	}()
 	for range time.Tick(s.cfg.CheckInterval) {
-		s.mu.RLock()
-		for id, t := range s.tests {
-			if t.events.Len() == 0 {
-				s.mu.RUnlock()
-				s.mu.Lock()
+		s.expireOnce()
+	}
+	return errors.New("storage expiration error")
+}
 
-				s.unsafeDeleteTest(id)
+// expireOnce deletes the expired events and the tests without events once.
+func (s *Storage) expireOnce() {
+	s.mu.RLock()
+	for id, t := range s.tests {
+		if s.unused(t) {
+			s.mu.RUnlock()
+			s.mu.Lock()
 
-				s.mu.Unlock()
-				s.mu.RLock()
-				continue
-			}
-			ttl := s.cfg.TTL
-			for t.events.Len() > 0 && time.Since((*t.events)[0].Time) > ttl {
-				s.mu.RUnlock()
-				s.mu.Lock()
+			s.unsafeDeleteTest(id)
 
-				s.unsafePopEvent(id)
+			s.mu.Unlock()
+			s.mu.RLock()
+			continue
+		}
+		ttl := s.cfg.TTL
+		for t.events.Len() > 0 && time.Since((*t.events)[0].Time) > ttl {
+			s.mu.RUnlock()
+			s.mu.Lock()
 
-				s.mu.Unlock()
-				s.mu.RLock()
-			}
+			s.unsafePopEvent(id)
+
+			s.mu.Unlock()
+			s.mu.RLock()
 		}
-		s.mu.RUnlock()
 	}
-	return errors.New("storage expiration error")
+	s.mu.RUnlock()
 }
 
 // StartExpire is used by the caller to start expiring events and, in case of a panic
@@ -209,25 +725,228 @@ func (s *Storage) unsafePushEvent(id string, evt app.Event) {
 	if t, exists := s.tests[id]; exists {
 		heap.Push(t.events, evt)
 		s.totalEvents++
+		s.unsafeNotify(id)
+		s.unsafePublish(id, evt)
 	}
-
 }
 
 // unsafePopEvent pops an event from an test's events leaving the mutex lock to the caller.
 // It's unsafe to be used without setting the appropriate lock externally.
 func (s *Storage) unsafePopEvent(id string) {
 	if t, exists := s.tests[id]; exists {
-		heap.Pop(t.events)
+		evt := heap.Pop(t.events).(app.Event)
 		s.totalEvents--
-		if t.events.Len() == 0 {
+		s.unsafeAddRemoved(id, evt)
+		if s.unused(t) {
 			s.unsafeDeleteTest(id)
 		}
 	}
 }
 
+// unsafeAddRemoved keeps the ID and time of the test id's removed event evt leaving the
+// mutex lock to the caller.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafeAddRemoved(id string, evt app.Event) {
+	t, exists := s.tests[id]
+	if !exists {
+		return
+	}
+	t.removed = append(t.removed, app.Event{ID: evt.ID, Time: evt.Time})
+	if over := len(t.removed) - s.cfg.MaxEventsByTest; over > 0 {
+		t.removed = append(t.removed[:0:0], t.removed[over:]...)
+	}
+	s.tests[id] = t
+}
+
+// unused reports whether the test t can be deleted because it has no events and its
+// webhook, public key, HTTP response or artifacts, if any, were not set within the TTL.
+func (s *Storage) unused(t test) bool {
+	return t.events.Len() == 0 && time.Since(t.configured) > s.cfg.TTL
+}
+
+// truncate limits the total size of the event's dump and protocol details to size
+// bytes. As the details duplicate parts of the dump, the ones which are small and the
+// most useful (e.g. the HTTP query and headers or the FTP commands) are kept first, then
+// the dump, and the HTTP body gets whatever is left. The details are copied so the
+// caller's are not changed.
+func truncate(evt *app.Event, size int) {
+	var n int
+	if evt.HTTP != nil {
+		info := *evt.HTTP
+		info.Query, n = fitValues(info.Query, size)
+		size -= n
+		info.Headers, n = fitValues(info.Headers, size)
+		size -= n
+		evt.HTTP = &info
+	}
+	if evt.SMTP != nil {
+		info := *evt.SMTP
+		size -= fitStrings(size, &info.Helo, &info.From)
+		info.To, n = fitList(info.To, size)
+		size -= n
+		evt.SMTP = &info
+	}
+	if evt.LDAP != nil {
+		info := *evt.LDAP
+		size -= fitStrings(size, &info.Operation, &info.DN)
+		evt.LDAP = &info
+	}
+	if evt.FTP != nil {
+		info := *evt.FTP
+		size -= fitStrings(size, &info.User, &info.Password)
+		info.Commands, n = fitList(info.Commands, size)
+		size -= n
+		info.DataAddrs, n = fitList(info.DataAddrs, size)
+		size -= n
+		evt.FTP = &info
+	}
+	if evt.ClientHello != nil {
+		info := *evt.ClientHello
+		size -= fitStrings(size, &info.ServerName, &info.JA3Hash, &info.JA4)
+		for _, l := range []*[]string{&info.Versions, &info.ALPN, &info.CipherSuites} {
+			*l, n = fitList(*l, size)
+			size -= n
+		}
+		size -= fitStrings(size, &info.JA3)
+		evt.ClientHello = &info
+	}
+
+	if len(evt.Dump) > size {
+		evt.Dump = evt.Dump[:size]
+	}
+	size -= len(evt.Dump)
+	if evt.HTTP != nil && len(evt.HTTP.Body) > size {
+		evt.HTTP.Body = evt.HTTP.Body[:size]
+	}
+}
+
+// fitStrings cuts the strings pointed by ss, in order, so their total size fits in size
+// bytes and returns it.
+func fitStrings(size int, ss ...*string) int {
+	total := 0
+	for _, s := range ss {
+		if len(*s) > size-total {
+			*s = (*s)[:size-total]
+		}
+		total += len(*s)
+	}
+	return total
+}
+
+// fitList returns the leading entries of l that fit in size bytes and their total size.
+func fitList(l []string, size int) ([]string, int) {
+	total := 0
+	for i, v := range l {
+		if total+len(v) > size {
+			return l[:i:i], total
+		}
+		total += len(v)
+	}
+	return l, total
+}
+
+// fitValues returns the entries of m, in the order of their keys, whose keys and values
+// fit in size bytes and their total size. m is returned as is if all of it fits.
+func fitValues(m map[string][]string, size int) (map[string][]string, int) {
+	total := 0
+	for k, vs := range m {
+		total += valuesSize(k, vs)
+	}
+	if total <= size {
+		return m, total
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fit := make(map[string][]string)
+	total = 0
+	for _, k := range keys {
+		if n := valuesSize(k, m[k]); total+n <= size {
+			fit[k] = m[k]
+			total += n
+		}
+	}
+	return fit, total
+}
+
+func valuesSize(k string, vs []string) int {
+	n := len(k)
+	for _, v := range vs {
+		n += len(v)
+	}
+	return n
+}
+
+// unsafeAddTest adds a new test without events leaving the mutex lock to the caller.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafeAddTest(id, canary string) {
+	events := &eventHeap{}
+	heap.Init(events)
+	s.tests[id] = test{
+		id:     id,
+		canary: canary,
+		events: events,
+	}
+	s.totalTests++
+}
+
 func (s *Storage) unsafeDeleteTest(id string) {
 	delete(s.tests, id)
 	s.totalTests--
+	s.unsafeNotify(id)
+}
+
+// unsafeNotify wakes up the callers waiting for changes to the test id's events leaving
+// the mutex lock to the caller.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafeNotify(id string) {
+	if w, exists := s.waiters[id]; exists {
+		close(w.ch)
+		delete(s.waiters, id)
+	}
+}
+
+// unsafePublish sends an event to the test id's subscribers leaving the mutex lock to
+// the caller. The channels of subscribers that can not receive it without blocking are
+// closed and the events they miss are counted until they unsubscribe.
+// It's unsafe to be used without setting the appropriate lock externally.
+func (s *Storage) unsafePublish(id string, evt app.Event) {
+	for _, subID := range []string{id, ""} {
+		for sub := range s.subscribers[subID] {
+			if sub.closed {
+				sub.dropped++
+				continue
+			}
+			select {
+			case sub.ch <- evt:
+			default:
+				log.Debug("Storage subscriber for test %q fell behind; closing its channel", subID)
+				close(sub.ch)
+				sub.closed = true
+				sub.dropped++
+			}
+		}
+	}
+}
+
+// unsafeUnsubscribe removes a subscription closing its channel and returns the number
+// of events it missed leaving the mutex lock to the caller. It's unsafe to be used
+// without setting the appropriate lock externally.
+func (s *Storage) unsafeUnsubscribe(id string, sub *subscription) (dropped int) {
+	if _, exists := s.subscribers[id][sub]; !exists {
+		return 0
+	}
+	if !sub.closed {
+		close(sub.ch)
+	}
+	delete(s.subscribers[id], sub)
+	if len(s.subscribers[id]) == 0 {
+		delete(s.subscribers, id)
+	}
+	return sub.dropped
 }
 
 // unsafeHmac uses the storage's hmac and passed bytes to return an HMAC'd sum.
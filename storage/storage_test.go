@@ -2,17 +2,23 @@ package storage_test
 
 import (
 	"container/heap"
+	crand "crypto/rand"
+	"errors"
+	"fmt"
 	"io/ioutil"
 
 	"math/rand"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	app "github.com/ciphermarco/BOAST"
 	"github.com/ciphermarco/BOAST/log"
 	"github.com/ciphermarco/BOAST/storage"
+
+	"golang.org/x/crypto/nacl/box"
 )
 
 type testEnv struct {
@@ -218,6 +224,45 @@ func TestSearchTest(t *testing.T) {
 	}
 }
 
+func TestLookupTest(t *testing.T) {
+	env := newTestEnv()
+
+	wantID, wantCanary := "", ""
+	gotID, gotCanary := env.strg.LookupTest(storage.TTest.ID() + ".example.com.")
+
+	if wantID != gotID {
+		t.Errorf("wrong ID: %v (want) != %v (got)", wantID, gotID)
+	}
+	if wantCanary != gotCanary {
+		t.Errorf("wrong canary: %v (want) != %v (got)", wantCanary, gotCanary)
+	}
+
+	env.strg.SetTest(storage.TTest.Secret)
+
+	rand.Seed(time.Now().UnixNano())
+	for i := 0; i < rand.Intn(11); i++ {
+		env.strg.SetTest(storage.RandBytes(8))
+	}
+
+	wantID, wantCanary = storage.TTest.ID(), storage.TTest.Canary()
+	for _, s := range []string{
+		wantID,
+		"sub." + wantID + ".example.com.",
+		strings.ToUpper(wantID) + ".example.com.",
+		"GET /" + wantID + " HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: " + wantID + ".example.com\r\n\r\n",
+	} {
+		gotID, gotCanary = env.strg.LookupTest(s)
+
+		if wantID != gotID {
+			t.Errorf("wrong ID: %v (want) != %v (got)", wantID, gotID)
+		}
+		if wantCanary != gotCanary {
+			t.Errorf("wrong canary: %v (want) != %v (got)", wantCanary, gotCanary)
+		}
+	}
+}
+
 func TestStoreEvent(t *testing.T) {
 	env := newTestEnv()
 	evt := storage.NewTestEvent()
@@ -294,6 +339,674 @@ func TestLoadEvents(t *testing.T) {
 	}
 }
 
+func TestLoadEventsRange(t *testing.T) {
+	env := newTestEnv()
+	env.strg.SetTest(storage.TTest.Secret)
+
+	now := time.Now()
+	var want []app.Event
+	for i := 0; i < env.strg.MaxEventsByTest(); i++ {
+		evt := storage.NewTestEvent()
+		evt.ID = app.ToBase32(storage.RandBytes(16))
+		// stored out of order to check the range is ordered
+		evt.Time = now.Add(time.Duration(i%3*10+i) * time.Second)
+		if err := env.strg.StoreEvent(evt); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, evt)
+	}
+	sortEvents(want)
+
+	check := func(rng app.EventsRange, want []app.Event) {
+		got, loaded := env.strg.LoadEventsRange(storage.TTest.ID(), rng)
+		if !loaded {
+			t.Errorf("response was not set as loaded: %v (want) != %v (got)", true, loaded)
+		}
+		if len(want) != len(got) {
+			t.Errorf("wrong length for %+v: %v (want) != %v (got)", rng, len(want), len(got))
+			return
+		}
+		for i := range want {
+			if want[i].ID != got[i].ID {
+				t.Errorf("wrong event %d for %+v: %v (want) != %v (got)", i, rng, want[i].ID, got[i].ID)
+			}
+		}
+	}
+
+	check(app.EventsRange{}, want)
+	check(app.EventsRange{Limit: 3}, want[:3])
+	check(app.EventsRange{SinceID: want[2].ID}, want[3:])
+	check(app.EventsRange{SinceID: want[2].ID, Limit: 2}, want[3:5])
+	check(app.EventsRange{SinceID: want[len(want)-1].ID}, []app.Event{})
+	check(app.EventsRange{SinceTime: want[4].Time}, want[5:])
+	// an unknown event ID is ignored
+	check(app.EventsRange{SinceID: "unknown"}, want)
+
+	_, loaded := env.strg.LoadEventsRange(string(storage.RandBytes(8)), app.EventsRange{})
+	if loaded {
+		t.Errorf("response was set as loaded: %v (want) != %v (got)", false, loaded)
+	}
+}
+
+func TestLoadEventsRangeRemovedCursor(t *testing.T) {
+	env := newTestEnv()
+	env.strg.SetTest(storage.TTest.Secret)
+
+	now := time.Now()
+	var stored []app.Event
+	store := func(n int) {
+		for i := 0; i < n; i++ {
+			evt := storage.NewTestEvent()
+			evt.ID = fmt.Sprintf("event%02d", len(stored))
+			evt.Time = now.Add(time.Duration(len(stored)) * time.Second)
+			if err := env.strg.StoreEvent(evt); err != nil {
+				t.Fatal(err)
+			}
+			stored = append(stored, evt)
+		}
+	}
+	check := func(since string, want []app.Event) {
+		got, _ := env.strg.LoadEventsRange(storage.TTest.ID(), app.EventsRange{SinceID: since})
+		var wantIDs, gotIDs []string
+		for _, evt := range want {
+			wantIDs = append(wantIDs, evt.ID)
+		}
+		for _, evt := range got {
+			gotIDs = append(gotIDs, evt.ID)
+		}
+		if !reflect.DeepEqual(wantIDs, gotIDs) {
+			t.Errorf("wrong events since %s: %v (want) != %v (got)", since, wantIDs, gotIDs)
+		}
+	}
+
+	// A client has seen the events up to the fourth one, which is then deleted.
+	maxEvts := env.strg.MaxEventsByTest()
+	store(maxEvts)
+	if _, err := env.strg.DeleteEvent(storage.TTest.ID(), stored[3].ID); err != nil {
+		t.Fatal(err)
+	}
+	check(stored[3].ID, stored[4:maxEvts])
+
+	// The next events evict the oldest ones, including a client's cursor.
+	store(4)
+	check(stored[1].ID, stored[4:])
+	check(stored[2].ID, stored[4:])
+	check(stored[3].ID, stored[4:])
+	check(stored[5].ID, stored[6:])
+}
+
+func TestWaitEvents(t *testing.T) {
+	env := newTestEnv()
+	env.strg.SetTest(storage.TTest.Secret)
+
+	notify, cancel := env.strg.WaitEvents(storage.TTest.ID())
+	defer cancel()
+	select {
+	case <-notify:
+		t.Fatal("notified without new events")
+	default:
+	}
+
+	if err := env.strg.StoreEvent(storage.NewTestEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-notify:
+	case <-time.After(time.Second):
+		t.Fatal("not notified of a new event")
+	}
+
+	// A new channel is returned for the next event.
+	notify, cancel = env.strg.WaitEvents(storage.TTest.ID())
+	defer cancel()
+	select {
+	case <-notify:
+		t.Fatal("notified without new events")
+	default:
+	}
+}
+
+func TestWaitEventsCancel(t *testing.T) {
+	env := newTestEnv()
+	env.strg.SetTest(storage.TTest.Secret)
+
+	// Waits that time out without new events don't leave waiters behind.
+	for i := 0; i < 1000; i++ {
+		_, cancel := env.strg.WaitEvents(storage.TTest.ID())
+		cancel()
+		cancel()
+	}
+	if got := env.strg.TotalWaiters(); got != 0 {
+		t.Errorf("wrong total waiters: %v (want) != %v (got)", 0, got)
+	}
+
+	// The channel shared by many callers is kept until all of them cancel their waits.
+	notify, cancel := env.strg.WaitEvents(storage.TTest.ID())
+	_, cancelOther := env.strg.WaitEvents(storage.TTest.ID())
+	cancelOther()
+	if got := env.strg.TotalWaiters(); got != 1 {
+		t.Errorf("wrong total waiters: %v (want) != %v (got)", 1, got)
+	}
+	if err := env.strg.StoreEvent(storage.NewTestEvent()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-notify:
+	case <-time.After(time.Second):
+		t.Fatal("not notified of a new event")
+	}
+	cancel()
+	if got := env.strg.TotalWaiters(); got != 0 {
+		t.Errorf("wrong total waiters: %v (want) != %v (got)", 0, got)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	env := newTestEnv()
+	env.strg.SetTest(storage.TTest.Secret)
+
+	evts, cancel := env.strg.Subscribe(storage.TTest.ID())
+	want := storage.NewTestEvent()
+	if err := env.strg.StoreEvent(want); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-evts:
+		if want.ID != got.ID {
+			t.Errorf("wrong event: %v (want) != %v (got)", want.ID, got.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not published")
+	}
+
+	cancel()
+	if _, ok := <-evts; ok {
+		t.Errorf("channel not closed: %v (want) != %v (got)", false, ok)
+	}
+	// Canceling twice is harmless.
+	cancel()
+}
+
+func TestSubscribeAll(t *testing.T) {
+	env := newTestEnv()
+	env.strg.SetTest(storage.TTest.Secret)
+
+	evts, cancel := env.strg.Subscribe("")
+	defer cancel()
+	want := storage.NewTestEvent()
+	if err := env.strg.StoreEvent(want); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-evts:
+		if want.ID != got.ID {
+			t.Errorf("wrong event: %v (want) != %v (got)", want.ID, got.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not published")
+	}
+}
+
+func TestSubscribeSlowSubscriber(t *testing.T) {
+	tCfg := storage.NewTestConfig()
+	tCfg.MaxEventsByTest = 1000
+	tCfg.MaxEvents = 1000
+	tStrg := storage.NewTestStorage(tCfg)
+	tStrg.SetTest(storage.TTest.Secret)
+
+	evts, cancel := tStrg.Subscribe(storage.TTest.ID())
+
+	// Storing must never block on a subscriber that does not receive its events.
+	for i := 0; i < 100; i++ {
+		if err := tStrg.StoreEvent(storage.NewTestEvent()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n := 0
+	for range evts {
+		n++
+	}
+	if n == 0 || n >= 100 {
+		t.Errorf("wrong number of buffered events: 0 < n < 100 (want) != %v (got)", n)
+	}
+	// Every event not received is counted as dropped.
+	if dropped := cancel(); n+dropped != 100 {
+		t.Errorf("wrong number of dropped events: %v (want) != %v (got)", 100-n, dropped)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	env := newTestEnv()
+	id := storage.TTest.ID()
+
+	wh := app.Webhook{URL: "https://example.com/hook", Secret: "s3cr3t"}
+	if err := env.strg.SetWebhook(id, wh); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+
+	env.strg.SetTest(storage.TTest.Secret)
+	if _, loaded := env.strg.LoadWebhook(id); loaded {
+		t.Errorf("wrong loaded: %v (want) != %v (got)", false, loaded)
+	}
+
+	if err := env.strg.SetWebhook(id, wh); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	got, loaded := env.strg.LoadWebhook(id)
+	if !loaded || wh != got {
+		t.Errorf("wrong webhook: %v (want) != %v (got)", wh, got)
+	}
+
+	// An empty URL removes the webhook.
+	env.strg.SetWebhook(id, app.Webhook{})
+	if _, loaded := env.strg.LoadWebhook(id); loaded {
+		t.Errorf("wrong loaded: %v (want) != %v (got)", false, loaded)
+	}
+}
+
+func TestHTTPResponse(t *testing.T) {
+	env := newTestEnv()
+	id := storage.TTest.ID()
+
+	res := &app.HTTPResponse{Status: 302, Headers: map[string]string{"X-Test": "test"}}
+	if err := env.strg.SetHTTPResponse(id, res); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+
+	env.strg.SetTest(storage.TTest.Secret)
+	if err := env.strg.SetHTTPResponse(id, res); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	// Changing the response afterwards does not change the stored one.
+	want := app.HTTPResponse{Status: 302, Headers: map[string]string{"X-Test": "test"}}
+	res.Headers["X-Test"] = "changed"
+	got, loaded := env.strg.LoadHTTPResponse(id)
+	if !loaded || !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong response: %v (want) != %v (got)", want, got)
+	}
+
+	env.strg.SetHTTPResponse(id, nil)
+	if _, loaded := env.strg.LoadHTTPResponse(id); loaded {
+		t.Errorf("wrong loaded: %v (want) != %v (got)", false, loaded)
+	}
+}
+
+func TestDNSRecords(t *testing.T) {
+	env := newTestEnv()
+	id := storage.TTest.ID()
+
+	recs := []app.DNSRecord{{Type: "A", Value: "10.0.0.1"}, {Type: "TXT", Value: "test", TTL: 60}}
+	if err := env.strg.SetDNSRecords(id, recs); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+
+	env.strg.SetTest(storage.TTest.Secret)
+	if err := env.strg.SetDNSRecords(id, recs); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	// Changing the records afterwards does not change the stored ones.
+	want := []app.DNSRecord{{Type: "A", Value: "10.0.0.1"}, {Type: "TXT", Value: "test", TTL: 60}}
+	recs[0].Value = "10.0.0.2"
+	got, loaded := env.strg.LoadDNSRecords(id)
+	if !loaded || !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong records: %v (want) != %v (got)", want, got)
+	}
+
+	env.strg.SetDNSRecords(id, nil)
+	if _, loaded := env.strg.LoadDNSRecords(id); loaded {
+		t.Errorf("wrong loaded: %v (want) != %v (got)", false, loaded)
+	}
+}
+
+func TestRebinding(t *testing.T) {
+	env := newTestEnv()
+	id := storage.TTest.ID()
+
+	rb := &app.Rebinding{First: "203.0.113.77", Second: "127.0.0.1", Mode: app.RebindCount, Count: 2}
+	if err := env.strg.SetRebinding(id, rb); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+
+	env.strg.SetTest(storage.TTest.Secret)
+	if err := env.strg.SetRebinding(id, rb); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	// Changing the policy afterwards does not change the stored one.
+	want := *rb
+	rb.Second = "10.0.0.1"
+	got, loaded := env.strg.LoadRebinding(id)
+	if !loaded || want != got {
+		t.Errorf("wrong rebinding: %v (want) != %v (got)", want, got)
+	}
+
+	env.strg.SetRebinding(id, nil)
+	if _, loaded := env.strg.LoadRebinding(id); loaded {
+		t.Errorf("wrong loaded: %v (want) != %v (got)", false, loaded)
+	}
+}
+
+func TestArtifact(t *testing.T) {
+	tCfg := storage.NewTestConfig()
+	tCfg.MaxArtifactsByTest = 2
+	tCfg.MaxArtifactSize = 8
+	tStrg := storage.NewTestStorage(tCfg)
+	id := storage.TTest.ID()
+
+	dtd := app.Artifact{Name: "evil.dtd", ContentType: "application/xml-dtd", Data: []byte("<!ENTITY")}
+	if err := tStrg.SetArtifact(id, dtd); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+
+	tStrg.SetTest(storage.TTest.Secret)
+	if err := tStrg.SetArtifact(id, dtd); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	got, loaded := tStrg.LoadArtifact(id, dtd.Name)
+	if !loaded || !reflect.DeepEqual(dtd, got) {
+		t.Errorf("wrong artifact: %v (want) != %v (got)", dtd, got)
+	}
+	// The stored artifact's data is not shared with the caller's.
+	dtd.Data[0] = 'X'
+	if got, _ := tStrg.LoadArtifact(id, dtd.Name); string(got.Data) != "<!ENTITY" {
+		t.Errorf("wrong data: %v (want) != %v (got)", "<!ENTITY", string(got.Data))
+	}
+	dtd.Data[0] = '<'
+
+	tooLarge := app.Artifact{Name: "large.js", Data: make([]byte, tCfg.MaxArtifactSize+1)}
+	if err := tStrg.SetArtifact(id, tooLarge); !errors.Is(err, app.ErrArtifactLimit) {
+		t.Errorf("wrong error: %v (want) != %v (got)", app.ErrArtifactLimit, err)
+	}
+
+	tStrg.SetArtifact(id, app.Artifact{Name: "x.js"})
+	if err := tStrg.SetArtifact(id, app.Artifact{Name: "y.js"}); !errors.Is(err, app.ErrArtifactLimit) {
+		t.Errorf("wrong error: %v (want) != %v (got)", app.ErrArtifactLimit, err)
+	}
+	// Replacing an artifact does not count towards the limit.
+	if err := tStrg.SetArtifact(id, app.Artifact{Name: "x.js"}); err != nil {
+		t.Errorf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	if deleted, _ := tStrg.DeleteArtifact(id, dtd.Name); !deleted {
+		t.Errorf("wrong deleted: %v (want) != %v (got)", true, deleted)
+	}
+	if _, loaded := tStrg.LoadArtifact(id, dtd.Name); loaded {
+		t.Errorf("wrong loaded: %v (want) != %v (got)", false, loaded)
+	}
+	if deleted, _ := tStrg.DeleteArtifact(id, dtd.Name); deleted {
+		t.Errorf("wrong deleted: %v (want) != %v (got)", false, deleted)
+	}
+}
+
+func TestStoreEventTruncate(t *testing.T) {
+	tCfg := storage.NewTestConfig()
+	tCfg.MaxDumpSize = 4
+	tStrg := storage.NewTestStorage(tCfg)
+	tStrg.SetTest(storage.TTest.Secret)
+
+	evt := storage.NewTestEvent()
+	evt.HTTP = &app.HTTPInfo{Method: "POST", Body: "TEST Body"}
+	if err := tStrg.StoreEvent(evt); err != nil {
+		t.Fatal(err)
+	}
+	evts, _ := tStrg.LoadEvents(storage.TTest.ID())
+	got := evts[0]
+
+	wantDump := evt.Dump[:tCfg.MaxDumpSize]
+	if wantDump != got.Dump {
+		t.Errorf("wrong dump: %v (want) != %v (got)", wantDump, got.Dump)
+	}
+	// The dump leaves no room for the body.
+	if got.HTTP.Body != "" {
+		t.Errorf("wrong body: %v (want) != %v (got)", "", got.HTTP.Body)
+	}
+	// The stored event's HTTP details are not shared with the caller's.
+	if evt.HTTP.Body != "TEST Body" {
+		t.Errorf("wrong caller's body: %v (want) != %v (got)", "TEST Body", evt.HTTP.Body)
+	}
+}
+
+func TestStoreEventTruncateTotal(t *testing.T) {
+	tCfg := storage.NewTestConfig()
+	tCfg.MaxDumpSize = 64
+	tStrg := storage.NewTestStorage(tCfg)
+	tStrg.SetTest(storage.TTest.Secret)
+
+	tests := []struct {
+		name     string
+		headers  map[string][]string
+		dump     string
+		body     string
+		wantBody string
+	}{
+		{"fits", map[string][]string{"A": {"1"}}, "small", "body", "body"},
+		{"body", map[string][]string{"A": {"1"}}, strings.Repeat("d", 40), strings.Repeat("b", 40), strings.Repeat("b", 20)},
+		{"dump", map[string][]string{"A": {"1"}}, strings.Repeat("d", 100), strings.Repeat("b", 100), ""},
+		{"headers", map[string][]string{"A": {strings.Repeat("h", 30)}, "B": {strings.Repeat("h", 40)}}, strings.Repeat("d", 100), strings.Repeat("b", 100), ""},
+	}
+	for _, tt := range tests {
+		evt := storage.NewTestEvent()
+		evt.Dump = tt.dump
+		evt.HTTP = &app.HTTPInfo{
+			Method:  "POST",
+			Query:   map[string][]string{"q": {"1"}},
+			Headers: tt.headers,
+			Body:    tt.body,
+		}
+		if err := tStrg.StoreEvent(evt); err != nil {
+			t.Fatal(err)
+		}
+		evts, _ := tStrg.LoadEvents(storage.TTest.ID())
+		got := evts[len(evts)-1]
+
+		size := len(got.Dump) + len(got.HTTP.Body)
+		for _, m := range []map[string][]string{got.HTTP.Query, got.HTTP.Headers} {
+			for k, vs := range m {
+				size += len(k)
+				for _, v := range vs {
+					size += len(v)
+				}
+			}
+		}
+		if size > tCfg.MaxDumpSize {
+			t.Errorf("wrong size for %s: <= %v (want) != %v (got)", tt.name, tCfg.MaxDumpSize, size)
+		}
+		if got.HTTP.Body != tt.wantBody {
+			t.Errorf("wrong body for %s: %v (want) != %v (got)", tt.name, tt.wantBody, got.HTTP.Body)
+		}
+		if len(got.HTTP.Query["q"]) != 1 {
+			t.Errorf("wrong query for %s: %v (want) != %v (got)", tt.name, evt.HTTP.Query, got.HTTP.Query)
+		}
+	}
+}
+
+func TestStoreEventTruncateDetails(t *testing.T) {
+	tCfg := storage.NewTestConfig()
+	tCfg.MaxDumpSize = 64
+	tStrg := storage.NewTestStorage(tCfg)
+	tStrg.SetTest(storage.TTest.Secret)
+
+	cmds := make([]string, 1000)
+	for i := range cmds {
+		cmds[i] = fmt.Sprintf("RETR /%03d", i)
+	}
+	evt := storage.NewTestEvent()
+	evt.Dump = strings.Repeat("d", 100)
+	evt.FTP = &app.FTPInfo{
+		User:      "anonymous",
+		Commands:  cmds,
+		DataAddrs: []string{"203.0.113.1:1024"},
+	}
+	evt.SMTP = &app.SMTPInfo{From: "a@example.com", To: []string{strings.Repeat("t", 100)}}
+	evt.ClientHello = &app.ClientHelloInfo{JA3: strings.Repeat("j", 100)}
+	if err := tStrg.StoreEvent(evt); err != nil {
+		t.Fatal(err)
+	}
+	evts, _ := tStrg.LoadEvents(storage.TTest.ID())
+	got := evts[0]
+
+	size := len(got.Dump) + len(got.FTP.User) + len(got.SMTP.From) + len(got.ClientHello.JA3)
+	for _, l := range [][]string{got.FTP.Commands, got.FTP.DataAddrs, got.SMTP.To} {
+		for _, v := range l {
+			size += len(v)
+		}
+	}
+	if size > tCfg.MaxDumpSize {
+		t.Errorf("wrong size: <= %v (want) != %v (got)", tCfg.MaxDumpSize, size)
+	}
+	// The leading commands are kept in order.
+	if len(got.FTP.Commands) == 0 || got.FTP.Commands[0] != cmds[0] {
+		t.Errorf("wrong commands: %v... (want) != %v (got)", cmds[0], got.FTP.Commands)
+	}
+	// The stored event's details are not shared with the caller's.
+	if len(evt.FTP.Commands) != len(cmds) || evt.ClientHello.JA3 != strings.Repeat("j", 100) {
+		t.Errorf("caller's details changed: %v", evt.FTP.Commands)
+	}
+}
+
+func TestStoreEventSealed(t *testing.T) {
+	tCfg := storage.NewTestConfig()
+	tCfg.MaxDumpSize = 4
+	tStrg := storage.NewTestStorage(tCfg)
+	tStrg.SetTest(storage.TTest.Secret)
+	id := storage.TTest.ID()
+
+	pub, priv, err := box.GenerateKey(crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tStrg.SetPublicKey(id, pub[:5]); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+	if err := tStrg.SetPublicKey(id, pub[:]); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+
+	want := storage.NewTestEvent()
+	if err := tStrg.StoreEvent(want); err != nil {
+		t.Fatal(err)
+	}
+	evts, _ := tStrg.LoadEvents(id)
+	got := evts[0]
+	if got.Dump != "" || got.RemoteAddr != "" || got.Sealed == "" {
+		t.Errorf("event not sealed: %v", &got)
+	}
+
+	if err := got.Open(pub, priv); err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	// The dump is truncated before being sealed.
+	wantDump := want.Dump[:tCfg.MaxDumpSize]
+	if wantDump != got.Dump {
+		t.Errorf("wrong dump: %v (want) != %v (got)", wantDump, got.Dump)
+	}
+	if want.RemoteAddr != got.RemoteAddr {
+		t.Errorf("wrong remote address: %v (want) != %v (got)", want.RemoteAddr, got.RemoteAddr)
+	}
+
+	// Removing the key stops sealing the new events.
+	tStrg.SetPublicKey(id, nil)
+	tStrg.StoreEvent(storage.NewTestEvent())
+	evts, _ = tStrg.LoadEventsRange(id, app.EventsRange{SinceID: want.ID})
+	if len(evts) != 1 || evts[0].Sealed != "" {
+		t.Errorf("event sealed without a key: %v", evts)
+	}
+}
+
+func TestDeleteEvents(t *testing.T) {
+	env := newTestEnv()
+	id := storage.TTest.ID()
+	if _, err := env.strg.DeleteEvents(id); err == nil {
+		t.Errorf("did not fail: error (want) != %v (got)", err)
+	}
+
+	env.strg.SetTest(storage.TTest.Secret)
+	other, _, _ := env.strg.SetTest(storage.RandBytes(8))
+	totalEvts := 3
+	for i := 0; i < totalEvts; i++ {
+		env.strg.StoreEvent(storage.NewTestEvent())
+	}
+	otherEvt := storage.NewTestEvent()
+	otherEvt.TestID = other
+	env.strg.StoreEvent(otherEvt)
+
+	deleted, err := env.strg.DeleteEvents(id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+	if totalEvts != deleted {
+		t.Errorf("wrong deleted: %v (want) != %v (got)", totalEvts, deleted)
+	}
+
+	// Only the test's events are deleted and the test itself is kept.
+	wantTotal := 1
+	gotTotal := env.strg.TotalEvents()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total events: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+	wantTotal = 2
+	gotTotal = env.strg.TotalTests()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total tests: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+
+	// The test keeps storing events.
+	if err := env.strg.StoreEvent(storage.NewTestEvent()); err != nil {
+		t.Errorf("unexpected error: %v (want) != %v (got)", nil, err)
+	}
+}
+
+func TestDeleteEvent(t *testing.T) {
+	env := newTestEnv()
+	env.strg.SetTest(storage.TTest.Secret)
+	id := storage.TTest.ID()
+
+	var evts []app.Event
+	for i := 0; i < 5; i++ {
+		evt := storage.NewTestEvent()
+		evt.Time = evt.Time.Add(time.Duration(i) * time.Second)
+		env.strg.StoreEvent(evt)
+		evts = append(evts, evt)
+	}
+
+	deleted, err := env.strg.DeleteEvent(id, evts[2].ID)
+	if err != nil || !deleted {
+		t.Fatalf("wrong deleted: %v (want) != %v (got) (error: %v)", true, deleted, err)
+	}
+	deleted, _ = env.strg.DeleteEvent(id, evts[2].ID)
+	if deleted {
+		t.Errorf("wrong deleted: %v (want) != %v (got)", false, deleted)
+	}
+
+	wantTotal := 4
+	gotTotal := env.strg.TotalEvents()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total events: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+
+	// The heap keeps its order after removing an event from its middle.
+	got, _ := env.strg.LoadEventsRange(id, app.EventsRange{})
+	want := []app.Event{evts[0], evts[1], evts[3], evts[4]}
+	for i := range want {
+		if want[i].ID != got[i].ID {
+			t.Errorf("wrong event: %v (want) != %v (got)", want[i].ID, got[i].ID)
+		}
+	}
+	env.strg.StoreEvent(storage.NewTestEvent())
+	for i := 0; i < env.cfg.MaxEventsByTest; i++ {
+		env.strg.StoreEvent(storage.NewTestEvent())
+	}
+	got, _ = env.strg.LoadEventsRange(id, app.EventsRange{})
+	for _, evt := range got {
+		if evt.ID == evts[0].ID {
+			t.Errorf("oldest event not evicted first")
+		}
+	}
+}
+
 func TestTotalTests(t *testing.T) {
 	env := newTestEnv()
 
@@ -364,6 +1077,33 @@ func TestExpiration(t *testing.T) {
 	check(wantTotal, tStrg.TotalTests())
 }
 
+func TestExpirationConfiguredTest(t *testing.T) {
+	tCfg := storage.NewTestConfig()
+	tCfg.TTL = 200 * time.Millisecond
+	tCfg.CheckInterval = 1 * time.Millisecond
+	tStrg := storage.NewTestStorage(tCfg)
+	tStrg.SetTest(storage.TTest.Secret)
+	tStrg.SetWebhook(storage.TTest.ID(), app.Webhook{URL: "https://example.com/hook"})
+
+	tErr := make(chan error, 1)
+	go tStrg.StartExpire(tErr)
+
+	// A test without events is kept while its webhook was set within the TTL.
+	time.Sleep(tStrg.TTL() / 4)
+	wantTotal := 1
+	gotTotal := tStrg.TotalTests()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+
+	time.Sleep(tStrg.TTL())
+	wantTotal = 0
+	gotTotal = tStrg.TotalTests()
+	if wantTotal != gotTotal {
+		t.Errorf("wrong total: %v (want) != %v (got)", wantTotal, gotTotal)
+	}
+}
+
 func TestHeapPushWithWrongType(t *testing.T) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -412,6 +1152,31 @@ func BenchmarkLoadEvents(b *testing.B) {
 	evtsBench, loadedBench = evts, loaded
 }
 
+func BenchmarkLoadEventsRange(b *testing.B) {
+	tCfg := storage.NewTestConfig()
+	tCfg.MaxEvents = 10_000
+	tCfg.MaxEventsByTest = 10_000
+	tStrg := storage.NewTestStorage(tCfg)
+	id, _, err := tStrg.SetTest(storage.TTest.Secret)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var last app.Event
+	for i := 0; i < tCfg.MaxEvents; i++ {
+		last = storage.NewTestEvent()
+		tStrg.StoreEvent(last)
+	}
+
+	var evts []app.Event
+	var loaded bool
+	for n := 0; n < b.N; n++ {
+		evts, loaded = tStrg.LoadEventsRange(id, app.EventsRange{SinceTime: last.Time.Add(-time.Nanosecond)})
+	}
+
+	evtsBench, loadedBench = evts, loaded
+}
+
 var idBench string
 var canaryBench string
 
@@ -444,3 +1209,104 @@ func BenchmarkSearchTest(b *testing.B) {
 	// call if the value is not used.
 	idBench, canaryBench = id, canary
 }
+
+// benchDump is a typical HTTP request dump to be used when benchmarking test lookups.
+var benchDump = "POST /api/v1/callback?u=https%3A%2F%2Ftarget.example.org%2Fredirect HTTP/1.1\r\n" +
+	"Host: callback.example.com\r\n" +
+	"Accept: text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8\r\n" +
+	"Accept-Encoding: gzip, deflate\r\n" +
+	"Content-Type: application/x-www-form-urlencoded\r\n" +
+	"User-Agent: Mozilla/5.0 (X11; Fedora; Linux x86_64; rv:79.0) Gecko/20100101 Firefox/79.0\r\n" +
+	"\r\n" +
+	strings.Repeat("data=ZXhhbXBsZSBwYXlsb2FkIGRhdGE%3D&", 20)
+
+func newBenchLookupStorage(b *testing.B, tests int) *storage.ExportStorage {
+	tCfg := storage.NewTestConfig()
+	tCfg.MaxEvents = tests
+	tCfg.MaxEventsByTest = 1
+	tStrg := storage.NewTestStorage(tCfg)
+	for i := 0; i < tStrg.MaxTests(); i++ {
+		if _, _, err := tStrg.SetTest(storage.RandBytes(16)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return tStrg
+}
+
+func benchmarkSearchTestDump(b *testing.B, tests int) {
+	tStrg := newBenchLookupStorage(b, tests)
+	f := func(key, value string) bool {
+		return strings.Contains(benchDump, key)
+	}
+
+	b.ResetTimer()
+	var id string
+	var canary string
+	for n := 0; n < b.N; n++ {
+		id, canary = tStrg.SearchTest(f)
+	}
+
+	idBench, canaryBench = id, canary
+}
+
+func benchmarkLookupTestDump(b *testing.B, tests int) {
+	tStrg := newBenchLookupStorage(b, tests)
+
+	b.ResetTimer()
+	var id string
+	var canary string
+	for n := 0; n < b.N; n++ {
+		id, canary = tStrg.LookupTest(benchDump)
+	}
+
+	idBench, canaryBench = id, canary
+}
+
+func BenchmarkSearchTestDump1K(b *testing.B)   { benchmarkSearchTestDump(b, 1_000) }
+func BenchmarkSearchTestDump10K(b *testing.B)  { benchmarkSearchTestDump(b, 10_000) }
+func BenchmarkSearchTestDump100K(b *testing.B) { benchmarkSearchTestDump(b, 100_000) }
+func BenchmarkLookupTestDump1K(b *testing.B)   { benchmarkLookupTestDump(b, 1_000) }
+func BenchmarkLookupTestDump10K(b *testing.B)  { benchmarkLookupTestDump(b, 10_000) }
+func BenchmarkLookupTestDump100K(b *testing.B) { benchmarkLookupTestDump(b, 100_000) }
+
+func TestSetTestKeepsConfiguration(t *testing.T) {
+	tCfg := storage.NewTestConfig()
+	tCfg.TTL = 50 * time.Millisecond
+	tStrg := storage.NewTestStorage(tCfg)
+	id, _, err := tStrg.SetTest(storage.TTest.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _, err := box.GenerateKey(crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tStrg.SetPublicKey(id, pub[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client keeps polling for longer than the TTL.
+	for end := time.Now().Add(2 * tCfg.TTL); time.Now().Before(end); {
+		time.Sleep(tCfg.TTL / 5)
+		tStrg.SetTest(storage.TTest.Secret)
+		tStrg.ExpireOnce()
+	}
+	if got := tStrg.TotalTests(); got != 1 {
+		t.Fatalf("wrong total tests: %v (want) != %v (got)", 1, got)
+	}
+	if err := tStrg.StoreEvent(storage.NewTestEvent()); err != nil {
+		t.Fatal(err)
+	}
+	evts, _ := tStrg.LoadEvents(id)
+	if len(evts) != 1 || evts[0].Sealed == "" {
+		t.Errorf("event not sealed: %v", evts)
+	}
+
+	// Without events nor polling, the test expires.
+	tStrg.DeleteEvents(id)
+	time.Sleep(tCfg.TTL + time.Millisecond)
+	tStrg.ExpireOnce()
+	if got := tStrg.TotalTests(); got != 0 {
+		t.Errorf("wrong total tests: %v (want) != %v (got)", 0, got)
+	}
+}
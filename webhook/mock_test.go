@@ -0,0 +1,119 @@
+package webhook_test
+
+import app "github.com/ciphermarco/BOAST"
+
+var tID = "mpqhomfbxab55m5de32mywvfoy"
+var tCanary = "k2b27meg7dfifvxuxmnfnm24oa"
+
+type mockStorage struct {
+	wh app.Webhook
+	// dropped makes the first subscription be closed as if it fell behind, missing
+	// dropped events.
+	dropped int
+}
+
+func (s *mockStorage) SetTest(secret []byte) (id string, canary string, err error) {
+	return tID, tCanary, nil
+}
+
+func (s *mockStorage) LoadEvents(id string) (evts []app.Event, loaded bool) {
+	return evts, false
+}
+
+func (s *mockStorage) LoadEventsRange(id string, rng app.EventsRange) (evts []app.Event, loaded bool) {
+	return evts, false
+}
+
+func (s *mockStorage) DeleteEvents(id string) (deleted int, err error) {
+	return 0, nil
+}
+
+func (s *mockStorage) DeleteEvent(id, evtID string) (deleted bool, err error) {
+	return false, nil
+}
+
+func (s *mockStorage) WaitEvents(id string) (notify <-chan struct{}, cancel func()) {
+	return nil, func() {}
+}
+
+func (s *mockStorage) Subscribe(id string) (evts <-chan app.Event, cancel func() (dropped int)) {
+	if s.dropped == 0 {
+		return nil, func() int { return 0 }
+	}
+	ch := make(chan app.Event)
+	close(ch)
+	n := s.dropped
+	s.dropped = 0
+	return ch, func() int { return n }
+}
+
+func (s *mockStorage) SetWebhook(id string, wh app.Webhook) error {
+	s.wh = wh
+	return nil
+}
+
+func (s *mockStorage) LoadWebhook(id string) (wh app.Webhook, loaded bool) {
+	return s.wh, id == tID && s.wh.URL != ""
+}
+
+func (s *mockStorage) SetPublicKey(id string, key []byte) error {
+	return nil
+}
+
+func (s *mockStorage) SetHTTPResponse(id string, res *app.HTTPResponse) error {
+	return nil
+}
+
+func (s *mockStorage) LoadHTTPResponse(id string) (res app.HTTPResponse, loaded bool) {
+	return res, false
+}
+
+func (s *mockStorage) SetArtifact(id string, a app.Artifact) error {
+	return nil
+}
+
+func (s *mockStorage) LoadArtifact(id, name string) (a app.Artifact, loaded bool) {
+	return a, false
+}
+
+func (s *mockStorage) DeleteArtifact(id, name string) (deleted bool, err error) {
+	return false, nil
+}
+
+func (s *mockStorage) SetDNSRecords(id string, recs []app.DNSRecord) error {
+	return nil
+}
+
+func (s *mockStorage) LoadDNSRecords(id string) (recs []app.DNSRecord, loaded bool) {
+	return recs, false
+}
+
+func (s *mockStorage) SetRebinding(id string, rb *app.Rebinding) error {
+	return nil
+}
+
+func (s *mockStorage) LoadRebinding(id string) (rb app.Rebinding, loaded bool) {
+	return rb, false
+}
+
+func (s *mockStorage) SearchTest(f func(k, v string) bool) (id string, canary string) {
+	return tID, tCanary
+}
+
+func (s *mockStorage) LookupTest(str string) (id string, canary string) {
+	return tID, tCanary
+}
+
+func (s *mockStorage) StoreEvent(evt app.Event) error {
+	return nil
+}
+
+func (s *mockStorage) TotalTests() int {
+	return 0
+}
+
+func (s *mockStorage) TotalEvents() int {
+	return 0
+}
+
+func (s *mockStorage) StartExpire(err chan error) {}
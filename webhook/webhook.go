@@ -0,0 +1,280 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/log"
+)
+
+const (
+	// SignatureHeader is the header holding the hex HMAC-SHA256 of the request's body
+	// keyed with the webhook's secret, prefixed by "sha256=".
+	SignatureHeader = "X-BOAST-Signature"
+	// EventHeader is the header holding the delivered event's ID.
+	EventHeader = "X-BOAST-Event"
+
+	defaultQueueSize = 1000
+	defaultWorkers   = 4
+	defaultBackoff   = time.Second
+	defaultTimeout   = 10 * time.Second
+	// maxBackoff caps the exponential backoff between retries.
+	maxBackoff = 5 * time.Minute
+)
+
+// Config represents the dispatcher's configurable options.
+type Config struct {
+	// URL is a global webhook notified of the events of all tests.
+	URL string
+	// Secret is the key used to sign the notifications sent to URL.
+	Secret string
+	// TestWebhooks allows tests to set their own webhooks.
+	TestWebhooks bool
+	QueueSize    int
+	Workers      int
+	MaxRetries   int
+	// Backoff is the time waited before the first retry. It's doubled at each retry.
+	Backoff time.Duration
+	Timeout time.Duration
+
+	// TestWebhooksAllowPrivate allows the tests' webhooks to reach non-public addresses
+	// (e.g. loopback, private, or link-local addresses). Otherwise, only the global
+	// webhook can reach them.
+	TestWebhooksAllowPrivate bool
+}
+
+// Dispatcher delivers the stored events to the webhooks.
+//
+// Deliveries are queued and sent by a pool of workers, so a slow webhook never blocks
+// the receivers storing the events. Deliveries that can not be queued because the queue
+// is full or that still fail after the maximum number of retries are dropped and
+// counted as dead letters, as are the stored events missed when the dispatcher falls
+// behind the storage. The tests' webhooks can only reach public addresses unless
+// Config.TestWebhooksAllowPrivate is set.
+type Dispatcher struct {
+	cfg         Config
+	strg        app.Storage
+	client      *http.Client
+	testClient  *http.Client
+	queue       chan *delivery
+	delivered   atomic.Int64
+	deadLetters atomic.Int64
+	done        chan struct{}
+	stop        sync.Once
+}
+
+// delivery represents an event to be delivered to a webhook.
+type delivery struct {
+	wh      app.Webhook
+	test    bool // wh is a test's webhook
+	evtID   string
+	body    []byte
+	retries int
+}
+
+// New returns a new Dispatcher for the events stored in strg.
+// Zero values in cfg are replaced by sensible defaults.
+func New(cfg *Config, strg app.Storage) *Dispatcher {
+	c := *cfg
+	if c.QueueSize <= 0 {
+		c.QueueSize = defaultQueueSize
+	}
+	if c.Workers <= 0 {
+		c.Workers = defaultWorkers
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = defaultBackoff
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	d := &Dispatcher{
+		cfg:        c,
+		strg:       strg,
+		client:     &http.Client{Timeout: c.Timeout},
+		testClient: newPublicClient(c.Timeout),
+		queue:      make(chan *delivery, c.QueueSize),
+		done:       make(chan struct{}),
+	}
+	if c.TestWebhooksAllowPrivate {
+		d.testClient = d.client
+	}
+	return d
+}
+
+// Start starts the workers and dispatches every event stored from now on.
+// It only returns after Stop is called.
+func (d *Dispatcher) Start() {
+	for i := 0; i < d.cfg.Workers; i++ {
+		go d.work()
+	}
+	log.Info("Webhook dispatcher: Started %d workers", d.cfg.Workers)
+	for {
+		evts, cancel := d.strg.Subscribe("")
+		stopped := d.dispatchAll(evts)
+		dropped := cancel()
+		if stopped {
+			return
+		}
+		d.deadLetters.Add(int64(dropped))
+		log.Info("Webhook dispatcher fell behind the stored events; %d were not delivered", dropped)
+	}
+}
+
+// Stop stops the dispatcher and its workers. The deliveries being sent are completed,
+// but the queued ones are dropped.
+func (d *Dispatcher) Stop() {
+	d.stop.Do(func() { close(d.done) })
+}
+
+// dispatchAll dispatches the events received from evts until it's closed or the
+// dispatcher is stopped, reporting whether it was stopped.
+func (d *Dispatcher) dispatchAll(evts <-chan app.Event) (stopped bool) {
+	for {
+		select {
+		case evt, ok := <-evts:
+			if !ok {
+				return false
+			}
+			d.Dispatch(evt)
+		case <-d.done:
+			return true
+		}
+	}
+}
+
+// Dispatch queues the delivery of evt to the global webhook and to its test's webhook.
+// It never blocks.
+func (d *Dispatcher) Dispatch(evt app.Event) {
+	var dls []*delivery
+	if d.cfg.URL != "" {
+		wh := app.Webhook{URL: d.cfg.URL, Secret: d.cfg.Secret}
+		dls = append(dls, &delivery{wh: wh, evtID: evt.ID})
+	}
+	if d.cfg.TestWebhooks {
+		if wh, loaded := d.strg.LoadWebhook(evt.TestID); loaded {
+			dls = append(dls, &delivery{wh: wh, test: true, evtID: evt.ID})
+		}
+	}
+	if len(dls) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(&evt)
+	if err != nil {
+		log.Debug("Webhook event marshal error: %v", err)
+		return
+	}
+	for _, dl := range dls {
+		dl.body = body
+		d.enqueue(dl)
+	}
+}
+
+// TestWebhooks reports whether tests are allowed to set their own webhooks.
+func (d *Dispatcher) TestWebhooks() bool {
+	return d.cfg.TestWebhooks
+}
+
+// Delivered returns the number of successful deliveries.
+func (d *Dispatcher) Delivered() int64 {
+	return d.delivered.Load()
+}
+
+// DeadLetters returns the number of deliveries and stored events dropped.
+func (d *Dispatcher) DeadLetters() int64 {
+	return d.deadLetters.Load()
+}
+
+func (d *Dispatcher) enqueue(dl *delivery) {
+	select {
+	case d.queue <- dl:
+	default:
+		d.deadLetters.Add(1)
+		log.Debug("Webhook queue is full; dropping event %s for %s", dl.evtID, dl.wh.URL)
+	}
+}
+
+func (d *Dispatcher) work() {
+	for {
+		select {
+		case dl := <-d.queue:
+			d.send(dl)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// send delivers dl scheduling a retry if it fails.
+func (d *Dispatcher) send(dl *delivery) {
+	err := d.deliver(dl)
+	if err == nil {
+		d.delivered.Add(1)
+		return
+	}
+	log.Debug("Webhook delivery of event %s to %s error: %v", dl.evtID, dl.wh.URL, err)
+	if dl.retries >= d.cfg.MaxRetries {
+		d.deadLetters.Add(1)
+		return
+	}
+	dl.retries++
+	time.AfterFunc(d.backoff(dl.retries), func() { d.enqueue(dl) })
+}
+
+// backoff returns the time to wait before the nth retry.
+func (d *Dispatcher) backoff(n int) time.Duration {
+	b := d.cfg.Backoff
+	for i := 1; i < n && b < maxBackoff; i++ {
+		b *= 2
+	}
+	if b > maxBackoff {
+		b = maxBackoff
+	}
+	return b
+}
+
+func (d *Dispatcher) deliver(dl *delivery) error {
+	req, err := http.NewRequest("POST", dl.wh.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "BOAST")
+	req.Header.Set(EventHeader, dl.evtID)
+	if dl.wh.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign([]byte(dl.wh.Secret), dl.body))
+	}
+
+	client := d.client
+	if dl.test {
+		client = d.testClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature of body keyed with secret as sent in SignatureHeader.
+// Receivers can verify a notification by comparing the header's value with the result
+// of Sign using hmac.Equal.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,254 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	app "github.com/ciphermarco/BOAST"
+	"github.com/ciphermarco/BOAST/webhook"
+)
+
+type request struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func newTestServer(status func(n int64) int) (*httptest.Server, <-chan request) {
+	reqs := make(chan request, 10)
+	var n atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		reqs <- request{path: r.URL.Path, header: r.Header, body: body}
+		w.WriteHeader(status(n.Add(1)))
+	}))
+	return srv, reqs
+}
+
+func newTestEvent() app.Event {
+	return app.Event{
+		ID:       "kbn6rqzf3pc3dz3fbzsmkmzezu",
+		Time:     time.Now().UTC(),
+		TestID:   tID,
+		Receiver: "HTTP",
+		Dump:     "TEST DUMP",
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	srv, reqs := newTestServer(func(n int64) int { return http.StatusOK })
+	defer srv.Close()
+
+	strg := &mockStorage{}
+	strg.SetWebhook(tID, app.Webhook{URL: srv.URL + "/test", Secret: "test secret"})
+	d := webhook.New(&webhook.Config{
+		URL:          srv.URL + "/global",
+		Secret:       "global secret",
+		TestWebhooks: true,
+		// The test server listens on a loopback address.
+		TestWebhooksAllowPrivate: true,
+	}, strg)
+	go d.Start()
+	defer d.Stop()
+
+	evt := newTestEvent()
+	d.Dispatch(evt)
+
+	secrets := map[string]string{"/global": "global secret", "/test": "test secret"}
+	for i := 0; i < 2; i++ {
+		req := <-reqs
+
+		var got app.Event
+		if err := json.Unmarshal(req.body, &got); err != nil {
+			t.Fatalf("unexpected error: %v (want) != %v (got)", nil, err)
+		}
+		if evt.ID != got.ID || evt.Dump != got.Dump {
+			t.Errorf("wrong event: %v (want) != %v (got)", evt, got)
+		}
+
+		wantEvtID := evt.ID
+		gotEvtID := req.header.Get(webhook.EventHeader)
+		if wantEvtID != gotEvtID {
+			t.Errorf("wrong event header: %v (want) != %v (got)", wantEvtID, gotEvtID)
+		}
+
+		wantSig := webhook.Sign([]byte(secrets[req.path]), req.body)
+		gotSig := req.header.Get(webhook.SignatureHeader)
+		if wantSig != gotSig {
+			t.Errorf("wrong signature: %v (want) != %v (got)", wantSig, gotSig)
+		}
+	}
+
+	waitFor(t, "deliveries", func() bool { return d.Delivered() == 2 })
+}
+
+func TestDispatchTestWebhooksDisabled(t *testing.T) {
+	srv, reqs := newTestServer(func(n int64) int { return http.StatusOK })
+	defer srv.Close()
+
+	strg := &mockStorage{}
+	strg.SetWebhook(tID, app.Webhook{URL: srv.URL})
+	d := webhook.New(&webhook.Config{}, strg)
+	go d.Start()
+	defer d.Stop()
+
+	d.Dispatch(newTestEvent())
+	select {
+	case <-reqs:
+		t.Errorf("delivered to a test's webhook with test webhooks disabled")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDispatchRetry(t *testing.T) {
+	// Fail twice before succeeding.
+	srv, reqs := newTestServer(func(n int64) int {
+		if n <= 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	defer srv.Close()
+
+	d := webhook.New(&webhook.Config{
+		URL:        srv.URL,
+		MaxRetries: 2,
+		Backoff:    time.Millisecond,
+	}, &mockStorage{})
+	go d.Start()
+	defer d.Stop()
+
+	d.Dispatch(newTestEvent())
+	for i := 0; i < 3; i++ {
+		<-reqs
+	}
+
+	waitFor(t, "delivery", func() bool { return d.Delivered() == 1 })
+	if got := d.DeadLetters(); got != 0 {
+		t.Errorf("wrong dead letters: %v (want) != %v (got)", 0, got)
+	}
+}
+
+func TestDispatchDeadLetter(t *testing.T) {
+	srv, reqs := newTestServer(func(n int64) int { return http.StatusInternalServerError })
+	defer srv.Close()
+
+	d := webhook.New(&webhook.Config{
+		URL:        srv.URL,
+		MaxRetries: 1,
+		Backoff:    time.Millisecond,
+	}, &mockStorage{})
+	go d.Start()
+	defer d.Stop()
+
+	d.Dispatch(newTestEvent())
+	for i := 0; i < 2; i++ {
+		<-reqs
+	}
+
+	waitFor(t, "dead letter", func() bool { return d.DeadLetters() == 1 })
+	if got := d.Delivered(); got != 0 {
+		t.Errorf("wrong deliveries: %v (want) != %v (got)", 0, got)
+	}
+}
+
+func TestDispatchQueueFull(t *testing.T) {
+	// Without starting the dispatcher, nothing is taken from the queue.
+	d := webhook.New(&webhook.Config{
+		URL:       "http://127.0.0.1:1",
+		QueueSize: 1,
+	}, &mockStorage{})
+
+	for i := 0; i < 3; i++ {
+		d.Dispatch(newTestEvent())
+	}
+
+	var want int64 = 2
+	if got := d.DeadLetters(); want != got {
+		t.Errorf("wrong dead letters: %v (want) != %v (got)", want, got)
+	}
+}
+
+func TestDispatchTestWebhookNotPublic(t *testing.T) {
+	srv, reqs := newTestServer(func(n int64) int { return http.StatusOK })
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, whURL := range []string{
+		srv.URL,
+		"http://localhost:" + u.Port(),
+		"http://[::1]:" + u.Port(),
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[fe80::1]/",
+		"http://10.0.0.1/",
+	} {
+		strg := &mockStorage{}
+		strg.SetWebhook(tID, app.Webhook{URL: whURL})
+		d := webhook.New(&webhook.Config{
+			URL:          srv.URL + "/global",
+			TestWebhooks: true,
+		}, strg)
+		go d.Start()
+
+		// The global webhook is trusted, but the test's can't reach the server.
+		d.Dispatch(newTestEvent())
+		waitFor(t, "dead letter", func() bool { return d.DeadLetters() == 1 })
+		if req := <-reqs; req.path != "/global" {
+			t.Errorf("wrong path: %v (want) != %v (got)", "/global", req.path)
+		}
+		waitFor(t, "delivery", func() bool { return d.Delivered() == 1 })
+		d.Stop()
+		select {
+		case req := <-reqs:
+			t.Errorf("delivered to %s: %v", whURL, req.path)
+		default:
+		}
+	}
+}
+
+func TestDispatchFellBehind(t *testing.T) {
+	// The subscription is closed after missing 3 events.
+	d := webhook.New(&webhook.Config{}, &mockStorage{dropped: 3})
+	go d.Start()
+	defer d.Stop()
+
+	waitFor(t, "dead letters", func() bool { return d.DeadLetters() == 3 })
+}
+
+func TestDispatchStop(t *testing.T) {
+	d := webhook.New(&webhook.Config{}, &mockStorage{})
+	done := make(chan struct{})
+	go func() {
+		d.Start()
+		close(done)
+	}()
+
+	d.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatcher not stopped")
+	}
+	// Stopping twice is harmless.
+	d.Stop()
+}